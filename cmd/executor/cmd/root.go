@@ -17,35 +17,51 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/buildcontext"
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
 	"github.com/GoogleContainerTools/kaniko/pkg/executor"
+	"github.com/GoogleContainerTools/kaniko/pkg/image"
 	"github.com/GoogleContainerTools/kaniko/pkg/logging"
+	"github.com/GoogleContainerTools/kaniko/pkg/policy"
+	"github.com/GoogleContainerTools/kaniko/pkg/provenance"
+	"github.com/GoogleContainerTools/kaniko/pkg/sbom"
+	"github.com/GoogleContainerTools/kaniko/pkg/signing"
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/genuinetools/bpfd/proc"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
 
+// TimeoutExitCode is the exit code of a build which exceeded --timeout or --run-timeout
+const TimeoutExitCode = 124
+
 var (
-	opts         = &config.KanikoOptions{}
-	ctxSubPath   string
-	force        bool
-	logLevel     string
-	logFormat    string
-	logTimestamp bool
+	opts            = &config.KanikoOptions{}
+	buildSource     provenance.ConfigSource
+	ctxSubPath      string
+	sourceDateEpoch string
+	force           bool
+	logLevel        string
+	logFormat       string
+	logTimestamp    bool
 )
 
 func init() {
@@ -81,46 +97,118 @@ var RootCmd = &cobra.Command{
 				return err
 			}
 
-			if !opts.NoPush && len(opts.Destinations) == 0 {
+			hasDestinations := len(opts.Destinations) > 0 || len(opts.TargetDestinations) > 0
+			if err := executor.CheckOutputs(opts); err != nil {
+				return errors.Wrap(err, "output flags invalid")
+			}
+			if len(opts.Outputs) > 0 && !hasDestinations && !opts.NoPush {
+				logrus.Info("Only writing the outputs, as no destination is set")
+				opts.NoPush = true
+			}
+			if err := executor.CheckTargets(opts); err != nil {
+				return errors.Wrap(err, "target flags invalid")
+			}
+			if !opts.NoPush && !opts.DryRun && !hasDestinations {
 				return errors.New("You must provide --destination, or use --no-push")
 			}
 			if err := cacheFlagsValid(); err != nil {
 				return errors.Wrap(err, "cache flags invalid")
 			}
+			if err := executor.CheckCompression(opts); err != nil {
+				return errors.Wrap(err, "compression flags invalid")
+			}
+			if err := executor.CheckOnFailureSnapshot(opts); err != nil {
+				return errors.Wrap(err, "on failure snapshot flag invalid")
+			}
+			if err := dockerfile.ValidNetwork(opts.RunNetwork); err != nil {
+				return errors.Wrap(err, "run network flag invalid")
+			}
+			if opts.MaxParallelStages < 1 {
+				return errors.New("--max-parallel-stages must be at least 1")
+			}
+			epoch, err := resolveSourceDateEpoch(sourceDateEpoch, cmd.Flags().Changed("source-date-epoch"), opts.Reproducible, os.Getenv)
+			if err != nil {
+				return err
+			}
+			opts.SourceDateEpoch = epoch
 			if err := resolveSourceContext(); err != nil {
 				return errors.Wrap(err, "error resolving source context")
 			}
 			if err := resolveDockerfilePath(); err != nil {
 				return errors.Wrap(err, "error resolving dockerfile path")
 			}
-			if len(opts.Destinations) == 0 && opts.ImageNameDigestFile != "" {
+			if opts.EventsFile != "" {
+				if err := events.Open(opts.EventsFile); err != nil {
+					return errors.Wrap(err, "opening events file")
+				}
+			}
+			if len(opts.Platforms) > 0 && opts.CustomPlatform != "" {
+				return errors.New("--customPlatform and --platform are mutually exclusive")
+			}
+			if opts.PushProvenance && (opts.NoPush || !hasDestinations) {
+				return errors.New("You must provide --destination and push the image if setting --push-provenance")
+			}
+			if opts.SignKey != "" {
+				if opts.NoPush || !hasDestinations {
+					return errors.New("You must provide --destination and push the image if setting --sign-key")
+				}
+				if _, err := signing.LoadSigner(opts.SignKey); err != nil {
+					return errors.Wrap(err, "loading signing key")
+				}
+			}
+			if opts.BaseImagePolicy != "" {
+				if _, err := policy.ReadBaseImagePolicy(opts.BaseImagePolicy); err != nil {
+					return err
+				}
+			}
+			if opts.ImagePolicy != "" {
+				if _, err := policy.ReadImagePolicy(opts.ImagePolicy); err != nil {
+					return err
+				}
+			} else if opts.ImagePolicyReport != "" {
+				return errors.New("You must provide --image-policy if setting --image-policy-report")
+			}
+			if opts.Lockfile != "" {
+				if _, err := image.ReadLockfile(opts.Lockfile); err != nil {
+					return err
+				}
+			}
+			if opts.VerifyBaseImageKey != "" {
+				if _, err := signing.LoadPublicKey(opts.VerifyBaseImageKey); err != nil {
+					return errors.Wrap(err, "loading base image verification key")
+				}
+			}
+			if opts.SBOMFormat != "" {
+				if err := sbom.ValidFormat(opts.SBOMFormat); err != nil {
+					return err
+				}
+				if opts.SBOMFile == "" && (opts.NoPush || !hasDestinations) {
+					return errors.New("You must provide --sbom-file if setting --sbom without pushing the image")
+				}
+			} else if opts.SBOMFile != "" {
+				return errors.New("You must provide --sbom if setting --sbom-file")
+			}
+			if !hasDestinations && opts.ImageNameDigestFile != "" {
 				return errors.New("You must provide --destination if setting ImageNameDigestFile")
 			}
-			if len(opts.Destinations) == 0 && opts.ImageNameTagDigestFile != "" {
+			if !hasDestinations && opts.ImageNameTagDigestFile != "" {
 				return errors.New("You must provide --destination if setting ImageNameTagDigestFile")
 			}
 			// Update ignored paths
-			if opts.IgnoreVarRun {
-				// /var/run is a special case. It's common to mount in /var/run/docker.sock
-				// or something similar which leads to a special mount on the /var/run/docker.sock
-				// file itself, but the directory to exist in the image with no way to tell if it came
-				// from the base image or not.
-				logrus.Trace("Adding /var/run to default ignore list")
-				util.AddToDefaultIgnoreList(util.IgnoreListEntry{
-					Path:            "/var/run",
-					PrefixMatchOnly: false,
-				})
-			}
-			for _, p := range opts.IgnorePaths {
-				util.AddToDefaultIgnoreList(util.IgnoreListEntry{
-					Path:            p,
-					PrefixMatchOnly: false,
-				})
-			}
+			util.AddOptionsToDefaultIgnoreList(opts)
 		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		defer closeEventsFile()
+		if opts.DryRun {
+			// Nothing is extracted or executed, only a remote build context is unpacked
+			// into the kaniko directory, so this is safe outside of a container
+			if err := printPlan(); err != nil {
+				exit(errors.Wrap(err, "error planning build"))
+			}
+			return
+		}
 		if !checkContained() {
 			if !force {
 				exit(errors.New("kaniko should only be run inside of a container, run with the --force flag if you are sure you want to continue"))
@@ -128,8 +216,10 @@ var RootCmd = &cobra.Command{
 			logrus.Warn("kaniko is being run outside of a container. This can have dangerous effects on your system")
 		}
 		if !opts.NoPush || opts.CacheRepo != "" {
-			if err := executor.CheckPushPermissions(opts); err != nil {
-				exit(errors.Wrap(err, "error checking push permissions -- make sure you entered the correct tag name, and that you are authenticated correctly, and try again"))
+			for _, pushOpts := range pushOptions() {
+				if err := executor.CheckPushPermissions(pushOpts); err != nil {
+					exit(errors.Wrap(err, "error checking push permissions -- make sure you entered the correct tag name, and that you are authenticated correctly, and try again"))
+				}
 			}
 		}
 		if err := resolveRelativePaths(); err != nil {
@@ -138,42 +228,136 @@ var RootCmd = &cobra.Command{
 		if err := os.Chdir("/"); err != nil {
 			exit(errors.Wrap(err, "error changing to root dir"))
 		}
-		image, err := executor.DoBuild(opts)
-		if err != nil {
-			exit(errors.Wrap(err, "error building image"))
-		}
-		if err := executor.DoPush(image, opts); err != nil {
-			exit(errors.Wrap(err, "error pushing image"))
+		ctx, cancel := cancelOnSignal(context.Background())
+		defer cancel()
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
 		}
-
-		benchmarkFile := os.Getenv("BENCHMARK_FILE")
-		// false is a keyword for integration tests to turn off benchmarking
-		if benchmarkFile != "" && benchmarkFile != "false" {
-			s, err := timing.JSON()
+		build := provenance.Build{Source: buildSource, StartedOn: time.Now()}
+		if executor.MultiTarget(opts) {
+			images, err := executor.DoMultiTargetBuild(ctx, opts)
 			if err != nil {
-				logrus.Warnf("Unable to write benchmark file: %s", err)
-				return
+				exit(errors.Wrap(err, "error building images"))
+			}
+			build.FinishedOn = time.Now()
+			if err := executor.DoLockfile(opts); err != nil {
+				exit(err)
 			}
-			if strings.HasPrefix(benchmarkFile, "gs://") {
-				logrus.Info("uploading to gcs")
-				if err := buildcontext.UploadToBucket(strings.NewReader(s), benchmarkFile); err != nil {
-					logrus.Infof("Unable to upload %s due to %v", benchmarkFile, err)
+			for _, target := range opts.Targets {
+				targetOpts := executor.TargetOptions(opts, target)
+				if err := executor.DoPush(ctx, images[target], targetOpts); err != nil {
+					exit(errors.Wrapf(err, "error pushing image of target %s", target))
+				}
+				if err := executor.DoSBOM(ctx, images[target], targetOpts); err != nil {
+					exit(errors.Wrapf(err, "error generating SBOM of target %s", target))
 				}
-				logrus.Infof("benchmark file written at %s", benchmarkFile)
-			} else {
-				f, err := os.Create(benchmarkFile)
-				if err != nil {
-					logrus.Warnf("Unable to create benchmarking file %s: %s", benchmarkFile, err)
-					return
+				if err := executor.DoProvenance(ctx, images[target], targetOpts, build); err != nil {
+					exit(errors.Wrapf(err, "error generating provenance of target %s", target))
 				}
-				defer f.Close()
-				f.WriteString(s)
-				logrus.Infof("benchmark file written at %s", benchmarkFile)
 			}
+			writeBenchmarkFile()
+			return
+		}
+		var built partial.Describable
+		if len(opts.Platforms) > 0 {
+			index, err := executor.DoMultiPlatformBuild(ctx, opts)
+			if err != nil {
+				exit(errors.Wrap(err, "error building image index"))
+			}
+			build.FinishedOn = time.Now()
+			if err := executor.DoLockfile(opts); err != nil {
+				exit(err)
+			}
+			if err := executor.DoPushIndex(ctx, index, opts); err != nil {
+				exit(errors.Wrap(err, "error pushing image index"))
+			}
+			built = index
+		} else {
+			image, err := executor.DoBuild(ctx, opts)
+			if err != nil {
+				exit(errors.Wrap(err, "error building image"))
+			}
+			build.FinishedOn = time.Now()
+			if err := executor.DoLockfile(opts); err != nil {
+				exit(err)
+			}
+			if err := executor.DoPush(ctx, image, opts); err != nil {
+				exit(errors.Wrap(err, "error pushing image"))
+			}
+			built = image
+		}
+		if err := executor.DoSBOM(ctx, built, opts); err != nil {
+			exit(errors.Wrap(err, "error generating SBOM"))
 		}
+		if err := executor.DoProvenance(ctx, built, opts, build); err != nil {
+			exit(errors.Wrap(err, "error generating provenance"))
+		}
+
+		writeBenchmarkFile()
 	},
 }
 
+// pushOptions returns the options of every image that is pushed, one per target
+// when several targets are built
+func pushOptions() []*config.KanikoOptions {
+	if !executor.MultiTarget(opts) || opts.NoPush {
+		return []*config.KanikoOptions{opts}
+	}
+	var targetsOpts []*config.KanikoOptions
+	for _, target := range opts.Targets {
+		targetsOpts = append(targetsOpts, executor.TargetOptions(opts, target))
+	}
+	return targetsOpts
+}
+
+// printPlan prints the plan of the build as JSON to stdout
+func printPlan() error {
+	ctx, cancel := cancelOnSignal(context.Background())
+	defer cancel()
+	plan, err := executor.DoDryRun(ctx, opts)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// writeBenchmarkFile writes the timings collected so far to $BENCHMARK_FILE
+func writeBenchmarkFile() {
+	benchmarkFile := os.Getenv("BENCHMARK_FILE")
+	// false is a keyword for integration tests to turn off benchmarking
+	if benchmarkFile == "" || benchmarkFile == "false" {
+		return
+	}
+	s, err := timing.JSON()
+	if err != nil {
+		logrus.Warnf("Unable to write benchmark file: %s", err)
+		return
+	}
+	if strings.HasPrefix(benchmarkFile, "gs://") {
+		logrus.Info("uploading to gcs")
+		if err := buildcontext.UploadToBucket(strings.NewReader(s), benchmarkFile); err != nil {
+			logrus.Infof("Unable to upload %s due to %v", benchmarkFile, err)
+		}
+		logrus.Infof("benchmark file written at %s", benchmarkFile)
+	} else {
+		f, err := os.Create(benchmarkFile)
+		if err != nil {
+			logrus.Warnf("Unable to create benchmarking file %s: %s", benchmarkFile, err)
+			return
+		}
+		defer f.Close()
+		f.WriteString(s)
+		logrus.Infof("benchmark file written at %s", benchmarkFile)
+	}
+}
+
 // addKanikoOptionsFlags configures opts
 func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.DockerfilePath, "dockerfile", "f", "Dockerfile", "Path to the dockerfile to be built.")
@@ -182,8 +366,13 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().StringVarP(&opts.Bucket, "bucket", "b", "", "Name of the GCS bucket from which to access build context as tarball.")
 	RootCmd.PersistentFlags().VarP(&opts.Destinations, "destination", "d", "Registry the final image should be pushed to. Set it repeatedly for multiple destinations.")
 	RootCmd.PersistentFlags().StringVarP(&opts.SnapshotMode, "snapshotMode", "", "full", "Change the file attributes inspected during snapshotting")
+	RootCmd.PersistentFlags().StringVarP(&opts.Compression, "compression", "", constants.CompressionGzip, "Compression of the layers kaniko creates, gzip, estargz or zstd.")
+	RootCmd.PersistentFlags().IntVarP(&opts.CompressionLevel, "compression-level", "", 0, "Compression level of the layers kaniko creates, from 1 (fastest) to 9 (smallest). Defaults to 1.")
 	RootCmd.PersistentFlags().StringVarP(&opts.CustomPlatform, "customPlatform", "", "", "Specify the build platform if different from the current host")
+	RootCmd.PersistentFlags().VarP(&opts.Platforms, "platform", "", "Build for this platform in the os/arch[/variant] format and push an image index. Set it repeatedly for multiple platforms.")
 	RootCmd.PersistentFlags().VarP(&opts.BuildArgs, "build-arg", "", "This flag allows you to pass in ARG values at build time. Set it repeatedly for multiple values.")
+	RootCmd.PersistentFlags().StringVarP(&opts.RunNetwork, "run-network", "", dockerfile.NetworkDefault, "Network of the RUN commands which don't set --network, none or default.")
+	RootCmd.PersistentFlags().VarP(&opts.Secrets, "secret", "", "Secret for RUN --mount=type=secret, as id=<id>,src=<path> or id=<id>,env=<variable>. Set it repeatedly for multiple secrets.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Insecure, "insecure", "", false, "Push to insecure registry using plain HTTP")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SkipTLSVerify, "skip-tls-verify", "", false, "Push to insecure registry ignoring TLS verify")
 	RootCmd.PersistentFlags().BoolVarP(&opts.InsecurePull, "insecure-pull", "", false, "Pull from insecure registry using plain HTTP")
@@ -192,15 +381,39 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().IntVar(&opts.ImageFSExtractRetry, "image-fs-extract-retry", 0, "Number of retries for image FS extraction")
 	RootCmd.PersistentFlags().StringVarP(&opts.TarPath, "tarPath", "", "", "Path to save the image in as a tarball instead of pushing")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SingleSnapshot, "single-snapshot", "", false, "Take a single snapshot at the end of the build.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.Squash, "squash", "", false, "Squash the layers every stage adds on top of its base image into a single layer.")
+	RootCmd.PersistentFlags().VarP(&opts.SquashStages, "squash-stage", "", "Squash the layers the stage with this name adds on top of its base image into a single layer. Set it repeatedly for multiple stages.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Reproducible, "reproducible", "", false, "Strip timestamps out of the image to make it reproducible")
-	RootCmd.PersistentFlags().StringVarP(&opts.Target, "target", "", "", "Set the target build stage to build")
+	RootCmd.PersistentFlags().StringVarP(&sourceDateEpoch, "source-date-epoch", "", "", "Clamp the timestamps of the files in the layers kaniko creates and set the image creation time to these seconds since the Unix epoch. Defaults to $SOURCE_DATE_EPOCH when --reproducible is set.")
+	RootCmd.PersistentFlags().VarP(&opts.Targets, "target", "", "Set the target build stage to build. Set it repeatedly to build several targets at once.")
+	RootCmd.PersistentFlags().VarP(&opts.TargetDestinations, "destination-for", "", "Registry the image of a target should be pushed to, as target=destination. Set it repeatedly for multiple targets or destinations.")
+	RootCmd.PersistentFlags().VarP(&opts.Outputs, "output", "", "Export the filesystem of the target, or of another stage, as type=local,dest=<dir> or type=tar,dest=<file> with an optional stage=<name>. Set it repeatedly for multiple outputs.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.NoPush, "no-push", "", false, "Do not push the image to the registry")
+	RootCmd.PersistentFlags().BoolVarP(&opts.DryRun, "dry-run", "", false, "Print the build plan with the cache key of every command and whether it would hit the cache, without building or pushing anything. A remote build context is still unpacked into the kaniko directory")
 	RootCmd.PersistentFlags().StringVarP(&opts.CacheRepo, "cache-repo", "", "", "Specify a repository to use as a cache, otherwise one will be inferred from the destination provided")
 	RootCmd.PersistentFlags().StringVarP(&opts.CacheDir, "cache-dir", "", "/cache", "Specify a local directory to use as a cache.")
+	RootCmd.PersistentFlags().StringVarP(&opts.CacheMountDir, "cache-mount-dir", "", filepath.Join(config.KanikoDir, "cache-mounts"), "Keep the directories of RUN --mount=type=cache in this directory. Mount a volume there to keep them across builds.")
 	RootCmd.PersistentFlags().StringVarP(&opts.DigestFile, "digest-file", "", "", "Specify a file to save the digest of the built image to.")
 	RootCmd.PersistentFlags().StringVarP(&opts.ImageNameDigestFile, "image-name-with-digest-file", "", "", "Specify a file to save the image name w/ digest of the built image to.")
 	RootCmd.PersistentFlags().StringVarP(&opts.ImageNameTagDigestFile, "image-name-tag-with-digest-file", "", "", "Specify a file to save the image name w/ image tag w/ digest of the built image to.")
-	RootCmd.PersistentFlags().StringVarP(&opts.OCILayoutPath, "oci-layout-path", "", "", "Path to save the OCI image layout of the built image.")
+	RootCmd.PersistentFlags().StringVarP(&opts.OCILayoutPath, "oci-layout-path", "", "", "Path to the OCI image layout the built image is added to, named after every destination.")
+	RootCmd.PersistentFlags().StringVarP(&opts.Lockfile, "lockfile", "", "", "Path to a lockfile written with --lockfile-out. The images of the build are retrieved by the digests it pins them to, and the build fails if one is missing.")
+	RootCmd.PersistentFlags().StringVarP(&opts.LockfileOut, "lockfile-out", "", "", "Path to write the digests the images of the build resolved to, by reference and platform, as a lockfile for --lockfile.")
+	RootCmd.PersistentFlags().StringVarP(&opts.BaseImagePolicy, "base-image-policy", "", "", "Path to a JSON policy restricting the registries and repositories base images may come from, and whether they must be referenced by digest.")
+	RootCmd.PersistentFlags().StringVarP(&opts.ImagePolicy, "image-policy", "", "", "Path to a JSON policy of rules the config and the filesystem of the built image are checked against before it is pushed.")
+	RootCmd.PersistentFlags().StringVarP(&opts.ImagePolicyReport, "image-policy-report", "", "", "Path to write the results of the rules of --image-policy to as JSON.")
+	RootCmd.PersistentFlags().StringVarP(&opts.LayerReport, "layer-report", "", "", "Path to write a JSON report of the layers of the image to, attributing each to the command which created it with the files it changed.")
+	RootCmd.PersistentFlags().VarP(&opts.MaxLayerSize, "max-layer-size", "", "Fail the build if a layer is larger than this compressed size, e.g. 500MB, listing its largest files.")
+	RootCmd.PersistentFlags().VarP(&opts.MaxImageSize, "max-image-size", "", "Fail the build if the image is larger than this compressed size, e.g. 1GB, listing its largest layers.")
+	RootCmd.PersistentFlags().StringVarP(&opts.BaseImageLayout, "base-image-layout", "", "", "Path to an OCI image layout to look up base images in by name before pulling them, e.g. one written with --oci-layout-path.")
+	RootCmd.PersistentFlags().StringVarP(&opts.OnFailureSnapshot, "on-failure-snapshot", "", "", "Save the filesystem of a failed command as an image to a tarball (tar:<path>), an OCI layout (oci:<path>) or a registry (docker://<reference>).")
+	RootCmd.PersistentFlags().StringVarP(&opts.ProvenanceFile, "provenance-file", "", "", "Write the in-toto provenance of the built image to this file.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.PushProvenance, "push-provenance", "", false, "Push the in-toto provenance of the built image next to it to every destination.")
+	RootCmd.PersistentFlags().StringVarP(&opts.SBOMFormat, "sbom", "", "", "Generate an SBOM of the built image in this format (spdx or cyclonedx) and push it next to the image.")
+	RootCmd.PersistentFlags().StringVarP(&opts.SBOMFile, "sbom-file", "", "", "Write the SBOM generated with --sbom to this file.")
+	RootCmd.PersistentFlags().StringVarP(&opts.SignKey, "sign-key", "", "", "Sign the pushed image with the ECDSA or ed25519 private key in this PEM file and push a cosign compatible signature next to it.")
+	RootCmd.PersistentFlags().StringVarP(&opts.VerifyBaseImageKey, "verify-base-image-key", "", "", "Fail the build unless every base image has a cosign compatible signature by the public key in this PEM file.")
+	RootCmd.PersistentFlags().StringVarP(&opts.EventsFile, "events-file", "", "", "Write the build events as JSON Lines to this file, e.g. /dev/fd/3 for an inherited file descriptor.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Cache, "cache", "", false, "Use cache when building image")
 	RootCmd.PersistentFlags().BoolVarP(&opts.Cleanup, "cleanup", "", false, "Clean the filesystem at the end")
 	RootCmd.PersistentFlags().DurationVarP(&opts.CacheTTL, "cache-ttl", "", time.Hour*336, "Cache timeout in hours. Defaults to two weeks.")
@@ -211,11 +424,17 @@ func addKanikoOptionsFlags() {
 	RootCmd.PersistentFlags().VarP(&opts.RegistryMirrors, "registry-mirror", "", "Registry mirror to use as pull-through cache instead of docker.io. Set it repeatedly for multiple mirrors.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.IgnoreVarRun, "ignore-var-run", "", true, "Ignore /var/run directory when taking image snapshot. Set it to false to preserve /var/run/ in destination image. (Default true).")
 	RootCmd.PersistentFlags().VarP(&opts.Labels, "label", "", "Set metadata for an image. Set it repeatedly for multiple labels.")
+	opts.Annotations = make(map[string]string)
+	RootCmd.PersistentFlags().VarP(&opts.Annotations, "annotation", "", "Set an annotation on the manifest of the image, and on the image index with --platform. Expected format is 'key=value'. Set it repeatedly for multiple annotations.")
+	RootCmd.PersistentFlags().BoolVarP(&opts.OCIMetadata, "oci-metadata", "", false, "Annotate the manifest with the standard OCI metadata: the creation time, the base image and, for git contexts, the source and revision.")
+	RootCmd.PersistentFlags().IntVarP(&opts.MaxParallelStages, "max-parallel-stages", "", 1, "Maximum number of stages built at the same time. Stages other than the targets are built concurrently in their own root, which needs the CAP_SYS_ADMIN capability.")
 	RootCmd.PersistentFlags().BoolVarP(&opts.SkipUnusedStages, "skip-unused-stages", "", false, "Build only used stages if defined to true. Otherwise it builds by default all stages, even the unnecessaries ones until it reaches the target stage / end of Dockerfile")
 	RootCmd.PersistentFlags().BoolVarP(&opts.RunV2, "use-new-run", "", false, "Use the experimental run implementation for detecting changes without requiring file system snapshots.")
 	RootCmd.PersistentFlags().Var(&opts.Git, "git", "Branch to clone if build context is a git repository")
 	RootCmd.PersistentFlags().BoolVarP(&opts.CacheCopyLayers, "cache-copy-layers", "", false, "Caches copy layers")
 	RootCmd.PersistentFlags().VarP(&opts.IgnorePaths, "ignore-path", "", "Ignore these paths when taking a snapshot. Set it repeatedly for multiple paths.")
+	RootCmd.PersistentFlags().DurationVarP(&opts.Timeout, "timeout", "", 0, "Fail the build if building and pushing the image takes longer than this duration. Zero means no timeout.")
+	RootCmd.PersistentFlags().DurationVarP(&opts.RunTimeout, "run-timeout", "", 0, "Fail the build if a single Dockerfile command, such as RUN, takes longer than this duration. Zero means no timeout.")
 }
 
 // addHiddenFlags marks certain flags as hidden from the executor help text
@@ -240,6 +459,9 @@ func cacheFlagsValid() error {
 	if opts.CacheRepo == "" && opts.NoPush {
 		return errors.New("if using cache with --no-push, specify cache repo with --cache-repo")
 	}
+	if opts.CacheRepo == "" && opts.DryRun && len(opts.Destinations) == 0 {
+		return errors.New("if using cache with --dry-run, specify cache repo with --cache-repo or --destination")
+	}
 	return nil
 }
 
@@ -279,9 +501,29 @@ func resolveEnvironmentBuildArgs(arguments []string, resolver func(string) strin
 	}
 }
 
+// resolveSourceDateEpoch parses the --source-date-epoch flag, falling back to the
+// SOURCE_DATE_EPOCH environment variable for reproducible builds when it isn't set
+func resolveSourceDateEpoch(value string, changed, reproducible bool, resolver func(string) string) (time.Time, error) {
+	if !changed && reproducible {
+		value = resolver("SOURCE_DATE_EPOCH")
+	}
+	if value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, fmt.Errorf("invalid source date epoch %q, must be a non-negative number of seconds", value)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
 // copy Dockerfile to /kaniko/Dockerfile so that if it's specified in the .dockerignore
 // it won't be copied into the image
 func copyDockerfile() error {
+	// A dry run deletes no files, so the Dockerfile is read where it is
+	if opts.DryRun {
+		return nil
+	}
 	if _, err := util.CopyFile(opts.DockerfilePath, constants.DockerfilePath, util.FileContext{}, util.DoNotChangeUID, util.DoNotChangeGID); err != nil {
 		return errors.Wrap(err, "copying dockerfile")
 	}
@@ -302,6 +544,7 @@ func resolveSourceContext() error {
 		return errors.New("please specify a path to the build context with the --context flag or a bucket with the --bucket flag")
 	}
 	if opts.SrcContext != "" && !strings.Contains(opts.SrcContext, "://") {
+		buildSource.URI = opts.SrcContext
 		return nil
 	}
 	if opts.Bucket != "" {
@@ -321,10 +564,15 @@ func resolveSourceContext() error {
 		return err
 	}
 	logrus.Debugf("Getting source context from %s", opts.SrcContext)
+	buildSource.URI = provenance.SanitizeURI(opts.SrcContext)
 	opts.SrcContext, err = contextExecutor.UnpackTarFromBuildContext()
 	if err != nil {
 		return err
 	}
+	if g, ok := contextExecutor.(*buildcontext.Git); ok {
+		buildSource.Digest = provenance.DigestSet{"sha1": g.Commit()}
+		executor.AddSourceAnnotations(opts, buildSource.URI, g.Commit())
+	}
 	if ctxSubPath != "" {
 		opts.SrcContext = filepath.Join(opts.SrcContext, ctxSubPath)
 		if _, err := os.Stat(opts.SrcContext); os.IsNotExist(err) {
@@ -340,10 +588,25 @@ func resolveRelativePaths() error {
 		&opts.DockerfilePath,
 		&opts.SrcContext,
 		&opts.CacheDir,
+		&opts.CacheMountDir,
 		&opts.TarPath,
 		&opts.DigestFile,
 		&opts.ImageNameDigestFile,
 		&opts.ImageNameTagDigestFile,
+		&opts.ProvenanceFile,
+		&opts.SBOMFile,
+		&opts.SignKey,
+		&opts.VerifyBaseImageKey,
+		&opts.BaseImageLayout,
+		&opts.Lockfile,
+		&opts.LockfileOut,
+		&opts.BaseImagePolicy,
+		&opts.ImagePolicy,
+		&opts.ImagePolicyReport,
+		&opts.LayerReport,
+	}
+	for i := range opts.Outputs {
+		optsPaths = append(optsPaths, &opts.Outputs[i].Dest)
 	}
 
 	for _, p := range optsPaths {
@@ -360,10 +623,28 @@ func resolveRelativePaths() error {
 		}
 		logrus.Debugf("Resolved relative path %s to %s", relp, *p)
 	}
+
+	// The path of --on-failure-snapshot comes after its prefix
+	if path := executor.FailureSnapshotPath(opts.OnFailureSnapshot); !shdSkip(path) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return errors.Wrapf(err, "Couldn't resolve relative path %s to an absolute path", path)
+		}
+		opts.OnFailureSnapshot = strings.TrimSuffix(opts.OnFailureSnapshot, path) + abs
+		logrus.Debugf("Resolved relative path %s to %s", path, abs)
+	}
 	return nil
 }
 
 func exit(err error) {
+	if sig := cancelledBy(); sig != nil && errors.Is(err, context.Canceled) {
+		// report how far the build got before it was cancelled
+		writeBenchmarkFile()
+		exitWithCode(err, signalExitCode(sig))
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		exitWithCode(err, TimeoutExitCode)
+	}
 	var execErr *exec.ExitError
 	if errors.As(err, &execErr) {
 		// if there is an exit code propagate it
@@ -375,10 +656,18 @@ func exit(err error) {
 
 //exits with the given error and exit code
 func exitWithCode(err error, exitCode int) {
+	closeEventsFile()
 	fmt.Println(err)
 	os.Exit(exitCode)
 }
 
+// closeEventsFile closes the file of --events-file, so the events are on disk before kaniko exits
+func closeEventsFile() {
+	if err := events.Close(); err != nil {
+		logrus.Warnf("Unable to close events file: %s", err)
+	}
+}
+
 func isURL(path string) bool {
 	if match, _ := regexp.MatchString("^https?://", path); match {
 		return true
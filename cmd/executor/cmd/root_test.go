@@ -17,7 +17,11 @@ limitations under the License.
 package cmd
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/testutil"
 )
@@ -149,3 +153,81 @@ func TestResolveEnvironmentBuildArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveSourceDateEpoch(t *testing.T) {
+	env := func(variable string) string {
+		if variable == "SOURCE_DATE_EPOCH" {
+			return "1600000000"
+		}
+		return ""
+	}
+	tests := []struct {
+		description  string
+		value        string
+		changed      bool
+		reproducible bool
+		expected     time.Time
+		shouldErr    bool
+	}{
+		{
+			description: "environment is ignored without --reproducible",
+		},
+		{
+			description:  "environment is used with --reproducible",
+			reproducible: true,
+			expected:     time.Unix(1600000000, 0).UTC(),
+		},
+		{
+			description:  "flag wins over the environment",
+			value:        "0",
+			changed:      true,
+			reproducible: true,
+			expected:     time.Unix(0, 0).UTC(),
+		},
+		{
+			description: "flag without --reproducible",
+			value:       "1700000000",
+			changed:     true,
+			expected:    time.Unix(1700000000, 0).UTC(),
+		},
+		{
+			description: "not a number",
+			value:       "yesterday",
+			changed:     true,
+			shouldErr:   true,
+		},
+		{
+			description: "negative",
+			value:       "-1",
+			changed:     true,
+			shouldErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			actual, err := resolveSourceDateEpoch(tt.value, tt.changed, tt.reproducible, env)
+			testutil.CheckErrorAndDeepEqual(t, tt.shouldErr, err, tt.expected, actual)
+		})
+	}
+}
+
+func TestResolveDockerfilePath_dryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfile, []byte("FROM scratch"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	original := *opts
+	defer func() { *opts = original }()
+	opts.DryRun = true
+	opts.SrcContext = dir
+	opts.DockerfilePath = "Dockerfile"
+	testutil.CheckNoError(t, resolveDockerfilePath())
+	// The Dockerfile isn't copied into the kaniko directory
+	testutil.CheckDeepEqual(t, dockerfile, opts.DockerfilePath)
+}
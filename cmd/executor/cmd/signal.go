@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// receivedSignal holds the termination signal which cancelled the build, if any
+var receivedSignal atomic.Value
+
+// cancelOnSignal returns a context which is cancelled on the first SIGTERM or SIGINT.
+// A second signal exits the executor right away.
+func cancelOnSignal(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case sig := <-signals:
+			receivedSignal.Store(sig)
+			logrus.Warnf("Received %s, cancelling the build", sig)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+			return
+		}
+		sig := <-signals
+		logrus.Warnf("Received %s again, exiting immediately", sig)
+		os.Exit(signalExitCode(sig))
+	}()
+	return ctx, cancel
+}
+
+// cancelledBy returns the signal which cancelled the build, or nil
+func cancelledBy() os.Signal {
+	sig, _ := receivedSignal.Load().(os.Signal)
+	return sig
+}
+
+// signalExitCode returns the conventional exit code of a process terminated by sig
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/executor"
+	"github.com/GoogleContainerTools/kaniko/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	RootCmd.AddCommand(stageCmd)
+}
+
+// stageCmd builds one stage in its own root for a build run with --max-parallel-stages,
+// it logs as JSON for the executor of the build to forward its logs
+var stageCmd = &cobra.Command{
+	Use:    executor.IsolatedStageCommand + " <dir>",
+	Short:  "Build one stage of a build whose stages are built in parallel",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := logging.Configure(logLevel, logging.FormatJSON, false); err != nil {
+			exit(err)
+		}
+		ctx, cancel := cancelOnSignal(context.Background())
+		defer cancel()
+		if err := executor.DoIsolatedStage(ctx, args[0]); err != nil {
+			exit(err)
+		}
+	},
+}
@@ -17,6 +17,7 @@ require (
 	github.com/coreos/etcd v3.3.13+incompatible // indirect
 	github.com/docker/docker v1.14.0-0.20190319215453-e7b5f7dbe98c
 	github.com/docker/go-metrics v0.0.0-20180209012529-399ea8c73916 // indirect
+	github.com/docker/go-units v0.4.0
 	github.com/docker/swarmkit v1.12.1-0.20180726190244-7567d47988d8 // indirect
 	github.com/genuinetools/bpfd v0.0.2-0.20190525234658-c12d8cd9aac8
 	github.com/go-git/go-billy/v5 v5.0.0
@@ -32,6 +33,7 @@ require (
 	github.com/hashicorp/go-memdb v0.0.0-20180223233045-1289e7fffe71 // indirect
 	github.com/hashicorp/go-uuid v1.0.1 // indirect
 	github.com/karrick/godirwalk v1.16.1
+	github.com/klauspost/compress v1.11.7
 	github.com/mattn/go-ieproxy v0.0.1 // indirect
 	github.com/mattn/go-shellwords v1.0.10 // indirect
 	github.com/minio/highwayhash v1.0.0
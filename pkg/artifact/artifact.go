@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package artifact creates the OCI artifacts kaniko pushes next to the images it builds,
+// such as their provenance or SBOM.
+package artifact
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// blobLayer is a layer which holds b as is, without compression
+type blobLayer struct {
+	b         []byte
+	mediaType types.MediaType
+}
+
+func (l *blobLayer) Digest() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(l.b))
+	return h, err
+}
+
+func (l *blobLayer) DiffID() (v1.Hash, error) {
+	return l.Digest()
+}
+
+func (l *blobLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.b)), nil
+}
+
+func (l *blobLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.Compressed()
+}
+
+func (l *blobLayer) Size() (int64, error) {
+	return int64(len(l.b)), nil
+}
+
+func (l *blobLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
+
+// Image returns an OCI artifact with content as its only layer
+func Image(content []byte, mediaType types.MediaType) (v1.Image, error) {
+	return Append(empty.Image, content, mediaType, nil)
+}
+
+// Append returns the OCI artifact base with content appended as a layer
+// whose descriptor carries the given annotations
+func Append(base v1.Image, content []byte, mediaType types.MediaType, annotations map[string]string) (v1.Image, error) {
+	img, err := mutate.Append(base, mutate.Addendum{
+		Layer:       &blobLayer{b: content, mediaType: mediaType},
+		MediaType:   mediaType,
+		Annotations: annotations,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutate.MediaType(img, types.OCIManifestSchema1), nil
+}
+
+// Tag returns the tag an artifact of the given kind, e.g. "att" or "sbom", is
+// pushed with for the image with the given digest, next to it in the same repository
+func Tag(digest v1.Hash, kind string) string {
+	return fmt.Sprintf("%s-%s.%s", digest.Algorithm, digest.Hex, kind)
+}
@@ -52,6 +52,7 @@ var (
 type Git struct {
 	context string
 	opts    BuildOptions
+	commit  string
 }
 
 // UnpackTarFromBuildContext will provide the directory where Git Repository is Cloned
@@ -118,7 +119,19 @@ func (g *Git) UnpackTarFromBuildContext() (string, error) {
 			return directory, err
 		}
 	}
-	return directory, err
+
+	head, err := r.Head()
+	if err != nil {
+		return directory, err
+	}
+	g.commit = head.Hash().String()
+	logrus.Debugf("Build context is at commit %s", g.commit)
+	return directory, nil
+}
+
+// Commit returns the commit the repository was checked out at, once it was unpacked
+func (g *Git) Commit() string {
+	return g.commit
 }
 
 func getGitReferenceName(directory string, url string, branch string) (plumbing.ReferenceName, error) {
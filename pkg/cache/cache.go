@@ -29,7 +29,6 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -166,7 +165,7 @@ func mfstFromPath(p string) (*v1.Manifest, error) {
 }
 
 func cachedImageFromPath(p string) (v1.Image, error) {
-	imgTar, err := tarball.ImageFromPath(p, nil)
+	imgTar, err := util.ImageFromTarball(p)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting image from path")
 	}
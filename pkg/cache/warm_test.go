@@ -18,6 +18,7 @@ package cache
 
 import (
 	"bytes"
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
@@ -34,7 +35,7 @@ func Test_Warmer_Warm_not_in_cache(t *testing.T) {
 	manifestBuf := new(bytes.Buffer)
 
 	cw := &Warmer{
-		Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
+		Remote: func(_ context.Context, _ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
 			return fakes.FakeImage{}, nil
 		},
 		Local: func(_ *config.CacheOptions, _ string) (v1.Image, error) {
@@ -62,7 +63,7 @@ func Test_Warmer_Warm_in_cache_not_expired(t *testing.T) {
 	manifestBuf := new(bytes.Buffer)
 
 	cw := &Warmer{
-		Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
+		Remote: func(_ context.Context, _ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
 			return fakes.FakeImage{}, nil
 		},
 		Local: func(_ *config.CacheOptions, _ string) (v1.Image, error) {
@@ -90,7 +91,7 @@ func Test_Warmer_Warm_in_cache_expired(t *testing.T) {
 	manifestBuf := new(bytes.Buffer)
 
 	cw := &Warmer{
-		Remote: func(_ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
+		Remote: func(_ context.Context, _ string, _ config.RegistryOptions, _ string) (v1.Image, error) {
 			return fakes.FakeImage{}, nil
 		},
 		Local: func(_ *config.CacheOptions, _ string) (v1.Image, error) {
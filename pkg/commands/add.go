@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"path/filepath"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -44,7 +45,7 @@ type AddCommand struct {
 // 		- If dest doesn't end with a slash, the filepath is inferred to be <dest>/<filename>
 // 	2. If <src> is a local tar archive:
 // 		- it is unpacked at the dest, as 'tar -x' would
-func (a *AddCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (a *AddCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
 
 	uid, gid, err := util.GetUserGroup(a.cmd.Chown, replacementEnvs)
@@ -104,7 +105,7 @@ func (a *AddCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.Bui
 		fileContext: a.fileContext,
 	}
 
-	if err := copyCmd.ExecuteCommand(config, buildArgs); err != nil {
+	if err := copyCmd.ExecuteCommand(ctx, config, buildArgs); err != nil {
 		return errors.Wrap(err, "executing copy command")
 	}
 	a.snapshotFiles = append(a.snapshotFiles, copyCmd.snapshotFiles...)
@@ -17,6 +17,8 @@ limitations under the License.
 package commands
 
 import (
+	"context"
+
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -29,7 +31,7 @@ type ArgCommand struct {
 }
 
 // ExecuteCommand only needs to add this ARG key/value as seen
-func (r *ArgCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (r *ArgCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	key, val, err := ParseArg(r.cmd.Key, r.cmd.Value, config.Env, buildArgs)
 	if err != nil {
 		return err
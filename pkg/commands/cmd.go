@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"strings"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -32,7 +33,7 @@ type CmdCommand struct {
 
 // ExecuteCommand executes the CMD command
 // Argument handling is the same as RUN.
-func (c *CmdCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (c *CmdCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	var newCommand []string
 	if c.cmd.PrependShell {
 		// This is the default shell on Linux
@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/testutil"
@@ -55,7 +56,7 @@ func TestExecuteCmd(t *testing.T) {
 				},
 			},
 		}
-		err := cmd.ExecuteCommand(cfg, nil)
+		err := cmd.ExecuteCommand(context.TODO(), cfg, nil)
 		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedCmd, cfg.Cmd)
 	}
 }
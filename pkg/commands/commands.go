@@ -17,6 +17,8 @@ limitations under the License.
 package commands
 
 import (
+	"context"
+
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -32,7 +34,7 @@ type DockerCommand interface {
 	// 	1. Making required changes to the filesystem (ex. copying files for ADD/COPY or setting ENV variables)
 	//  2. Updating metadata fields in the config
 	// It should not change the config history.
-	ExecuteCommand(*v1.Config, *dockerfile.BuildArgs) error
+	ExecuteCommand(context.Context, *v1.Config, *dockerfile.BuildArgs) error
 	// Returns a string representation of the command
 	String() string
 	// A list of files to snapshot, empty for metadata commands or nil if we don't know
@@ -60,13 +62,13 @@ type DockerCommand interface {
 	ShouldDetectDeletedFiles() bool
 }
 
-func GetCommand(cmd instructions.Command, fileContext util.FileContext, useNewRun bool, cacheCopy bool) (DockerCommand, error) {
+func GetCommand(cmd instructions.Command, fileContext util.FileContext, useNewRun bool, cacheCopy bool, runOpts RunOptions) (DockerCommand, error) {
 	switch c := cmd.(type) {
 	case *instructions.RunCommand:
 		if useNewRun {
-			return &RunMarkerCommand{cmd: c}, nil
+			return &RunMarkerCommand{cmd: c, opts: runOpts, fileContext: fileContext}, nil
 		}
-		return &RunCommand{cmd: c}, nil
+		return &RunCommand{cmd: c, opts: runOpts, fileContext: fileContext}, nil
 	case *instructions.CopyCommand:
 		return &CopyCommand{cmd: c, fileContext: fileContext, shdCache: cacheCopy}, nil
 	case *instructions.ExposeCommand:
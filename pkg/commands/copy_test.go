@@ -17,6 +17,7 @@ package commands
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -214,7 +215,7 @@ func Test_CachingCopyCommand_ExecuteCommand(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.desctiption, func(t *testing.T) {
 			c := tc.command
-			err := c.ExecuteCommand(config, buildArgs)
+			err := c.ExecuteCommand(context.TODO(), config, buildArgs)
 			if !tc.expectErr && err != nil {
 				t.Errorf("Expected err to be nil but was %v", err)
 			} else if tc.expectErr && err == nil {
@@ -285,7 +286,7 @@ func TestCopyExecuteCmd(t *testing.T) {
 			buildArgs := copySetUpBuildArgs()
 			dest := cfg.WorkingDir + "/" + test.sourcesAndDest[len(test.sourcesAndDest)-1]
 
-			err := cmd.ExecuteCommand(cfg, buildArgs)
+			err := cmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 			if err != nil {
 				t.Error()
 			}
@@ -441,7 +442,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err = cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -473,7 +474,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "dest" dir exists with file bam.txt
 		files, err := ioutil.ReadDir(filepath.Join(testDir, "dest"))
@@ -500,7 +501,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if bam.txt is copied to dest file
 		if _, err := os.Lstat(filepath.Join(testDir, "dest")); err != nil {
@@ -529,7 +530,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "dest" dir exists with file bam.txt
 		files, err := ioutil.ReadDir(filepath.Join(testDir, "dest"))
@@ -558,7 +559,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "dest" dir exists with link sym.link
 		files, err := ioutil.ReadDir(filepath.Join(testDir, "dest"))
@@ -603,7 +604,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "dest" dir exists with link dead.link
 		files, err := ioutil.ReadDir(filepath.Join(testDir, "dest"))
@@ -644,7 +645,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err = cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "dest" dir exists with contents of srcDir
 		actual, err := ioutil.ReadDir(filepath.Join(testDir, "dest"))
@@ -690,7 +691,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err = cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "dest" dir exists contents of srcDir and an extra zSym.link created
 		// in this test
@@ -734,7 +735,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err = cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "dest" dir exists with bam.txt and "dest" dir is a symlink
 		actual, err := ioutil.ReadDir(filepath.Join(testDir, "dest"))
@@ -777,7 +778,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err = cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err = cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "linkdest" dir exists with contents of srcDir
 		actual, err := ioutil.ReadDir(filepath.Join(testDir, "linkDest"))
@@ -822,7 +823,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		// Check if "linkDest" link is same.
 		actual, err := ioutil.ReadDir(filepath.Join(testDir, "dest"))
@@ -871,7 +872,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 
 		actual, err := ioutil.ReadDir(filepath.Join(testDir))
@@ -916,7 +917,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			WorkingDir: testDir,
 		}
 
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		if !errors.Is(err, os.ErrPermission) {
 			testutil.CheckNoError(t, err)
 		}
@@ -946,7 +947,7 @@ func TestCopyCommand_ExecuteCommand_Extended(t *testing.T) {
 			Env:        []string{},
 			WorkingDir: testDir,
 		}
-		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		err := cmd.ExecuteCommand(context.TODO(), cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckNoError(t, err)
 		actual, err := ioutil.ReadDir(filepath.Join(dest, "another"))
 		if err != nil {
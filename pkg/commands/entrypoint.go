@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"strings"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -31,7 +32,7 @@ type EntrypointCommand struct {
 }
 
 // ExecuteCommand handles command processing similar to CMD and RUN,
-func (e *EntrypointCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (e *EntrypointCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	var newCommand []string
 	if e.cmd.PrependShell {
 		// This is the default shell on Linux
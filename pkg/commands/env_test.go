@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -63,7 +64,7 @@ func Test_EnvExecute(t *testing.T) {
 		"foo=foo2",
 	}
 	buildArgs := setUpBuildArgs()
-	err := envCmd.ExecuteCommand(cfg, buildArgs)
+	err := envCmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEnvs, cfg.Env)
 }
 
@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -63,7 +64,7 @@ func TestUpdateExposedPorts(t *testing.T) {
 		"8085/udp": {},
 	}
 	buildArgs := dockerfile.NewBuildArgs([]string{})
-	err := exposeCmd.ExecuteCommand(cfg, buildArgs)
+	err := exposeCmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 	testutil.CheckErrorAndDeepEqual(t, false, err, expectedPorts, cfg.ExposedPorts)
 }
 
@@ -82,6 +83,6 @@ func TestInvalidProtocol(t *testing.T) {
 		},
 	}
 	buildArgs := dockerfile.NewBuildArgs([]string{})
-	err := exposeCmd.ExecuteCommand(cfg, buildArgs)
+	err := exposeCmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 	testutil.CheckErrorAndDeepEqual(t, true, err, nil, nil)
 }
@@ -17,6 +17,8 @@ limitations under the License.
 package commands
 
 import (
+	"context"
+
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
@@ -28,7 +30,7 @@ type HealthCheckCommand struct {
 }
 
 // ExecuteCommand handles command processing similar to CMD and RUN,
-func (h *HealthCheckCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (h *HealthCheckCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	check := v1.HealthConfig(*h.cmd.Health)
 	config.Healthcheck = &check
 
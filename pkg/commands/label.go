@@ -17,6 +17,8 @@ limitations under the License.
 package commands
 
 import (
+	"context"
+
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 
@@ -30,7 +32,7 @@ type LabelCommand struct {
 	cmd *instructions.LabelCommand
 }
 
-func (r *LabelCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (r *LabelCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	return updateLabels(r.cmd.Labels, config, buildArgs)
 }
 
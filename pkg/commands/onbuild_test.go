@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -65,7 +66,7 @@ func TestExecuteOnbuild(t *testing.T) {
 			},
 		}
 		buildArgs := dockerfile.NewBuildArgs([]string{})
-		err := onbuildCmd.ExecuteCommand(cfg, buildArgs)
+		err := onbuildCmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedArray, cfg.OnBuild)
 	}
 
@@ -17,12 +17,14 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
 	"strings"
 	"syscall"
+	"time"
 
 	kConfig "github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
@@ -36,7 +38,9 @@ import (
 
 type RunCommand struct {
 	BaseCommand
-	cmd *instructions.RunCommand
+	cmd         *instructions.RunCommand
+	opts        RunOptions
+	fileContext util.FileContext
 }
 
 // for testing
@@ -45,11 +49,11 @@ var (
 	userLookupID = user.LookupId
 )
 
-func (r *RunCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
-	return runCommandInExec(config, buildArgs, r.cmd)
+func (r *RunCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+	return runCommandWithMounts(ctx, config, buildArgs, r.cmd, r.opts, r.fileContext)
 }
 
-func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand) error {
+func runCommandInExec(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand, network string) error {
 	var newCommand []string
 	if cmdRun.PrependShell {
 		// This is the default shell on Linux
@@ -114,7 +118,13 @@ func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun
 	cmd.Env = env
 
 	logrus.Infof("Running: %s", cmd.Args)
-	if err := cmd.Start(); err != nil {
+	if network == dockerfile.NetworkNone {
+		logrus.Infof("Running without network access")
+		err = startWithoutNetwork(cmd)
+	} else {
+		err = cmd.Start()
+	}
+	if err != nil {
 		return errors.Wrap(err, "starting command")
 	}
 
@@ -122,8 +132,32 @@ func runCommandInExec(config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun
 	if err != nil {
 		return errors.Wrap(err, "getting group id for process")
 	}
-	if err := cmd.Wait(); err != nil {
-		return errors.Wrap(err, "waiting for process to exit")
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+	select {
+	case err := <-waitErr:
+		if err != nil {
+			return errors.Wrap(err, "waiting for process to exit")
+		}
+	case <-ctx.Done():
+		// Signal the whole process group, the command may have spawned children of its own
+		logrus.Warnf("Terminating %s: %s", cmd.Args, ctx.Err())
+		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+			return errors.Wrap(err, "terminating process group")
+		}
+		select {
+		case <-waitErr:
+		case <-time.After(constants.TerminationGracePeriod):
+			logrus.Warnf("Killing %s as it did not exit within %s", cmd.Args, constants.TerminationGracePeriod)
+			if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				return errors.Wrap(err, "killing process group")
+			}
+			<-waitErr
+		}
+		return errors.Wrap(ctx.Err(), "running command")
 	}
 
 	//it's not an error if there are no grandchildren
@@ -166,6 +200,15 @@ func (r *RunCommand) String() string {
 	return r.cmd.String()
 }
 
+func (r *RunCommand) FilesUsedFromContext(_ *v1.Config, _ *dockerfile.BuildArgs) ([]string, error) {
+	return runCmdFilesUsedFromContext(r.cmd, r.fileContext)
+}
+
+// BindMountsFrom returns the previous stages and images the command mounts files from
+func (r *RunCommand) BindMountsFrom() ([]string, error) {
+	return bindMountsFrom(r.cmd, r.opts.StageNameToIdx)
+}
+
 func (r *RunCommand) FilesToSnapshot() []string {
 	return nil
 }
@@ -178,9 +221,11 @@ func (r *RunCommand) ProvidesFilesToSnapshot() bool {
 func (r *RunCommand) CacheCommand(img v1.Image) DockerCommand {
 
 	return &CachingRunCommand{
-		img:       img,
-		cmd:       r.cmd,
-		extractFn: util.ExtractFile,
+		img:         img,
+		cmd:         r.cmd,
+		opts:        r.opts,
+		fileContext: r.fileContext,
+		extractFn:   util.ExtractFile,
 	}
 }
 
@@ -202,10 +247,12 @@ type CachingRunCommand struct {
 	img            v1.Image
 	extractedFiles []string
 	cmd            *instructions.RunCommand
+	opts           RunOptions
+	fileContext    util.FileContext
 	extractFn      util.ExtractFunction
 }
 
-func (cr *CachingRunCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (cr *CachingRunCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	logrus.Infof("Found cached layer, extracting to filesystem")
 	var err error
 
@@ -229,6 +276,7 @@ func (cr *CachingRunCommand) ExecuteCommand(config *v1.Config, buildArgs *docker
 		layers,
 		util.ExtractFunc(cr.extractFn),
 		util.IncludeWhiteout(),
+		util.WithContext(ctx),
 	)
 	if err != nil {
 		return errors.Wrap(err, "extracting fs from image")
@@ -237,6 +285,21 @@ func (cr *CachingRunCommand) ExecuteCommand(config *v1.Config, buildArgs *docker
 	return nil
 }
 
+func (cr *CachingRunCommand) FilesUsedFromContext(_ *v1.Config, _ *dockerfile.BuildArgs) ([]string, error) {
+	if cr.cmd == nil {
+		return nil, nil
+	}
+	return runCmdFilesUsedFromContext(cr.cmd, cr.fileContext)
+}
+
+// BindMountsFrom returns the previous stages and images the command mounts files from
+func (cr *CachingRunCommand) BindMountsFrom() ([]string, error) {
+	if cr.cmd == nil {
+		return nil, nil
+	}
+	return bindMountsFrom(cr.cmd, cr.opts.StageNameToIdx)
+}
+
 func (cr *CachingRunCommand) FilesToSnapshot() []string {
 	f := cr.extractedFiles
 	logrus.Debugf("%d files extracted by caching run command", len(f))
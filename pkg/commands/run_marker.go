@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"os"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -28,15 +29,17 @@ import (
 
 type RunMarkerCommand struct {
 	BaseCommand
-	cmd   *instructions.RunCommand
-	Files []string
+	cmd         *instructions.RunCommand
+	opts        RunOptions
+	fileContext util.FileContext
+	Files       []string
 }
 
-func (r *RunMarkerCommand) ExecuteCommand(config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
+func (r *RunMarkerCommand) ExecuteCommand(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs) error {
 	// run command `touch filemarker`
 	logrus.Debugf("using new RunMarker command")
 	prevFilesMap, _ := util.GetFSInfoMap("/", map[string]os.FileInfo{})
-	if err := runCommandInExec(config, buildArgs, r.cmd); err != nil {
+	if err := runCommandWithMounts(ctx, config, buildArgs, r.cmd, r.opts, r.fileContext); err != nil {
 		return err
 	}
 	_, r.Files = util.GetFSInfoMap("/", prevFilesMap)
@@ -50,6 +53,15 @@ func (r *RunMarkerCommand) String() string {
 	return r.cmd.String()
 }
 
+func (r *RunMarkerCommand) FilesUsedFromContext(_ *v1.Config, _ *dockerfile.BuildArgs) ([]string, error) {
+	return runCmdFilesUsedFromContext(r.cmd, r.fileContext)
+}
+
+// BindMountsFrom returns the previous stages and images the command mounts files from
+func (r *RunMarkerCommand) BindMountsFrom() ([]string, error) {
+	return bindMountsFrom(r.cmd, r.opts.StageNameToIdx)
+}
+
 func (r *RunMarkerCommand) FilesToSnapshot() []string {
 	return r.Files
 }
@@ -62,9 +74,11 @@ func (r *RunMarkerCommand) ProvidesFilesToSnapshot() bool {
 func (r *RunMarkerCommand) CacheCommand(img v1.Image) DockerCommand {
 
 	return &CachingRunCommand{
-		img:       img,
-		cmd:       r.cmd,
-		extractFn: util.ExtractFile,
+		img:         img,
+		cmd:         r.cmd,
+		opts:        r.opts,
+		fileContext: r.fileContext,
+		extractFn:   util.ExtractFile,
 	}
 }
 
@@ -0,0 +1,479 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	kConfig "github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RunOptions are the options of RUN commands which come from the build rather than the Dockerfile
+type RunOptions struct {
+	// CacheMountDir holds the directories of RUN --mount=type=cache across builds
+	CacheMountDir string
+	// Secrets are exposed to the commands by RUN --mount=type=secret
+	Secrets map[string]kConfig.Secret
+	// StageNameToIdx resolves the stages RUN --mount=type=bind,from= names
+	StageNameToIdx map[string]string
+	// Network is the network of the commands which don't set RUN --network
+	Network string
+}
+
+// runCommandWithMounts runs cmdRun in the network it asks for, with the mounts it requests set up for as long as it runs
+func runCommandWithMounts(ctx context.Context, config *v1.Config, buildArgs *dockerfile.BuildArgs, cmdRun *instructions.RunCommand, opts RunOptions, fileContext util.FileContext) error {
+	network, err := dockerfile.RunNetwork(cmdRun)
+	if err != nil {
+		return err
+	}
+	if network == "" {
+		network = opts.Network
+	}
+	teardown, err := setupRunMounts(cmdRun, config, opts, fileContext)
+	if err != nil {
+		return err
+	}
+	err = runCommandInExec(ctx, config, buildArgs, cmdRun, network)
+	if terr := teardown(); err == nil {
+		err = terr
+	}
+	return err
+}
+
+// setupRunMounts sets up the mounts requested by cmdRun and returns the func tearing them down
+func setupRunMounts(cmdRun *instructions.RunCommand, config *v1.Config, opts RunOptions, fileContext util.FileContext) (func() error, error) {
+	mounts, err := dockerfile.RunMounts(cmdRun)
+	if err != nil {
+		return nil, err
+	}
+	var teardowns []func() error
+	teardown := func() error {
+		var err error
+		for i := len(teardowns) - 1; i >= 0; i-- {
+			if terr := teardowns[i](); terr != nil && err == nil {
+				err = terr
+			}
+		}
+		return err
+	}
+	for _, m := range mounts {
+		var t func() error
+		switch m.Type {
+		case dockerfile.MountTypeBind:
+			t, err = mountBind(m, config.WorkingDir, opts.StageNameToIdx, fileContext)
+		case dockerfile.MountTypeCache:
+			t, err = mountCache(m, config.WorkingDir, opts.CacheMountDir)
+		case dockerfile.MountTypeSecret:
+			t, err = mountSecret(m, config.WorkingDir, opts.Secrets)
+		default:
+			err = fmt.Errorf("RUN --mount=type=%s is not supported", m.Type)
+		}
+		if err != nil {
+			teardown()
+			return nil, err
+		}
+		teardowns = append(teardowns, t)
+	}
+	return teardown, nil
+}
+
+// mountBind binds the files m mounts from the build context, a previous stage or an
+// image at its target, which is kept out of the snapshots. Read-write mounts get a
+// copy of the files, so that what the command writes to them is thrown away.
+func mountBind(m *dockerfile.Mount, workdir string, stageNameToIdx map[string]string, fileContext util.FileContext) (func() error, error) {
+	src := bindMountSource(m, stageNameToIdx, fileContext)
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "bind mount source %s", m.Source)
+	}
+	removeCopy := func() error { return nil }
+	// Files of the context left out by .dockerignore mustn't show up in the mount either
+	if !m.ReadOnly || (m.From == "" && len(fileContext.ExcludedFiles) > 0) {
+		if m.From != "" {
+			fileContext = util.FileContext{}
+		}
+		scratch, err := copyBindSource(src, fi, fileContext)
+		if err != nil {
+			return nil, err
+		}
+		src = filepath.Join(scratch, filepath.Base(src))
+		removeCopy = func() error { return os.RemoveAll(scratch) }
+	}
+
+	target := mountTarget(m, workdir)
+	dest := filepath.Join(kConfig.RootDir, target)
+	removeTarget, err := createMountTarget(dest, fi.IsDir())
+	if err != nil {
+		removeCopy()
+		return nil, errors.Wrapf(err, "creating bind mount target %s", target)
+	}
+	unignore := ignoreMountTarget(dest)
+	logrus.Infof("Mounting %s at %s", m.Source, target)
+	if err := bindMount(src, dest, m.ReadOnly); err != nil {
+		unignore()
+		removeTarget()
+		removeCopy()
+		return nil, err
+	}
+	return func() error {
+		if err := unmount(dest); err != nil {
+			return err
+		}
+		unignore()
+		if err := removeTarget(); err != nil {
+			return err
+		}
+		return removeCopy()
+	}, nil
+}
+
+// bindMountSource returns where the files m mounts are: in the build context, in the
+// directory the files of a previous stage are saved to or in the one an image is extracted to
+func bindMountSource(m *dockerfile.Mount, stageNameToIdx map[string]string, fileContext util.FileContext) string {
+	root := fileContext.Root
+	if m.From != "" {
+		root = filepath.Join(kConfig.KanikoDir, m.ResolveFrom(stageNameToIdx))
+	}
+	return filepath.Join(root, filepath.Clean("/"+m.Source))
+}
+
+// copyBindSource copies the file or directory src to a scratch directory and returns it
+func copyBindSource(src string, fi os.FileInfo, fileContext util.FileContext) (string, error) {
+	if err := os.MkdirAll(kConfig.KanikoDir, 0755); err != nil {
+		return "", err
+	}
+	scratch, err := ioutil.TempDir(kConfig.KanikoDir, "bind-mount-")
+	if err != nil {
+		return "", errors.Wrap(err, "creating bind mount directory")
+	}
+	dest := filepath.Join(scratch, filepath.Base(src))
+	if fi.IsDir() {
+		if err := os.Mkdir(dest, fi.Mode().Perm()); err == nil {
+			_, err = util.CopyDir(src, dest, fileContext, util.DoNotChangeUID, util.DoNotChangeGID)
+		}
+	} else {
+		_, err = util.CopyFile(src, dest, fileContext, util.DoNotChangeUID, util.DoNotChangeGID)
+	}
+	if err != nil {
+		os.RemoveAll(scratch)
+		return "", errors.Wrapf(err, "copying %s for a read-write bind mount", src)
+	}
+	return scratch, nil
+}
+
+// bindMountsFrom returns the previous stages and images the bind mounts of cmdRun use files from
+func bindMountsFrom(cmdRun *instructions.RunCommand, stageNameToIdx map[string]string) ([]string, error) {
+	mounts, err := dockerfile.RunMounts(cmdRun)
+	if err != nil {
+		return nil, err
+	}
+	var froms []string
+	for _, m := range mounts {
+		if m.Type == dockerfile.MountTypeBind && m.From != "" {
+			froms = append(froms, m.ResolveFrom(stageNameToIdx))
+		}
+	}
+	return froms, nil
+}
+
+// runCmdFilesUsedFromContext returns the files of the build context the bind mounts of cmdRun use
+func runCmdFilesUsedFromContext(cmdRun *instructions.RunCommand, fileContext util.FileContext) ([]string, error) {
+	mounts, err := dockerfile.RunMounts(cmdRun)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, m := range mounts {
+		if m.Type != dockerfile.MountTypeBind || m.From != "" {
+			continue
+		}
+		src := bindMountSource(m, nil, fileContext)
+		if _, err := os.Lstat(src); err != nil {
+			return nil, errors.Wrapf(err, "bind mount source %s", m.Source)
+		}
+		files = append(files, src)
+	}
+	return files, nil
+}
+
+// mountCache binds the cache directory of m at its target, which is kept out of the snapshots
+func mountCache(m *dockerfile.Mount, workdir, root string) (func() error, error) {
+	dir := filepath.Join(root, fmt.Sprintf("%x", sha256.Sum256([]byte(m.ID))))
+	unlock, dir, err := lockCacheDir(dir, m.CacheSharing)
+	if err != nil {
+		return nil, err
+	}
+	if err := initCacheDir(dir, m); err != nil {
+		unlock()
+		return nil, err
+	}
+
+	target := mountTarget(m, workdir)
+	dest := filepath.Join(kConfig.RootDir, target)
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		unlock()
+		return nil, errors.Wrapf(err, "creating cache mount target %s", target)
+	}
+	unignore := ignoreMountTarget(dest)
+	logrus.Infof("Mounting cache %s at %s", m.ID, target)
+	if err := bindMount(dir, dest, m.ReadOnly); err != nil {
+		unignore()
+		unlock()
+		return nil, err
+	}
+	return func() error {
+		defer unlock()
+		if err := unmount(dest); err != nil {
+			return err
+		}
+		unignore()
+		return nil
+	}, nil
+}
+
+// initCacheDir creates the cache directory dir the first time it is mounted
+func initCacheDir(dir string, m *dockerfile.Mount) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	mode := os.FileMode(0755)
+	if m.Mode != nil {
+		mode = os.FileMode(*m.Mode)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating cache directory for %s", m.ID)
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return err
+	}
+	uid, gid := 0, 0
+	if m.UID != nil {
+		uid = int(*m.UID)
+	}
+	if m.GID != nil {
+		gid = int(*m.GID)
+	}
+	return os.Lchown(dir, uid, gid)
+}
+
+// lockCacheDir locks the cache directory dir as its sharing mode asks for and
+// returns the func unlocking it along with the directory to mount. Shared
+// caches aren't locked, locked caches wait for the other writers and private
+// caches get a throwaway directory when another writer holds them.
+func lockCacheDir(dir, sharing string) (func(), string, error) {
+	noop := func() {}
+	if sharing == dockerfile.MountSharingShared {
+		return noop, dir, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, "", errors.Wrap(err, "creating cache mount directory")
+	}
+	f, err := os.OpenFile(dir+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "opening cache lock")
+	}
+	how := syscall.LOCK_EX
+	if sharing == dockerfile.MountSharingPrivate {
+		how |= syscall.LOCK_NB
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if err != syscall.EWOULDBLOCK {
+			return nil, "", errors.Wrap(err, "locking cache")
+		}
+		private, err := ioutil.TempDir(filepath.Dir(dir), filepath.Base(dir)+"-private-")
+		if err != nil {
+			return nil, "", errors.Wrap(err, "creating private cache directory")
+		}
+		// Let initCacheDir create it with the mode and owner of the mount
+		os.Remove(private)
+		logrus.Infof("Cache %s is in use, mounting an empty private one", dir)
+		return func() { os.RemoveAll(private) }, private, nil
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, dir, nil
+}
+
+// mountSecret binds the secret of m at its target, which is kept out of the snapshot, from
+// a tmpfs so that it is never written to disk, and removes what it created for it afterwards
+func mountSecret(m *dockerfile.Mount, workdir string, secrets map[string]kConfig.Secret) (func() error, error) {
+	secret, ok := secrets[m.ID]
+	if !ok {
+		if m.Required {
+			return nil, fmt.Errorf("secret %s is required, set it with --secret", m.ID)
+		}
+		logrus.Infof("Secret %s is not set, not mounting it", m.ID)
+		return func() error { return nil }, nil
+	}
+	value, err := secret.Value()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading secret %s", m.ID)
+	}
+
+	dir, err := ioutil.TempDir(kConfig.KanikoDir, "secret")
+	if err != nil {
+		return nil, err
+	}
+	if err := mountTmpfs(dir); err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	removeDir := func() error {
+		if err := unmount(dir); err != nil {
+			return err
+		}
+		return os.Remove(dir)
+	}
+	src := filepath.Join(dir, "secret")
+	if err := writeSecret(src, value, m); err != nil {
+		removeDir()
+		return nil, errors.Wrapf(err, "writing secret %s", m.ID)
+	}
+
+	target := mountTarget(m, workdir)
+	dest := filepath.Join(kConfig.RootDir, target)
+	removeTarget, err := createMountTarget(dest, false)
+	if err != nil {
+		removeDir()
+		return nil, errors.Wrapf(err, "creating secret mount target %s", target)
+	}
+	unignore := ignoreMountTarget(dest)
+	logrus.Infof("Mounting secret %s at %s", m.ID, target)
+	if err := bindMount(src, dest, true); err != nil {
+		unignore()
+		removeTarget()
+		removeDir()
+		return nil, err
+	}
+	return func() error {
+		if err := unmount(dest); err != nil {
+			return err
+		}
+		unignore()
+		if err := removeTarget(); err != nil {
+			return err
+		}
+		return removeDir()
+	}, nil
+}
+
+// writeSecret writes the secret value to path with the mode and owner m asks for
+func writeSecret(path string, value []byte, m *dockerfile.Mount) error {
+	mode := os.FileMode(0400)
+	if m.Mode != nil {
+		mode = os.FileMode(*m.Mode)
+	}
+	if err := ioutil.WriteFile(path, value, mode); err != nil {
+		return err
+	}
+	uid, gid := 0, 0
+	if m.UID != nil {
+		uid = int(*m.UID)
+	}
+	if m.GID != nil {
+		gid = int(*m.GID)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// ignoreMountTarget keeps the mount target dest out of the snapshots while it is mounted
+// and returns the func putting it back in once it is unmounted, so that what later
+// commands write there makes it into the image
+func ignoreMountTarget(dest string) func() {
+	entry := util.IgnoreListEntry{
+		Path:            dest,
+		PrefixMatchOnly: false,
+	}
+	util.AddToIgnoreList(entry)
+	return func() { util.RemoveFromIgnoreList(entry) }
+}
+
+// mountTarget returns the absolute target of m, which is relative to the working directory otherwise
+func mountTarget(m *dockerfile.Mount, workdir string) string {
+	if filepath.IsAbs(m.Target) {
+		return m.Target
+	}
+	return filepath.Join("/", workdir, m.Target)
+}
+
+// createMountTarget creates the file or directory dest to mount over when it doesn't
+// exist and returns the func removing what it created, leaving its parent as it was
+func createMountTarget(dest string, dir bool) (func() error, error) {
+	if _, err := os.Lstat(dest); err == nil {
+		return func() error { return nil }, nil
+	}
+	created := missingDirs(filepath.Dir(dest))
+	parent := filepath.Dir(dest)
+	if len(created) > 0 {
+		parent = filepath.Dir(created[0])
+	}
+	parentInfo, err := os.Lstat(parent)
+	if err != nil {
+		return nil, err
+	}
+	remove := func() error {
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "removing mount target %s", dest)
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			// The command may have added files of its own next to the target
+			if err := os.Remove(created[i]); err != nil {
+				break
+			}
+		}
+		return os.Chtimes(parent, parentInfo.ModTime(), parentInfo.ModTime())
+	}
+	if dir {
+		err = os.MkdirAll(dest, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+		err = ioutil.WriteFile(dest, nil, 0644)
+	}
+	if err != nil {
+		remove()
+		return nil, err
+	}
+	return remove, nil
+}
+
+// missingDirs returns dir and those of its parents which don't exist, top down
+func missingDirs(dir string) []string {
+	var missing []string
+	for d := dir; d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		}
+		missing = append([]string{d}, missing...)
+	}
+	return missing
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// bindMount mounts source at target, read-only if asked to
+func bindMount(source, target string, readOnly bool) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		if err == syscall.EPERM {
+			return errors.Wrapf(err, "bind mounting %s at %s, RUN --mount needs the CAP_SYS_ADMIN capability", source, target)
+		}
+		return errors.Wrapf(err, "bind mounting %s at %s", source, target)
+	}
+	if !readOnly {
+		return nil
+	}
+	if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_REC, ""); err != nil {
+		syscall.Unmount(target, 0)
+		return errors.Wrapf(err, "remounting %s read-only", target)
+	}
+	return nil
+}
+
+// mountTmpfs mounts a tmpfs only root can access at target
+func mountTmpfs(target string) error {
+	if err := syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=0700"); err != nil {
+		if err == syscall.EPERM {
+			return errors.Wrapf(err, "mounting tmpfs at %s, RUN --mount needs the CAP_SYS_ADMIN capability", target)
+		}
+		return errors.Wrapf(err, "mounting tmpfs at %s", target)
+	}
+	return nil
+}
+
+// unmount unmounts target
+func unmount(target string) error {
+	return errors.Wrapf(syscall.Unmount(target, 0), "unmounting %s", target)
+}
@@ -0,0 +1,362 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	kConfig "github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+)
+
+func Test_lockCacheDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "cache-mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "cache")
+
+	unlock, shared, err := lockCacheDir(dir, dockerfile.MountSharingShared)
+	testutil.CheckErrorAndDeepEqual(t, false, err, dir, shared)
+	unlock()
+
+	unlock, locked, err := lockCacheDir(dir, dockerfile.MountSharingLocked)
+	testutil.CheckErrorAndDeepEqual(t, false, err, dir, locked)
+
+	// The cache is held, a private mount gets its own directory
+	unlockPrivate, private, err := lockCacheDir(dir, dockerfile.MountSharingPrivate)
+	testutil.CheckError(t, false, err)
+	if private == dir {
+		t.Fatalf("expected a private directory, got the shared one %s", private)
+	}
+	m := &dockerfile.Mount{ID: "cache"}
+	if err := initCacheDir(private, m); err != nil {
+		t.Fatal(err)
+	}
+	unlockPrivate()
+	if _, err := os.Stat(private); !os.IsNotExist(err) {
+		t.Errorf("expected the private directory %s to be removed, got %v", private, err)
+	}
+	unlock()
+
+	unlock, private, err = lockCacheDir(dir, dockerfile.MountSharingPrivate)
+	testutil.CheckErrorAndDeepEqual(t, false, err, dir, private)
+	unlock()
+}
+
+func Test_initCacheDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "cache-mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "cache")
+
+	mode := uint64(0700)
+	if err := initCacheDir(dir, &dockerfile.Mount{Mode: &mode}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(dir)
+	testutil.CheckErrorAndDeepEqual(t, false, err, os.FileMode(0700), fi.Mode().Perm())
+
+	// An existing cache is left as it is
+	other := uint64(0755)
+	if err := initCacheDir(dir, &dockerfile.Mount{Mode: &other}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = os.Stat(dir)
+	testutil.CheckErrorAndDeepEqual(t, false, err, os.FileMode(0700), fi.Mode().Perm())
+}
+
+func Test_setupRunMounts(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	cacheDir, err := ioutil.TempDir("", "cache-mounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	original := kConfig.RootDir
+	kConfig.RootDir = root
+	defer func() {
+		kConfig.RootDir = original
+		util.InitIgnoreList(false)
+	}()
+
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nRUN --mount=type=cache,target=cache,id=test true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := stages[0].Commands[0].(*instructions.RunCommand)
+	teardown, err := setupRunMounts(cmd, &v1.Config{WorkingDir: "/work"}, RunOptions{CacheMountDir: cacheDir}, util.FileContext{})
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("bind mounts are not permitted")
+		}
+		t.Fatal(err)
+	}
+	target := filepath.Join(root, "work", "cache")
+	if !util.CheckIgnoreList(filepath.Join(target, "file")) {
+		t.Errorf("expected %s to be ignored", target)
+	}
+	if err := ioutil.WriteFile(filepath.Join(target, "file"), []byte("cached"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "file")); !os.IsNotExist(err) {
+		t.Errorf("expected the cache to be unmounted, got %v", err)
+	}
+	// What later commands write to the target makes it into their snapshots
+	if util.CheckIgnoreList(filepath.Join(target, "file")) {
+		t.Errorf("expected %s not to be ignored once unmounted", target)
+	}
+	dirs, err := ioutil.ReadDir(cacheDir)
+	if err != nil || len(dirs) != 1 {
+		t.Fatalf("expected one cache directory, got %v %v", dirs, err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(cacheDir, dirs[0].Name(), "file"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "cached", string(b))
+}
+
+func Test_mountSecret(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	kanikoDir, err := ioutil.TempDir("", "kaniko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(kanikoDir)
+	originalRoot, originalKanikoDir := kConfig.RootDir, kConfig.KanikoDir
+	kConfig.RootDir, kConfig.KanikoDir = root, kanikoDir
+	defer func() {
+		kConfig.RootDir, kConfig.KanikoDir = originalRoot, originalKanikoDir
+		util.InitIgnoreList(false)
+	}()
+	if err := os.MkdirAll(filepath.Join(root, "run"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1600000000, 0)
+	if err := os.Chtimes(filepath.Join(root, "run"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("KANIKO_TEST_SECRET", "s3cr3t")
+	defer os.Unsetenv("KANIKO_TEST_SECRET")
+	secrets := map[string]kConfig.Secret{"token": {Env: "KANIKO_TEST_SECRET"}}
+
+	m, err := dockerfile.ParseMount("type=secret,id=token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown, err := mountSecret(m, "/", secrets)
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("mounts are not permitted")
+		}
+		t.Fatal(err)
+	}
+	dest := filepath.Join(root, "run", "secrets", "token")
+	if !util.CheckIgnoreList(dest) {
+		t.Errorf("expected %s to be ignored", dest)
+	}
+	b, err := ioutil.ReadFile(dest)
+	testutil.CheckErrorAndDeepEqual(t, false, err, "s3cr3t", string(b))
+	fi, err := os.Stat(dest)
+	testutil.CheckErrorAndDeepEqual(t, false, err, os.FileMode(0400), fi.Mode().Perm())
+	// The secret is mounted read-only from a tmpfs
+	testutil.CheckError(t, true, ioutil.WriteFile(dest, []byte("changed"), 0644))
+
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "run", "secrets")); !os.IsNotExist(err) {
+		t.Errorf("expected the secret and its directory to be removed, got %v", err)
+	}
+	if util.CheckIgnoreList(dest) {
+		t.Errorf("expected %s not to be ignored once removed", dest)
+	}
+	if leftovers, err := ioutil.ReadDir(kanikoDir); err != nil || len(leftovers) != 0 {
+		t.Errorf("expected the tmpfs of the secret to be removed, got %v %v", leftovers, err)
+	}
+	fi, err = os.Stat(filepath.Join(root, "run"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, mtime.Unix(), fi.ModTime().Unix())
+
+	// A file at the target is put back once the command is done
+	existing := filepath.Join(root, "etc", "npmrc")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(existing, []byte("registry"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err = dockerfile.ParseMount("type=secret,id=token,target=/etc/npmrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown, err = mountSecret(m, "/", secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadFile(existing)
+	testutil.CheckDeepEqual(t, "s3cr3t", string(b))
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadFile(existing)
+	testutil.CheckDeepEqual(t, "registry", string(b))
+
+	// Missing secrets are skipped unless required
+	m, err = dockerfile.ParseMount("type=secret,id=other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown, err = mountSecret(m, "/", secrets)
+	testutil.CheckError(t, false, err)
+	testutil.CheckError(t, false, teardown())
+	m.Required = true
+	_, err = mountSecret(m, "/", secrets)
+	testutil.CheckError(t, true, err)
+}
+
+func Test_mountBind(t *testing.T) {
+	root, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	kanikoDir, err := ioutil.TempDir("", "kaniko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(kanikoDir)
+	buildContext, err := ioutil.TempDir("", "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildContext)
+	originalRoot, originalKanikoDir := kConfig.RootDir, kConfig.KanikoDir
+	kConfig.RootDir, kConfig.KanikoDir = root, kanikoDir
+	defer func() {
+		kConfig.RootDir, kConfig.KanikoDir = originalRoot, originalKanikoDir
+		util.InitIgnoreList(false)
+	}()
+
+	for path, content := range map[string]string{
+		filepath.Join(buildContext, "src", "main.go"):   "package main",
+		filepath.Join(buildContext, "src", "secret"):    "s3cr3t",
+		filepath.Join(kanikoDir, "0", "out", "app"):     "binary",
+		filepath.Join(kanikoDir, "alpine", "etc", "os"): "alpine",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileContext := util.FileContext{Root: buildContext}
+	stageNameToIdx := map[string]string{"builder": "0"}
+
+	m, err := dockerfile.ParseMount("type=bind,source=src,target=src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown, err := mountBind(m, "/work", stageNameToIdx, fileContext)
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			t.Skip("bind mounts are not permitted")
+		}
+		t.Fatal(err)
+	}
+	target := filepath.Join(root, "work", "src")
+	if !util.CheckIgnoreList(filepath.Join(target, "main.go")) {
+		t.Errorf("expected %s to be ignored", target)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(target, "main.go"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "package main", string(b))
+	testutil.CheckError(t, true, ioutil.WriteFile(filepath.Join(target, "new"), nil, 0644))
+	if err := teardown(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "work")); !os.IsNotExist(err) {
+		t.Errorf("expected the mount target to be removed, got %v", err)
+	}
+	if util.CheckIgnoreList(filepath.Join(target, "main.go")) {
+		t.Errorf("expected %s not to be ignored once unmounted", target)
+	}
+
+	// Files left out by .dockerignore are left out of the mount
+	fileContext.ExcludedFiles = []string{"src/secret"}
+	teardown, err = mountBind(m, "/work", stageNameToIdx, fileContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(target, "secret"))
+	testutil.CheckError(t, true, err)
+	testutil.CheckError(t, false, teardown())
+
+	// What is written to read-write mounts is thrown away
+	m, err = dockerfile.ParseMount("type=bind,from=builder,source=/out,target=/out,rw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown, err = mountBind(m, "/", stageNameToIdx, fileContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "out", "app"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckError(t, false, teardown())
+	b, err = ioutil.ReadFile(filepath.Join(kanikoDir, "0", "out", "app"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "binary", string(b))
+
+	// Single files are mounted from images
+	m, err = dockerfile.ParseMount("type=bind,from=alpine,source=/etc/os,target=/etc/os-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	teardown, err = mountBind(m, "/", stageNameToIdx, fileContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(root, "etc", "os-release"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "alpine", string(b))
+	testutil.CheckError(t, false, teardown())
+	if _, err := os.Stat(filepath.Join(root, "etc")); !os.IsNotExist(err) {
+		t.Errorf("expected the mount target to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// ifreqFlags is the struct ifreq of the SIOCGIFFLAGS and SIOCSIFFLAGS ioctls
+type ifreqFlags struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// startWithoutNetwork starts cmd in a network namespace of its own, where only the loopback interface is up
+func startWithoutNetwork(cmd *exec.Cmd) error {
+	errs := make(chan error, 1)
+	go func() {
+		// The thread is left in the new namespace, so it is never unlocked
+		// and goes away with the goroutine instead of running other ones
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			if err == syscall.EPERM {
+				errs <- errors.Wrap(err, "creating a network namespace, RUN --network=none needs the CAP_SYS_ADMIN capability")
+				return
+			}
+			errs <- errors.Wrap(err, "creating a network namespace")
+			return
+		}
+		if err := loopbackUp(); err != nil {
+			errs <- errors.Wrap(err, "bringing up the loopback interface")
+			return
+		}
+		errs <- cmd.Start()
+	}()
+	return <-errs
+}
+
+// loopbackUp brings up the loopback interface of the network namespace of the calling thread
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	req := ifreqFlags{}
+	copy(req.name[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	req.flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ package commands
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
@@ -25,11 +26,14 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 )
 
 func Test_addDefaultHOME(t *testing.T) {
@@ -268,7 +272,7 @@ func Test_CachingRunCommand_ExecuteCommand(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.desctiption, func(t *testing.T) {
 			c := tc.command
-			err := c.ExecuteCommand(config, buildArgs)
+			err := c.ExecuteCommand(context.TODO(), config, buildArgs)
 			if !tc.expectErr && err != nil {
 				t.Errorf("Expected err to be nil but was %v", err)
 			} else if tc.expectErr && err == nil {
@@ -325,3 +329,46 @@ func TestSetWorkDirIfExists(t *testing.T) {
 	testutil.CheckDeepEqual(t, testDir, setWorkDirIfExists(testDir))
 	testutil.CheckDeepEqual(t, "", setWorkDirIfExists("doesnot-exists"))
 }
+
+func TestRunCommandInExecTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cmd := &instructions.RunCommand{
+		ShellDependantCmdLine: instructions.ShellDependantCmdLine{
+			CmdLine:      []string{"sleep 10 & sleep 10"},
+			PrependShell: true,
+		},
+	}
+	start := time.Now()
+	err := runCommandInExec(ctx, &v1.Config{}, dockerfile.NewBuildArgs(nil), cmd, "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the command to be killed, it ran for %s", elapsed)
+	}
+}
+
+func TestRunCommandInExecWithoutNetwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "network")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "dev")
+
+	cmd := &instructions.RunCommand{
+		ShellDependantCmdLine: instructions.ShellDependantCmdLine{
+			CmdLine:      []string{"cut -d: -f1 /proc/net/dev | tail -n +3 | tr -d ' ' > " + out},
+			PrependShell: true,
+		},
+	}
+	err = runCommandInExec(context.Background(), &v1.Config{}, dockerfile.NewBuildArgs(nil), cmd, dockerfile.NetworkNone)
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("network namespaces are not permitted")
+	}
+	testutil.CheckError(t, false, err)
+	b, err := ioutil.ReadFile(out)
+	testutil.CheckErrorAndDeepEqual(t, false, err, "lo\n", string(b))
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/testutil"
@@ -49,7 +50,7 @@ func TestShellExecuteCmd(t *testing.T) {
 				Shell: test.cmdLine,
 			},
 		}
-		err := cmd.ExecuteCommand(cfg, nil)
+		err := cmd.ExecuteCommand(context.TODO(), cfg, nil)
 		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedShell, cfg.Shell)
 	}
 }
@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -56,7 +57,7 @@ func TestStopsignalExecuteCmd(t *testing.T) {
 			},
 		}
 		b := dockerfile.NewBuildArgs([]string{})
-		err := cmd.ExecuteCommand(cfg, b)
+		err := cmd.ExecuteCommand(context.TODO(), cfg, b)
 		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedSignal, cfg.StopSignal)
 	}
 }
@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"fmt"
 	"os/user"
 	"testing"
@@ -117,7 +118,7 @@ func TestUpdateUser(t *testing.T) {
 		}
 		defer func() { Lookup = util.Lookup }()
 		buildArgs := dockerfile.NewBuildArgs([]string{})
-		err := cmd.ExecuteCommand(cfg, buildArgs)
+		err := cmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedUID, cfg.User)
 	}
 }
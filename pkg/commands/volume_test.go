@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
@@ -51,6 +52,6 @@ func TestUpdateVolume(t *testing.T) {
 		"/etc":     {},
 	}
 	buildArgs := dockerfile.NewBuildArgs([]string{})
-	err := volumeCmd.ExecuteCommand(cfg, buildArgs)
+	err := volumeCmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 	testutil.CheckErrorAndDeepEqual(t, false, err, expectedVolumes, cfg.Volumes)
 }
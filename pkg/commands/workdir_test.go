@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -112,7 +113,7 @@ func TestWorkdirCommand(t *testing.T) {
 			snapshotFiles: nil,
 		}
 		buildArgs := dockerfile.NewBuildArgs([]string{})
-		cmd.ExecuteCommand(cfg, buildArgs)
+		cmd.ExecuteCommand(context.TODO(), cfg, buildArgs)
 		testutil.CheckErrorAndDeepEqual(t, false, nil, test.expectedPath, cfg.WorkingDir)
 		testutil.CheckErrorAndDeepEqual(t, false, nil, test.snapshotFiles, cmd.snapshotFiles)
 	}
@@ -18,8 +18,13 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/docker/go-units"
 	"github.com/sirupsen/logrus"
 )
 
@@ -82,3 +87,205 @@ func (a *keyValueArg) Set(value string) error {
 func (a *keyValueArg) Type() string {
 	return "key-value-arg type"
 }
+
+// Secret is a build secret set with --secret, which RUN --mount=type=secret exposes to its command
+type Secret struct {
+	// Src is the file holding the secret
+	Src string
+	// Env is the environment variable holding the secret
+	Env string
+}
+
+// Value reads the value of the secret
+func (s Secret) Value() ([]byte, error) {
+	if s.Env != "" {
+		v, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return []byte(v), nil
+	}
+	return ioutil.ReadFile(s.Src)
+}
+
+// This type is used to supported passing in multiple id=...,src=... or id=...,env=... secret flags
+type secretsArg map[string]Secret
+
+func (a *secretsArg) String() string {
+	var result []string
+	for id, s := range *a {
+		if s.Env != "" {
+			result = append(result, fmt.Sprintf("id=%s,env=%s", id, s.Env))
+		} else {
+			result = append(result, fmt.Sprintf("id=%s,src=%s", id, s.Src))
+		}
+	}
+	sort.Strings(result)
+	return strings.Join(result, " ")
+}
+
+func (a *secretsArg) Set(value string) error {
+	var id string
+	var s Secret
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid secret %s, expect id=<id>,src=<path> or id=<id>,env=<variable>", value)
+		}
+		switch strings.ToLower(parts[0]) {
+		case "id":
+			id = parts[1]
+		case "src", "source":
+			src, err := filepath.Abs(parts[1])
+			if err != nil {
+				return err
+			}
+			s.Src = src
+		case "env":
+			s.Env = parts[1]
+		default:
+			return fmt.Errorf("invalid secret %s, unexpected key %s", value, parts[0])
+		}
+	}
+	if id == "" || (s.Src == "") == (s.Env == "") {
+		return fmt.Errorf("invalid secret %s, expect id=<id>,src=<path> or id=<id>,env=<variable>", value)
+	}
+	if s.Src != "" {
+		if _, err := os.Stat(s.Src); err != nil {
+			return fmt.Errorf("invalid secret %s: %v", value, err)
+		}
+	}
+	if *a == nil {
+		*a = secretsArg{}
+	}
+	(*a)[id] = s
+	return nil
+}
+
+func (a *secretsArg) Type() string {
+	return "secret"
+}
+
+// This type is used to supported passing in multiple target=destination flags,
+// a target getting every destination it is given
+type targetDestinationsArg map[string][]string
+
+func (a *targetDestinationsArg) String() string {
+	var result []string
+	for target, destinations := range *a {
+		for _, d := range destinations {
+			result = append(result, fmt.Sprintf("%s=%s", target, d))
+		}
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
+}
+
+func (a *targetDestinationsArg) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid destination %s, expect <target>=<destination>", value)
+	}
+	if *a == nil {
+		*a = targetDestinationsArg{}
+	}
+	(*a)[parts[0]] = append((*a)[parts[0]], parts[1])
+	return nil
+}
+
+func (a *targetDestinationsArg) Type() string {
+	return "target-destination"
+}
+
+// The types of --output
+const (
+	OutputTypeLocal = "local"
+	OutputTypeTar   = "tar"
+)
+
+// Output is an export of the filesystem of a stage set with --output
+type Output struct {
+	// Type is either OutputTypeLocal, a directory, or OutputTypeTar, a tarball
+	Type string
+	// Dest is the directory or the tarball the filesystem is written to
+	Dest string
+	// Stage names the stage to export, the target if empty
+	Stage string
+}
+
+func (o Output) String() string {
+	s := fmt.Sprintf("type=%s,dest=%s", o.Type, o.Dest)
+	if o.Stage != "" {
+		s += ",stage=" + o.Stage
+	}
+	return s
+}
+
+// This type is used to supported passing in multiple type=...,dest=...[,stage=...] output flags
+type outputsArg []Output
+
+func (a *outputsArg) String() string {
+	var result []string
+	for _, o := range *a {
+		result = append(result, o.String())
+	}
+	return strings.Join(result, " ")
+}
+
+func (a *outputsArg) Set(value string) error {
+	var o Output
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid output %s, expect type=<local|tar>,dest=<path>[,stage=<name>]", value)
+		}
+		switch strings.ToLower(parts[0]) {
+		case "type":
+			o.Type = strings.ToLower(parts[1])
+		case "dest":
+			o.Dest = parts[1]
+		case "stage":
+			o.Stage = strings.ToLower(parts[1])
+		default:
+			return fmt.Errorf("invalid output %s, unexpected key %s", value, parts[0])
+		}
+	}
+	if o.Type != OutputTypeLocal && o.Type != OutputTypeTar {
+		return fmt.Errorf("invalid output %s, type must be %s or %s", value, OutputTypeLocal, OutputTypeTar)
+	}
+	if o.Dest == "" {
+		return fmt.Errorf("invalid output %s, dest is required", value)
+	}
+	*a = append(*a, o)
+	return nil
+}
+
+func (a *outputsArg) Type() string {
+	return "output"
+}
+
+// sizeArg is a size in bytes set with units, like 500MB or 1.5GB
+type sizeArg int64
+
+func (a *sizeArg) String() string {
+	if *a == 0 {
+		return ""
+	}
+	return units.BytesSize(float64(*a))
+}
+
+func (a *sizeArg) Set(value string) error {
+	size, err := units.RAMInBytes(value)
+	if err != nil {
+		return fmt.Errorf("invalid size %s: %s", value, err)
+	}
+	if size <= 0 {
+		return fmt.Errorf("invalid size %s, it must be positive", value)
+	}
+	*a = sizeArg(size)
+	return nil
+}
+
+func (a *sizeArg) Type() string {
+	return "size"
+}
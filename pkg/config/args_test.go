@@ -16,7 +16,11 @@ limitations under the License.
 
 package config
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
 
 func TestMultiArg_Set_shouldAppendValue(t *testing.T) {
 	var arg multiArg
@@ -45,3 +49,96 @@ func Test_KeyValueArg_Set_shouldAcceptEqualAsValue(t *testing.T) {
 		t.Error("Invalid split. key=value=something should be split to key=>value=something")
 	}
 }
+
+func Test_SecretsArg_Set(t *testing.T) {
+	f, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	var arg secretsArg
+	if err := arg.Set("id=file,src=" + f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if err := arg.Set("id=token,env=TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+	if arg["file"].Src != f.Name() || arg["token"].Env != "TOKEN" {
+		t.Errorf("Invalid secrets %v", arg)
+	}
+	for _, invalid := range []string{"src=" + f.Name(), "id=both,src=" + f.Name() + ",env=TOKEN", "id=none", "id=missing,src=/does/not/exist", "id=x,value=y"} {
+		if err := arg.Set(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func Test_Secret_Value(t *testing.T) {
+	os.Setenv("KANIKO_TEST_SECRET", "from env")
+	defer os.Unsetenv("KANIKO_TEST_SECRET")
+	v, err := Secret{Env: "KANIKO_TEST_SECRET"}.Value()
+	if err != nil || string(v) != "from env" {
+		t.Errorf("Expected the value of the environment variable, got %q %v", v, err)
+	}
+	if _, err := (Secret{Env: "KANIKO_TEST_UNSET_SECRET"}).Value(); err == nil {
+		t.Error("Expected an error for an unset environment variable")
+	}
+}
+
+func Test_TargetDestinationsArg_Set(t *testing.T) {
+	var arg targetDestinationsArg
+	for _, v := range []string{"api=reg/api:tag", "api=reg/api:latest", "worker=reg/worker:tag"} {
+		if err := arg.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(arg["api"]) != 2 || arg["api"][1] != "reg/api:latest" || len(arg["worker"]) != 1 {
+		t.Errorf("Invalid destinations %v", arg)
+	}
+	if s := arg.String(); s != "api=reg/api:latest,api=reg/api:tag,worker=reg/worker:tag" {
+		t.Errorf("Invalid string %s", s)
+	}
+	for _, invalid := range []string{"reg/api:tag", "=reg/api:tag", "api="} {
+		if err := arg.Set(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func Test_OutputsArg_Set(t *testing.T) {
+	var arg outputsArg
+	if err := arg.Set("type=local,dest=/out"); err != nil {
+		t.Fatal(err)
+	}
+	if err := arg.Set("type=tar,dest=out.tar,stage=Builder"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Output{{Type: OutputTypeLocal, Dest: "/out"}, {Type: OutputTypeTar, Dest: "out.tar", Stage: "builder"}}
+	if len(arg) != 2 || arg[0] != expected[0] || arg[1] != expected[1] {
+		t.Errorf("Invalid outputs %v", arg)
+	}
+	for _, invalid := range []string{"type=local", "dest=/out", "type=image,dest=/out", "type=tar,dest=out.tar,push=true", "type=local,/out"} {
+		if err := arg.Set(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func Test_SizeArg_Set(t *testing.T) {
+	var arg sizeArg
+	if err := arg.Set("1.5GB"); err != nil {
+		t.Fatal(err)
+	}
+	if arg != 1536*1024*1024 {
+		t.Errorf("Invalid size %d", arg)
+	}
+	if s := arg.String(); s != "1.5GiB" {
+		t.Errorf("Invalid string %s", s)
+	}
+	for _, invalid := range []string{"", "big", "-1MB", "0"} {
+		if err := arg.Set(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
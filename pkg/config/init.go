@@ -23,9 +23,11 @@ import (
 var RootDir string
 var KanikoDir string
 var IgnoreListPath string
+var IntermediateStagesDir string
 
 func init() {
 	RootDir = constants.RootDir
 	KanikoDir = constants.KanikoDir
 	IgnoreListPath = constants.IgnoreListPath
+	IntermediateStagesDir = constants.KanikoIntermediateStagesDir
 }
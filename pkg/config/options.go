@@ -50,21 +50,50 @@ type KanikoOptions struct {
 	DockerfilePath         string
 	SrcContext             string
 	SnapshotMode           string
+	Compression            string
 	CustomPlatform         string
 	Bucket                 string
 	TarPath                string
-	Target                 string
 	CacheRepo              string
+	CacheMountDir          string
+	RunNetwork             string
 	DigestFile             string
 	ImageNameDigestFile    string
 	ImageNameTagDigestFile string
 	OCILayoutPath          string
+	BaseImageLayout        string
+	Lockfile               string
+	LockfileOut            string
+	BaseImagePolicy        string
+	ImagePolicy            string
+	ImagePolicyReport      string
+	LayerReport            string
+	MaxLayerSize           sizeArg
+	MaxImageSize           sizeArg
+	OnFailureSnapshot      string
+	EventsFile             string
+	ProvenanceFile         string
+	SBOMFormat             string
+	SBOMFile               string
+	SignKey                string
+	VerifyBaseImageKey     string
 	Destinations           multiArg
+	Targets                multiArg
+	TargetDestinations     targetDestinationsArg
+	Outputs                outputsArg
+	Platforms              multiArg
 	BuildArgs              multiArg
 	Labels                 multiArg
+	Annotations            keyValueArg
+	SquashStages           multiArg
+	Secrets                secretsArg
 	SingleSnapshot         bool
+	Squash                 bool
 	Reproducible           bool
+	OCIMetadata            bool
 	NoPush                 bool
+	DryRun                 bool
+	PushProvenance         bool
 	Cache                  bool
 	Cleanup                bool
 	IgnoreVarRun           bool
@@ -74,6 +103,11 @@ type KanikoOptions struct {
 	Git                    KanikoGitOptions
 	IgnorePaths            multiArg
 	ImageFSExtractRetry    int
+	CompressionLevel       int
+	MaxParallelStages      int
+	Timeout                time.Duration
+	RunTimeout             time.Duration
+	SourceDateEpoch        time.Time
 }
 
 type KanikoGitOptions struct {
@@ -16,6 +16,8 @@ limitations under the License.
 
 package constants
 
+import "time"
+
 const (
 	// RootDir is the path to the root directory
 	RootDir = "/"
@@ -46,6 +48,11 @@ const (
 	SnapshotModeFull = "full"
 	SnapshotModeRedo = "redo"
 
+	// Layer compression algorithms:
+	CompressionGzip    = "gzip"
+	CompressionEstargz = "estargz"
+	CompressionZstd    = "zstd"
+
 	// NoBaseImage is the scratch image
 	NoBaseImage = "scratch"
 
@@ -70,6 +77,10 @@ const (
 	// S3 Custom endpoint ENV name
 	S3EndpointEnv    = "S3_ENDPOINT"
 	S3ForcePathStyle = "S3_FORCE_PATH_STYLE"
+
+	// TerminationGracePeriod is how long RUN commands and cache pushes get to
+	// finish after the build was cancelled before they are abandoned
+	TerminationGracePeriod = 10 * time.Second
 )
 
 // ScratchEnvVars are the default environment variables needed for a scratch image.
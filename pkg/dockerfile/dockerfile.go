@@ -36,23 +36,36 @@ import (
 )
 
 func ParseStages(opts *config.KanikoOptions) ([]instructions.Stage, []instructions.ArgCommand, error) {
+	d, err := ReadDockerfile(opts.DockerfilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ParseStagesFrom(d, opts)
+}
+
+// ReadDockerfile returns the content of the Dockerfile at path, a file or an http(s) URL
+func ReadDockerfile(path string) ([]byte, error) {
 	var err error
 	var d []uint8
-	match, _ := regexp.MatchString("^https?://", opts.DockerfilePath)
+	match, _ := regexp.MatchString("^https?://", path)
 	if match {
-		response, e := http.Get(opts.DockerfilePath)
+		response, e := http.Get(path)
 		if e != nil {
-			return nil, nil, e
+			return nil, e
 		}
 		d, err = ioutil.ReadAll(response.Body)
 	} else {
-		d, err = ioutil.ReadFile(opts.DockerfilePath)
+		d, err = ioutil.ReadFile(path)
 	}
 
 	if err != nil {
-		return nil, nil, errors.Wrap(err, fmt.Sprintf("reading dockerfile at path %s", opts.DockerfilePath))
+		return nil, errors.Wrap(err, fmt.Sprintf("reading dockerfile at path %s", path))
 	}
+	return d, nil
+}
 
+// ParseStagesFrom parses the Dockerfile content d like ParseStages
+func ParseStagesFrom(d []byte, opts *config.KanikoOptions) ([]instructions.Stage, []instructions.ArgCommand, error) {
 	stages, metaArgs, err := Parse(d)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "parsing dockerfile")
@@ -89,6 +102,9 @@ func Parse(b []byte) ([]instructions.Stage, []instructions.ArgCommand, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := extractRunFlags(p.AST); err != nil {
+		return nil, nil, err
+	}
 	stages, metaArgs, err := instructions.Parse(p.AST)
 	if err != nil {
 		return nil, nil, err
@@ -202,6 +218,23 @@ func targetStage(stages []instructions.Stage, target string) (int, error) {
 	return -1, fmt.Errorf("%s is not a valid target build stage", target)
 }
 
+// validSquashStages returns an error if one of names isn't the name of a stage
+func validSquashStages(stages []instructions.Stage, names []string) error {
+	for _, name := range names {
+		found := false
+		for _, stage := range stages {
+			if stage.Name == strings.ToLower(name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not a valid build stage to squash", name)
+		}
+	}
+	return nil
+}
+
 // ParseCommands parses an array of commands into an array of instructions.Command; used for onbuild
 func ParseCommands(cmdArray []string) ([]instructions.Command, error) {
 	var cmds []instructions.Command
@@ -210,6 +243,9 @@ func ParseCommands(cmdArray []string) ([]instructions.Command, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := extractRunFlags(ast.AST); err != nil {
+		return nil, err
+	}
 	for _, child := range ast.AST.Children {
 		cmd, err := instructions.ParseCommand(child)
 		if err != nil {
@@ -270,16 +306,55 @@ func resolveStagesArgs(stages []instructions.Stage, args []string) error {
 }
 
 func MakeKanikoStages(opts *config.KanikoOptions, stages []instructions.Stage, metaArgs []instructions.ArgCommand) ([]config.KanikoStage, error) {
-	targetStage, err := targetStage(stages, opts.Target)
-	if err != nil {
-		return nil, errors.Wrap(err, "Error finding target stage")
+	targets := []string(opts.Targets)
+	if len(targets) == 0 {
+		targets = []string{""}
+	}
+	var targetStages []int
+	for _, target := range targets {
+		targetStage, err := targetStage(stages, target)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error finding target stage")
+		}
+		targetStages = append(targetStages, targetStage)
+	}
+	// The stages exported with --output are built along with the targets, without being targets
+	needed := append([]string{}, targets...)
+	for _, o := range opts.Outputs {
+		if o.Stage == "" {
+			continue
+		}
+		outputStage, err := targetStage(stages, o.Stage)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error finding output stage")
+		}
+		needed = append(needed, o.Stage)
+		targetStages = append(targetStages, outputStage)
+	}
+	if err := validSquashStages(stages, opts.SquashStages); err != nil {
+		return nil, err
 	}
 	args := unifyArgs(metaArgs, opts.BuildArgs)
 	if err := resolveStagesArgs(stages, args); err != nil {
 		return nil, errors.Wrap(err, "resolving args")
 	}
 	if opts.SkipUnusedStages {
-		stages = skipUnusedStages(stages, &targetStage, opts.Target)
+		if len(needed) == 1 {
+			stages = skipUnusedStages(stages, &targetStages[0], needed[0])
+		} else {
+			stages, targetStages = skipUnusedStagesOfTargets(stages, targetStages, needed)
+		}
+	}
+	// Stages are built up to the last stage needed, each of them once however many targets need it
+	isTarget := make(map[int]bool)
+	lastTargetStage := 0
+	for i, targetStage := range targetStages {
+		if i < len(targets) {
+			isTarget[targetStage] = true
+		}
+		if targetStage > lastTargetStage {
+			lastTargetStage = targetStage
+		}
 	}
 	var kanikoStages []config.KanikoStage
 	for index, stage := range stages {
@@ -292,11 +367,11 @@ func MakeKanikoStages(opts *config.KanikoOptions, stages []instructions.Stage, m
 			BaseImageIndex:         baseImageIndex,
 			BaseImageStoredLocally: (baseImageIndex != -1),
 			SaveStage:              saveStage(index, stages),
-			Final:                  index == targetStage,
+			Final:                  isTarget[index],
 			MetaArgs:               metaArgs,
 			Index:                  index,
 		})
-		if index == targetStage {
+		if index == lastTargetStage {
 			break
 		}
 	}
@@ -343,9 +418,44 @@ func unifyArgs(metaArgs []instructions.ArgCommand, buildArgs []string) []string
 
 // skipUnusedStages returns the list of used stages without the unnecessaries ones
 func skipUnusedStages(stages []instructions.Stage, lastStageIndex *int, target string) []instructions.Stage {
-	stagesDependencies := make(map[string]bool)
 	var onlyUsedStages []instructions.Stage
-	idx := *lastStageIndex
+	for _, i := range usedStages(stages, *lastStageIndex, target) {
+		onlyUsedStages = append(onlyUsedStages, stages[i])
+	}
+	if *lastStageIndex > len(onlyUsedStages)-1 {
+		*lastStageIndex = len(onlyUsedStages) - 1
+	}
+	return onlyUsedStages
+}
+
+// skipUnusedStagesOfTargets returns the stages used by any of the targets, whose
+// indexes in stages are targetIndexes, along with the indexes of the targets in them
+func skipUnusedStagesOfTargets(stages []instructions.Stage, targetIndexes []int, targets []string) ([]instructions.Stage, []int) {
+	used := make(map[int]bool)
+	for i, idx := range targetIndexes {
+		for _, u := range usedStages(stages, idx, targets[i]) {
+			used[u] = true
+		}
+	}
+	var onlyUsedStages []instructions.Stage
+	newIndexes := make(map[int]int)
+	for i, s := range stages {
+		if used[i] {
+			newIndexes[i] = len(onlyUsedStages)
+			onlyUsedStages = append(onlyUsedStages, s)
+		}
+	}
+	var onlyUsedTargetIndexes []int
+	for _, idx := range targetIndexes {
+		onlyUsedTargetIndexes = append(onlyUsedTargetIndexes, newIndexes[idx])
+	}
+	return onlyUsedStages, onlyUsedTargetIndexes
+}
+
+// usedStages returns the indexes of the stages the stage at idx needs to be built, its own included
+func usedStages(stages []instructions.Stage, idx int, target string) []int {
+	stagesDependencies := make(map[string]bool)
+	var used []int
 
 	lastStageBaseName := stages[idx].BaseName
 
@@ -362,6 +472,21 @@ func skipUnusedStages(stages []instructions.Stage, lastStageIndex *int, target s
 					if !stagesDependencies[stageName] {
 						stagesDependencies[stageName] = true
 					}
+				case *instructions.RunCommand:
+					mounts, err := RunMounts(cmd)
+					if err != nil {
+						continue
+					}
+					for _, m := range mounts {
+						if m.Type != MountTypeBind || m.From == "" {
+							continue
+						}
+						stageName := strings.ToLower(m.From)
+						if fromIndex, err := strconv.Atoi(stageName); err == nil && fromIndex >= 0 && fromIndex < len(stages) {
+							stageName = stages[fromIndex].Name
+						}
+						stagesDependencies[stageName] = true
+					}
 				}
 			}
 			if i != idx {
@@ -371,7 +496,10 @@ func skipUnusedStages(stages []instructions.Stage, lastStageIndex *int, target s
 	}
 	dependenciesLen := len(stagesDependencies)
 	if target == "" && dependenciesLen == 0 {
-		return stages
+		for i := range stages {
+			used = append(used, i)
+		}
+		return used
 	} else if dependenciesLen > 0 {
 		for i := 0; i < idx; i++ {
 			if stages[i].Name == "" {
@@ -379,14 +507,9 @@ func skipUnusedStages(stages []instructions.Stage, lastStageIndex *int, target s
 			}
 			s := stages[i]
 			if stagesDependencies[s.Name] || s.Name == lastStageBaseName {
-				onlyUsedStages = append(onlyUsedStages, s)
+				used = append(used, i)
 			}
 		}
 	}
-	onlyUsedStages = append(onlyUsedStages, stages[idx])
-	if idx > len(onlyUsedStages)-1 {
-		*lastStageIndex = len(onlyUsedStages) - 1
-	}
-
-	return onlyUsedStages
+	return append(used, idx)
 }
@@ -306,6 +306,23 @@ func Test_targetStage(t *testing.T) {
 	}
 }
 
+func Test_validSquashStages(t *testing.T) {
+	dockerfile := `
+	FROM scratch AS builder
+	RUN echo hi > /hi
+
+	FROM scratch
+	COPY --from=builder /hi /hi
+	`
+	stages, _, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckError(t, false, validSquashStages(stages, nil))
+	testutil.CheckError(t, false, validSquashStages(stages, []string{"Builder"}))
+	testutil.CheckError(t, true, validSquashStages(stages, []string{"builder", "invalid"}))
+}
+
 func Test_SaveStage(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -627,6 +644,29 @@ func Test_SkipingUnusedStages(t *testing.T) {
 				"":      4,
 			},
 		},
+		{
+			description: "dockerfile_with_run_bind_mounts",
+			dockerfile: `
+			FROM alpine:3.11 AS deps
+			RUN echo deps > /hi
+			FROM alpine:3.11 AS unused
+			RUN echo unused > /hi
+			FROM alpine:3.11 AS tools
+			RUN echo tools > /hi
+			FROM alpine:3.11
+			RUN --mount=from=Deps,target=/deps --mount=from=2,source=/hi,target=/tools cat /deps/hi /tools
+			`,
+			targets: []string{""},
+			expectedSourceCodes: map[string][]string{
+				"": {"FROM alpine:3.11 AS deps", "FROM alpine:3.11 AS tools", "FROM alpine:3.11"},
+			},
+			expectedTargetIndexBeforeSkip: map[string]int{
+				"": 3,
+			},
+			expectedTargetIndexAfterSkip: map[string]int{
+				"": 2,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -649,3 +689,81 @@ func Test_SkipingUnusedStages(t *testing.T) {
 		}
 	}
 }
+
+func Test_MakeKanikoStages_MultipleTargets(t *testing.T) {
+	dockerfile := `
+	FROM alpine:3.11 AS deps
+	RUN echo deps > /hi
+	FROM alpine:3.11 AS unused
+	FROM deps AS api
+	FROM alpine:3.11 AS tools
+	FROM deps AS worker
+	COPY --from=tools /hi /tools
+	FROM alpine:3.11 AS last
+	`
+	tests := []struct {
+		description      string
+		skipUnusedStages bool
+		expectedNames    []string
+		expectedFinal    []bool
+	}{
+		{
+			description:   "every stage up to the last target",
+			expectedNames: []string{"deps", "unused", "api", "tools", "worker"},
+			expectedFinal: []bool{false, false, true, false, true},
+		},
+		{
+			description:      "only the stages the targets use",
+			skipUnusedStages: true,
+			expectedNames:    []string{"deps", "api", "tools", "worker"},
+			expectedFinal:    []bool{false, true, false, true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			stages, metaArgs, err := Parse([]byte(dockerfile))
+			testutil.CheckError(t, false, err)
+			opts := &config.KanikoOptions{
+				Targets:          []string{"worker", "api"},
+				SkipUnusedStages: test.skipUnusedStages,
+			}
+			kanikoStages, err := MakeKanikoStages(opts, stages, metaArgs)
+			testutil.CheckError(t, false, err)
+			var names []string
+			var final []bool
+			for i, s := range kanikoStages {
+				names = append(names, s.Name)
+				final = append(final, s.Final)
+				testutil.CheckDeepEqual(t, i, s.Index)
+			}
+			testutil.CheckDeepEqual(t, test.expectedNames, names)
+			testutil.CheckDeepEqual(t, test.expectedFinal, final)
+		})
+	}
+}
+
+func Test_MakeKanikoStages_OutputStage(t *testing.T) {
+	dockerfile := `
+	FROM alpine:3.11 AS app
+	FROM alpine:3.11 AS unused
+	FROM alpine:3.11 AS reports
+	`
+	stages, metaArgs, err := Parse([]byte(dockerfile))
+	testutil.CheckError(t, false, err)
+	opts := &config.KanikoOptions{
+		Targets:          []string{"app"},
+		Outputs:          []config.Output{{Type: config.OutputTypeLocal, Dest: "/out", Stage: "reports"}},
+		SkipUnusedStages: true,
+	}
+	kanikoStages, err := MakeKanikoStages(opts, stages, metaArgs)
+	testutil.CheckError(t, false, err)
+	testutil.CheckDeepEqual(t, 2, len(kanikoStages))
+	testutil.CheckDeepEqual(t, "app", kanikoStages[0].Name)
+	testutil.CheckDeepEqual(t, true, kanikoStages[0].Final)
+	testutil.CheckDeepEqual(t, "reports", kanikoStages[1].Name)
+	testutil.CheckDeepEqual(t, false, kanikoStages[1].Final)
+
+	opts.Outputs[0].Stage = "missing"
+	_, err = MakeKanikoStages(opts, stages, metaArgs)
+	testutil.CheckError(t, true, err)
+}
@@ -0,0 +1,371 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/pkg/errors"
+)
+
+// The types of RUN --mount
+const (
+	MountTypeBind   = "bind"
+	MountTypeCache  = "cache"
+	MountTypeTmpfs  = "tmpfs"
+	MountTypeSecret = "secret"
+	MountTypeSSH    = "ssh"
+)
+
+// The sharing modes of RUN --mount=type=cache
+const (
+	MountSharingShared  = "shared"
+	MountSharingPrivate = "private"
+	MountSharingLocked  = "locked"
+)
+
+// The networks of RUN --network
+const (
+	NetworkDefault = "default"
+	NetworkNone    = "none"
+)
+
+const (
+	mountFlag   = "--mount="
+	networkFlag = "--network="
+)
+
+// SecretsDir is where secret mounts go when they don't set a target
+const SecretsDir = "/run/secrets"
+
+var supportedMountTypes = map[string]bool{
+	MountTypeBind:   true,
+	MountTypeCache:  true,
+	MountTypeSecret: true,
+}
+
+var allowedSharingTypes = map[string]bool{
+	MountSharingShared:  true,
+	MountSharingPrivate: true,
+	MountSharingLocked:  true,
+}
+
+// Mount is a mount requested with RUN --mount.
+// The vendored parser only knows about them when built with the dfrunmount tag,
+// so they are taken off the RUN instructions before parsing and read back with RunMounts.
+// The same goes for RUN --network and RunNetwork.
+// The ID of a cache mount names its cache, its target by default, and the one of a
+// secret mount names the secret.
+type Mount struct {
+	Type         string
+	From         string
+	Source       string
+	Target       string
+	ReadOnly     bool
+	ID           string
+	CacheSharing string
+	Required     bool
+	Mode         *uint64
+	UID          *uint64
+	GID          *uint64
+}
+
+// ParseMount parses the value of a RUN --mount flag, e.g. type=cache,target=/root/.cache
+func ParseMount(value string) (*Mount, error) {
+	fields, err := csv.NewReader(strings.NewReader(value)).Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse csv mounts")
+	}
+
+	m := &Mount{Type: MountTypeBind}
+	roAuto := true
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(parts[0])
+
+		if len(parts) == 1 {
+			switch key {
+			case "readonly", "ro":
+				m.ReadOnly = true
+				roAuto = false
+				continue
+			case "readwrite", "rw":
+				m.ReadOnly = false
+				roAuto = false
+				continue
+			case "required":
+				if m.Type != MountTypeSecret && m.Type != MountTypeSSH {
+					return nil, errors.Errorf("unexpected key '%s' for mount type '%s'", key, m.Type)
+				}
+				m.Required = true
+				continue
+			}
+			return nil, errors.Errorf("invalid field '%s' must be a key=value pair", field)
+		}
+
+		value := parts[1]
+		switch key {
+		case "type":
+			m.Type = strings.ToLower(value)
+			if !supportedMountTypes[m.Type] {
+				return nil, errors.Errorf("unsupported mount type %q", value)
+			}
+		case "from":
+			m.From = value
+		case "source", "src":
+			m.Source = value
+		case "target", "dst", "destination":
+			m.Target = value
+		case "readonly", "ro":
+			if m.ReadOnly, err = strconv.ParseBool(value); err != nil {
+				return nil, errors.Errorf("invalid value for %s: %s", key, value)
+			}
+			roAuto = false
+		case "readwrite", "rw":
+			rw, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.Errorf("invalid value for %s: %s", key, value)
+			}
+			m.ReadOnly = !rw
+			roAuto = false
+		case "required":
+			if m.Type != MountTypeSecret && m.Type != MountTypeSSH {
+				return nil, errors.Errorf("unexpected key '%s' for mount type '%s'", key, m.Type)
+			}
+			if m.Required, err = strconv.ParseBool(value); err != nil {
+				return nil, errors.Errorf("invalid value for %s: %s", key, value)
+			}
+		case "id":
+			m.ID = value
+		case "sharing":
+			m.CacheSharing = strings.ToLower(value)
+			if !allowedSharingTypes[m.CacheSharing] {
+				return nil, errors.Errorf("unsupported sharing value %q", value)
+			}
+		case "mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return nil, errors.Errorf("invalid value %s for mode", value)
+			}
+			m.Mode = &mode
+		case "uid":
+			uid, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("invalid value %s for uid", value)
+			}
+			m.UID = &uid
+		case "gid":
+			gid, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, errors.Errorf("invalid value %s for gid", value)
+			}
+			m.GID = &gid
+		default:
+			return nil, errors.Errorf("unexpected key '%s' in '%s'", key, field)
+		}
+	}
+
+	if !supportedMountTypes[m.Type] {
+		return nil, errors.Errorf("unsupported mount type %q", m.Type)
+	}
+	fileInfoAllowed := m.Type == MountTypeSecret || m.Type == MountTypeSSH || m.Type == MountTypeCache
+	if (m.Mode != nil || m.UID != nil || m.GID != nil) && !fileInfoAllowed {
+		return nil, errors.Errorf("mode, uid and gid are not allowed for %q type mounts", m.Type)
+	}
+	if roAuto {
+		m.ReadOnly = m.Type != MountTypeCache && m.Type != MountTypeTmpfs
+	}
+	if m.CacheSharing != "" && m.Type != MountTypeCache {
+		return nil, errors.Errorf("invalid cache sharing set for %v mount", m.Type)
+	}
+	if m.Type == MountTypeBind && m.Target == "" {
+		return nil, errors.New("bind mount requires a target")
+	}
+	if m.Type == MountTypeCache {
+		if m.Target == "" {
+			return nil, errors.New("cache mount requires a target")
+		}
+		if m.From != "" {
+			return nil, errors.New("cache mounts from a stage or an image are not supported")
+		}
+		if m.ID == "" {
+			m.ID = m.Target
+		}
+		if m.CacheSharing == "" {
+			m.CacheSharing = MountSharingShared
+		}
+	}
+	if m.Type == MountTypeSecret {
+		if m.From != "" {
+			return nil, errors.New("secret mount should not have a from")
+		}
+		if m.Source != "" && m.ID != "" {
+			return nil, errors.New("both source and id can't be set")
+		}
+		// A secret has no source in the context, source= is another way to name it
+		if m.Source != "" {
+			m.ID, m.Source = m.Source, ""
+		}
+		if m.ID == "" {
+			if m.Target == "" {
+				return nil, errors.New("invalid secret mount. one of id, source, target required")
+			}
+			m.ID = path.Base(m.Target)
+		}
+		if m.Target == "" {
+			m.Target = path.Join(SecretsDir, path.Base(m.ID))
+		}
+	}
+	return m, nil
+}
+
+// ResolveFrom returns the index of the stage m mounts files from, resolving stage
+// names the same way ResolveCrossStageCommands does, or the image it names otherwise
+func (m *Mount) ResolveFrom(stageNameToIdx map[string]string) string {
+	if idx, ok := stageNameToIdx[strings.ToLower(m.From)]; ok {
+		return idx
+	}
+	return m.From
+}
+
+// RunMounts returns the mounts requested by the --mount flags of cmd
+func RunMounts(cmd *instructions.RunCommand) ([]*Mount, error) {
+	flags, err := runFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var mounts []*Mount
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, mountFlag) {
+			continue
+		}
+		m, err := ParseMount(strings.TrimPrefix(flag, mountFlag))
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// RunNetwork returns the network requested by the --network flag of cmd, or "" if it doesn't set one
+func RunNetwork(cmd *instructions.RunCommand) (string, error) {
+	flags, err := runFlags(cmd)
+	if err != nil {
+		return "", err
+	}
+	network := ""
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, networkFlag) {
+			network = strings.TrimPrefix(flag, networkFlag)
+		}
+	}
+	return network, nil
+}
+
+// ValidNetwork returns an error if network isn't one RUN commands can run in
+func ValidNetwork(network string) error {
+	switch network {
+	case NetworkDefault, NetworkNone:
+		return nil
+	}
+	return errors.Errorf("unsupported network %q, must be %s or %s", network, NetworkNone, NetworkDefault)
+}
+
+// runFlags returns the flags of cmd, the ones extractRunFlags took off included
+func runFlags(cmd *instructions.RunCommand) ([]string, error) {
+	res, err := parser.Parse(strings.NewReader(cmd.String()))
+	if err != nil {
+		return nil, err
+	}
+	var flags []string
+	for _, node := range res.AST.Children {
+		flags = append(flags, node.Flags...)
+	}
+	return flags, nil
+}
+
+// extractRunFlags takes the --mount and --network flags off the RUN instructions
+// of the Dockerfile for the vendored parser to accept them, making sure they are valid
+func extractRunFlags(ast *parser.Node) error {
+	for _, node := range ast.Children {
+		if node.Value != command.Run {
+			continue
+		}
+		var flags []string
+		for _, flag := range node.Flags {
+			switch {
+			case strings.HasPrefix(flag, mountFlag):
+				if _, err := ParseMount(strings.TrimPrefix(flag, mountFlag)); err != nil {
+					return errors.Wrapf(err, "parsing %s", node.Original)
+				}
+			case strings.HasPrefix(flag, networkFlag):
+				if err := ValidNetwork(strings.TrimPrefix(flag, networkFlag)); err != nil {
+					return errors.Wrapf(err, "parsing %s", node.Original)
+				}
+			default:
+				flags = append(flags, flag)
+			}
+		}
+		node.Flags = flags
+	}
+	return nil
+}
+
+// WithoutCacheMounts returns the RUN instruction line without its cache mounts.
+// What they hold doesn't change the result of the command, so they are kept
+// out of its cache key. Other lines are returned as they are.
+func WithoutCacheMounts(line string) string {
+	if !strings.Contains(line, mountFlag) {
+		return line
+	}
+	res, err := parser.Parse(strings.NewReader(line))
+	if err != nil || len(res.AST.Children) != 1 || res.AST.Children[0].Value != command.Run {
+		return line
+	}
+	node := res.AST.Children[0]
+	parts := []string{strings.ToUpper(command.Run)}
+	for _, flag := range node.Flags {
+		if strings.HasPrefix(flag, mountFlag) {
+			if m, err := ParseMount(strings.TrimPrefix(flag, mountFlag)); err == nil && m.Type == MountTypeCache {
+				continue
+			}
+		}
+		parts = append(parts, flag)
+	}
+	var args []string
+	for n := node.Next; n != nil; n = n.Next {
+		args = append(args, n.Value)
+	}
+	if node.Attributes["json"] {
+		b, err := json.Marshal(args)
+		if err != nil {
+			return line
+		}
+		parts = append(parts, string(b))
+	} else {
+		parts = append(parts, args...)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+)
+
+func Test_ParseMount(t *testing.T) {
+	mode := uint64(0700)
+	uid := uint64(1000)
+	secretMode := uint64(0440)
+	gid := uint64(10)
+	tests := []struct {
+		description string
+		value       string
+		expected    *Mount
+		shouldErr   bool
+	}{
+		{
+			description: "cache mount defaults",
+			value:       "type=cache,target=/root/.cache",
+			expected: &Mount{
+				Type:         MountTypeCache,
+				Target:       "/root/.cache",
+				ID:           "/root/.cache",
+				CacheSharing: MountSharingShared,
+			},
+		},
+		{
+			description: "cache mount with every option",
+			value:       "type=cache,dst=/var/cache/apt,id=apt,sharing=locked,mode=0700,uid=1000,ro",
+			expected: &Mount{
+				Type:         MountTypeCache,
+				Target:       "/var/cache/apt",
+				ID:           "apt",
+				CacheSharing: MountSharingLocked,
+				ReadOnly:     true,
+				Mode:         &mode,
+				UID:          &uid,
+			},
+		},
+		{
+			description: "cache mount without target",
+			value:       "type=cache,id=apt",
+			shouldErr:   true,
+		},
+		{
+			description: "cache mount from a stage",
+			value:       "type=cache,target=/cache,from=builder",
+			shouldErr:   true,
+		},
+		{
+			description: "unknown sharing",
+			value:       "type=cache,target=/cache,sharing=everyone",
+			shouldErr:   true,
+		},
+		{
+			description: "unknown key",
+			value:       "type=cache,target=/cache,size=10",
+			shouldErr:   true,
+		},
+		{
+			description: "secret mount defaults",
+			value:       "type=secret,id=npmrc",
+			expected: &Mount{
+				Type:     MountTypeSecret,
+				Target:   "/run/secrets/npmrc",
+				ID:       "npmrc",
+				ReadOnly: true,
+			},
+		},
+		{
+			description: "secret mount with target only",
+			value:       "type=secret,target=/root/.npmrc,required",
+			expected: &Mount{
+				Type:     MountTypeSecret,
+				Target:   "/root/.npmrc",
+				ID:       ".npmrc",
+				ReadOnly: true,
+				Required: true,
+			},
+		},
+		{
+			description: "secret mount with source",
+			value:       "type=secret,source=token,mode=0440,gid=10",
+			expected: &Mount{
+				Type:     MountTypeSecret,
+				Target:   "/run/secrets/token",
+				ID:       "token",
+				ReadOnly: true,
+				Mode:     &secretMode,
+				GID:      &gid,
+			},
+		},
+		{
+			description: "secret mount without id",
+			value:       "type=secret",
+			shouldErr:   true,
+		},
+		{
+			description: "secret mount with sharing",
+			value:       "type=secret,id=token,sharing=locked",
+			shouldErr:   true,
+		},
+		{
+			description: "unsupported type",
+			value:       "type=tmpfs,target=/tmp",
+			shouldErr:   true,
+		},
+		{
+			description: "bind mount by default",
+			value:       "target=/src",
+			expected: &Mount{
+				Type:     MountTypeBind,
+				Target:   "/src",
+				ReadOnly: true,
+			},
+		},
+		{
+			description: "read-write bind mount from a stage",
+			value:       "type=bind,from=builder,source=/out,target=/in,rw",
+			expected: &Mount{
+				Type:   MountTypeBind,
+				From:   "builder",
+				Source: "/out",
+				Target: "/in",
+			},
+		},
+		{
+			description: "bind mount without target",
+			value:       "type=bind,source=src",
+			shouldErr:   true,
+		},
+		{
+			description: "bind mount with mode",
+			value:       "type=bind,target=/src,mode=0700",
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			m, err := ParseMount(test.value)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, m)
+		})
+	}
+}
+
+func Test_Mount_ResolveFrom(t *testing.T) {
+	stageNameToIdx := map[string]string{"builder": "0"}
+	tests := []struct {
+		from     string
+		expected string
+	}{
+		{from: "builder", expected: "0"},
+		{from: "Builder", expected: "0"},
+		{from: "1", expected: "1"},
+		{from: "alpine:3.12", expected: "alpine:3.12"},
+	}
+	for _, test := range tests {
+		m := &Mount{Type: MountTypeBind, From: test.from}
+		testutil.CheckDeepEqual(t, test.expected, m.ResolveFrom(stageNameToIdx))
+	}
+}
+
+func Test_Parse_RunMounts(t *testing.T) {
+	dockerfile := `
+FROM scratch
+RUN --mount=type=cache,target=/root/.cache,id=go \
+    --mount=type=cache,target=/go/pkg/mod go build ./...
+RUN echo hi
+`
+	stages, _, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := stages[0].Commands[0].(*instructions.RunCommand)
+	testutil.CheckDeepEqual(t, "go build ./...", run.CmdLine[0])
+	mounts, err := RunMounts(run)
+	testutil.CheckError(t, false, err)
+	testutil.CheckDeepEqual(t, 2, len(mounts))
+	testutil.CheckDeepEqual(t, "go", mounts[0].ID)
+	testutil.CheckDeepEqual(t, "/go/pkg/mod", mounts[1].Target)
+
+	mounts, err = RunMounts(stages[0].Commands[1].(*instructions.RunCommand))
+	testutil.CheckErrorAndDeepEqual(t, false, err, 0, len(mounts))
+
+	_, _, err = Parse([]byte("FROM scratch\nRUN --mount=type=cache true\n"))
+	testutil.CheckError(t, true, err)
+}
+
+func Test_Parse_RunNetwork(t *testing.T) {
+	dockerfile := `
+FROM scratch
+RUN --network=none --mount=type=cache,target=/root/.cache go test ./...
+RUN --network=default curl -O https://example.com/file
+RUN echo hi
+`
+	stages, _, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []string{NetworkNone, NetworkDefault, ""} {
+		network, err := RunNetwork(stages[0].Commands[i].(*instructions.RunCommand))
+		testutil.CheckErrorAndDeepEqual(t, false, err, expected, network)
+	}
+
+	_, _, err = Parse([]byte("FROM scratch\nRUN --network=host true\n"))
+	testutil.CheckError(t, true, err)
+}
+
+func Test_WithoutCacheMounts(t *testing.T) {
+	tests := []struct {
+		description string
+		line        string
+		expected    string
+	}{
+		{
+			description: "no mounts",
+			line:        "RUN go build ./...",
+			expected:    "RUN go build ./...",
+		},
+		{
+			description: "cache mounts",
+			line:        "RUN --mount=type=cache,target=/root/.cache --mount=type=cache,target=/go/pkg/mod,id=mod go build ./...",
+			expected:    "RUN go build ./...",
+		},
+		{
+			description: "exec form",
+			line:        `RUN --mount=type=cache,target=/root/.cache ["go", "build", "./..."]`,
+			expected:    `RUN ["go","build","./..."]`,
+		},
+		{
+			description: "other commands",
+			line:        "COPY --mount=type=cache,target=/x a b",
+			expected:    "COPY --mount=type=cache,target=/x a b",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, WithoutCacheMounts(test.line))
+		})
+	}
+	testutil.CheckDeepEqual(t,
+		WithoutCacheMounts("RUN --mount=type=cache,target=/a go build"),
+		WithoutCacheMounts("RUN --mount=type=cache,target=/b,id=other go build"),
+	)
+}
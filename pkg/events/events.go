@@ -0,0 +1,178 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// For testing
+var currentTimeFunc = time.Now
+
+// Type is the type of an Event
+type Type string
+
+// The types of the events emitted during a build
+const (
+	StageStarted         Type = "stage-started"
+	StageFinished        Type = "stage-finished"
+	BaseImageResolved    Type = "base-image-resolved"
+	CommandStarted       Type = "command-started"
+	CommandFinished      Type = "command-finished"
+	CacheHit             Type = "cache-hit"
+	CacheMiss            Type = "cache-miss"
+	SnapshotTaken        Type = "snapshot-taken"
+	LayerPushed          Type = "layer-pushed"
+	ImagePushed          Type = "image-pushed"
+	AttestationPushed    Type = "attestation-pushed"
+	SBOMPushed           Type = "sbom-pushed"
+	SignaturePushed      Type = "signature-pushed"
+	FailureSnapshotSaved Type = "failure-snapshot-saved"
+)
+
+// Event is one line of the event stream. Fields which don't apply to its Type are omitted.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Stage is the index of the stage in the Dockerfile
+	Stage     *int   `json:"stage,omitempty"`
+	StageName string `json:"stageName,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Digest    string `json:"digest,omitempty"`
+	CacheKey  string `json:"cacheKey,omitempty"`
+	// DurationMs is how long the stage or command took in milliseconds
+	DurationMs int64 `json:"durationMs,omitempty"`
+	// Files is the number of files and whiteouts in a snapshot
+	Files int `json:"files,omitempty"`
+	// Size is the uncompressed size of a snapshot in bytes
+	Size  int64  `json:"size,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// DefaultStream is the default "singleton" Stream instance.
+var DefaultStream = NewStream(nil)
+
+// Stream writes events as JSON Lines
+type Stream struct {
+	mu sync.Mutex
+	w  io.Writer // protected by mu
+}
+
+// NewStream returns a Stream writing to w, events are dropped if w is nil
+func NewStream(w io.Writer) *Stream {
+	return &Stream{w: w}
+}
+
+// Emit writes e to the stream, its Time is set to now unless it is set already
+func (s *Stream) Emit(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = currentTimeFunc()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		logrus.Warnf("Unable to marshal event %s: %s", e.Type, err)
+		return
+	}
+	if _, err := s.w.Write(append(b, '\n')); err != nil {
+		logrus.Warnf("Unable to write event %s: %s", e.Type, err)
+	}
+}
+
+// Enabled returns true if the stream has an output, events which are costly to
+// build are only worth building then
+func (s *Stream) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w != nil
+}
+
+// SetOutput directs the events of the stream to w
+func (s *Stream) SetOutput(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = w
+}
+
+// Close closes the output of the stream if it is closable, later events are dropped
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.w.(io.Closer)
+	s.w = nil
+	if !ok {
+		return nil
+	}
+	return c.Close()
+}
+
+// Forward emits the events read as JSON Lines from r, those of another executor, to s
+func (s *Stream) Forward(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			logrus.Warnf("Unable to parse forwarded event: %s", err)
+			continue
+		}
+		s.Emit(e)
+	}
+	return scanner.Err()
+}
+
+// Open directs the events of the DefaultStream to the file at path, which is
+// created or truncated. Paths like /dev/fd/3 write to an inherited file descriptor.
+func Open(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	DefaultStream.SetOutput(f)
+	return nil
+}
+
+// Close closes the output of the DefaultStream, the file opened by Open
+func Close() error {
+	return DefaultStream.Close()
+}
+
+// Enabled returns true if the DefaultStream has an output
+func Enabled() bool {
+	return DefaultStream.Enabled()
+}
+
+// Emit writes e to the DefaultStream
+func Emit(e Event) {
+	DefaultStream.Emit(e)
+}
+
+// Since returns the milliseconds which passed since start, for Event.DurationMs
+func Since(start time.Time) int64 {
+	return currentTimeFunc().Sub(start).Milliseconds()
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func TestStream_Emit(t *testing.T) {
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	currentTimeFunc = func() time.Time { return now }
+	defer func() { currentTimeFunc = time.Now }()
+
+	b := bytes.Buffer{}
+	s := NewStream(&b)
+	stage := 0
+	s.Emit(Event{Type: StageStarted, Stage: &stage, StageName: "builder"})
+	s.Emit(Event{Type: CacheMiss, Command: "RUN foo", CacheKey: "abc"})
+
+	expected := `{"type":"stage-started","time":"2021-01-02T03:04:05Z","stage":0,"stageName":"builder"}
+{"type":"cache-miss","time":"2021-01-02T03:04:05Z","command":"RUN foo","cacheKey":"abc"}
+`
+	testutil.CheckDeepEqual(t, expected, b.String())
+}
+
+func TestStream_EmitWithoutOutput(t *testing.T) {
+	// Must not panic
+	NewStream(nil).Emit(Event{Type: ImagePushed})
+}
+
+func TestStream_Enabled(t *testing.T) {
+	s := NewStream(nil)
+	testutil.CheckDeepEqual(t, false, s.Enabled())
+	s.SetOutput(&bytes.Buffer{})
+	testutil.CheckDeepEqual(t, true, s.Enabled())
+	testutil.CheckNoError(t, s.Close())
+	testutil.CheckDeepEqual(t, false, s.Enabled())
+}
+
+func TestStream_Forward(t *testing.T) {
+	in := `{"type":"stage-started","time":"2021-01-02T03:04:05Z","stage":1,"stageName":"builder"}
+not an event
+{"type":"stage-finished","time":"2021-01-02T03:04:06Z","stage":1,"durationMs":1000}
+`
+	b := bytes.Buffer{}
+	testutil.CheckNoError(t, NewStream(&b).Forward(strings.NewReader(in)))
+
+	expected := `{"type":"stage-started","time":"2021-01-02T03:04:05Z","stage":1,"stageName":"builder"}
+{"type":"stage-finished","time":"2021-01-02T03:04:06Z","stage":1,"durationMs":1000}
+`
+	testutil.CheckDeepEqual(t, expected, b.String())
+}
+
+func TestStream_Close(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	testutil.CheckNoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	f, err := os.Create(path)
+	testutil.CheckNoError(t, err)
+
+	s := NewStream(f)
+	s.Emit(Event{Type: ImagePushed, Digest: "sha256:abc"})
+	testutil.CheckNoError(t, s.Close())
+	// Dropped, the file is closed
+	s.Emit(Event{Type: ImagePushed})
+
+	b, err := ioutil.ReadFile(path)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 1, strings.Count(string(b), "\n"))
+	testutil.CheckNoError(t, NewStream(&bytes.Buffer{}).Close())
+}
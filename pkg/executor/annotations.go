@@ -0,0 +1,196 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+)
+
+// The annotations --oci-metadata fills in
+const (
+	AnnotationSource     = "org.opencontainers.image.source"
+	AnnotationRevision   = "org.opencontainers.image.revision"
+	AnnotationCreated    = "org.opencontainers.image.created"
+	AnnotationBaseName   = "org.opencontainers.image.base.name"
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// baseImage is the image a stage was built on, other stages aside
+type baseImage struct {
+	name   string
+	digest string
+}
+
+// AddSourceAnnotations adds the repository and the commit of a git build context
+// to the annotations of opts if --oci-metadata is set, unless they were set already
+func AddSourceAnnotations(opts *config.KanikoOptions, sourceURI string, revision string) {
+	if !opts.OCIMetadata {
+		return
+	}
+	if opts.Annotations == nil {
+		opts.Annotations = map[string]string{}
+	}
+	setAnnotation(opts.Annotations, AnnotationSource, gitSourceURL(sourceURI))
+	setAnnotation(opts.Annotations, AnnotationRevision, revision)
+}
+
+// gitSourceURL returns the URL of the repository of a git build context,
+// e.g. https://github.com/org/repo.git for git://github.com/org/repo.git#refs/heads/main
+func gitSourceURL(uri string) string {
+	uri = strings.SplitN(uri, "#", 2)[0]
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	if u.Scheme == "git" {
+		u.Scheme = "https"
+	}
+	u.User = nil
+	return u.String()
+}
+
+// setAnnotation sets key to value in annotations unless it is set already or value is empty
+func setAnnotation(annotations map[string]string, key string, value string) {
+	if _, ok := annotations[key]; ok || value == "" {
+		return
+	}
+	annotations[key] = value
+}
+
+// imageAnnotations returns the annotations of the manifest of an image created at created
+// on base, those of --annotation and, if --oci-metadata is set, the standard ones
+func imageAnnotations(opts *config.KanikoOptions, created time.Time, base baseImage) map[string]string {
+	annotations := map[string]string{}
+	for k, v := range opts.Annotations {
+		annotations[k] = v
+	}
+	if !opts.OCIMetadata {
+		return annotations
+	}
+	setAnnotation(annotations, AnnotationCreated, created.UTC().Format(time.RFC3339))
+	if base.name != "" {
+		baseName := base.name
+		if ref, err := name.ParseReference(base.name, name.WeakValidation); err == nil {
+			baseName = ref.Name()
+		}
+		setAnnotation(annotations, AnnotationBaseName, baseName)
+		setAnnotation(annotations, AnnotationBaseDigest, base.digest)
+	}
+	return annotations
+}
+
+// annotatedImage is an image whose manifest has additional annotations
+type annotatedImage struct {
+	v1.Image
+	annotations map[string]string
+}
+
+// annotateImage returns image with annotations added to its manifest
+func annotateImage(image v1.Image, annotations map[string]string) v1.Image {
+	if len(annotations) == 0 {
+		return image
+	}
+	return &annotatedImage{Image: image, annotations: annotations}
+}
+
+func (a *annotatedImage) Manifest() (*v1.Manifest, error) {
+	m, err := a.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	m = m.DeepCopy()
+	if m.Annotations == nil {
+		m.Annotations = map[string]string{}
+	}
+	for k, v := range a.annotations {
+		m.Annotations[k] = v
+	}
+	return m, nil
+}
+
+func (a *annotatedImage) RawManifest() ([]byte, error) {
+	m, err := a.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func (a *annotatedImage) Digest() (v1.Hash, error) {
+	return partial.Digest(a)
+}
+
+func (a *annotatedImage) Size() (int64, error) {
+	return partial.Size(a)
+}
+
+// imageIndex names the index embedded in annotatedIndex, whose ImageIndex method it keeps
+type imageIndex = v1.ImageIndex
+
+// annotatedIndex is an image index whose manifest has additional annotations
+type annotatedIndex struct {
+	imageIndex
+	annotations map[string]string
+}
+
+// annotateIndex returns index with annotations added to its manifest
+func annotateIndex(index v1.ImageIndex, annotations map[string]string) v1.ImageIndex {
+	if len(annotations) == 0 {
+		return index
+	}
+	return &annotatedIndex{imageIndex: index, annotations: annotations}
+}
+
+func (a *annotatedIndex) IndexManifest() (*v1.IndexManifest, error) {
+	m, err := a.imageIndex.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	m = m.DeepCopy()
+	if m.Annotations == nil {
+		m.Annotations = map[string]string{}
+	}
+	for k, v := range a.annotations {
+		m.Annotations[k] = v
+	}
+	return m, nil
+}
+
+func (a *annotatedIndex) RawManifest() ([]byte, error) {
+	m, err := a.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func (a *annotatedIndex) Digest() (v1.Hash, error) {
+	return partial.Digest(a)
+}
+
+func (a *annotatedIndex) Size() (int64, error) {
+	return partial.Size(a)
+}
@@ -17,12 +17,18 @@ limitations under the License.
 package executor
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
@@ -30,6 +36,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -40,8 +47,11 @@ import (
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
 	image_util "github.com/GoogleContainerTools/kaniko/pkg/image"
 	"github.com/GoogleContainerTools/kaniko/pkg/image/remote"
+	"github.com/GoogleContainerTools/kaniko/pkg/policy"
+	"github.com/GoogleContainerTools/kaniko/pkg/provenance"
 	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
@@ -56,16 +66,18 @@ var (
 	initializeConfig = initConfig
 )
 
-type cachePusher func(*config.KanikoOptions, string, string, string) error
+type cachePusher func(context.Context, *config.KanikoOptions, string, string, string) error
 type snapShotter interface {
 	Init() error
 	TakeSnapshotFS() (string, error)
 	TakeSnapshot([]string, bool) (string, error)
+	Changes() []snapshot.Change
 }
 
 // stageBuilder contains all fields necessary to build one stage of a Dockerfile
 type stageBuilder struct {
 	stage            config.KanikoStage
+	baseImage        v1.Image
 	image            v1.Image
 	cf               *v1.ConfigFile
 	baseImageDigest  string
@@ -78,23 +90,30 @@ type stageBuilder struct {
 	digestToCacheKey map[string]string
 	stageIdxToDigest map[string]string
 	snapshotter      snapShotter
+	snapshots        []string
 	layerCache       cache.LayerCache
 	pushLayerToCache cachePusher
+	plan             *StagePlan
+	layerReports     []LayerReport
 }
 
 // newStageBuilder returns a new type stageBuilder which contains all the information required to build the stage
-func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, crossStageDeps map[int][]string, dcm map[string]string, sid map[string]string, stageNameToIdx map[string]string, fileContext util.FileContext) (*stageBuilder, error) {
-	sourceImage, err := image_util.RetrieveSourceImage(stage, opts)
+func newStageBuilder(ctx context.Context, opts *config.KanikoOptions, stage config.KanikoStage, crossStageDeps map[int][]string, dcm map[string]string, sid map[string]string, stageNameToIdx map[string]string, fileContext util.FileContext) (*stageBuilder, error) {
+	sourceImage, err := image_util.RetrieveSourceImage(ctx, stage, opts)
 	if err != nil {
 		return nil, err
 	}
+	return newStageBuilderFromImage(sourceImage, opts, stage, crossStageDeps, dcm, sid, stageNameToIdx, fileContext)
+}
 
+// newStageBuilderFromImage returns a new stageBuilder for a stage with the given source image
+func newStageBuilderFromImage(sourceImage v1.Image, opts *config.KanikoOptions, stage config.KanikoStage, crossStageDeps map[int][]string, dcm map[string]string, sid map[string]string, stageNameToIdx map[string]string, fileContext util.FileContext) (*stageBuilder, error) {
 	imageConfig, err := initializeConfig(sourceImage, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := resolveOnBuild(&stage, &imageConfig.Config, stageNameToIdx); err != nil {
+	if err := resolveOnBuild(&stage, &imageConfig.Config, opts, stageNameToIdx); err != nil {
 		return nil, err
 	}
 
@@ -109,13 +128,29 @@ func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, cross
 	}
 	l := snapshot.NewLayeredMap(hasher, util.CacheHasher())
 	snapshotter := snapshot.NewSnapshotter(l, config.RootDir)
+	snapshotter.SetSourceDateEpoch(opts.SourceDateEpoch)
 
 	digest, err := sourceImage.Digest()
 	if err != nil {
 		return nil, err
 	}
+	e := stageEvent(events.BaseImageResolved, stage)
+	e.Image = stage.BaseName
+	e.Digest = digest.String()
+	events.Emit(e)
+	if !stage.BaseImageStoredLocally && stage.BaseName != constants.NoBaseImage {
+		provenance.AddMaterial(stage.BaseName, digest.String())
+	}
+
+	// The digest of the base image is the one it was retrieved by
+	sourceImage, err = compressionImage(sourceImage, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &stageBuilder{
 		stage:            stage,
+		baseImage:        sourceImage,
 		image:            sourceImage,
 		cf:               imageConfig,
 		snapshotter:      snapshotter,
@@ -132,7 +167,12 @@ func newStageBuilder(opts *config.KanikoOptions, stage config.KanikoStage, cross
 	}
 
 	for _, cmd := range s.stage.Commands {
-		command, err := commands.GetCommand(cmd, fileContext, opts.RunV2, opts.CacheCopyLayers)
+		command, err := commands.GetCommand(cmd, fileContext, opts.RunV2, opts.CacheCopyLayers, commands.RunOptions{
+			CacheMountDir:  opts.CacheMountDir,
+			Secrets:        opts.Secrets,
+			StageNameToIdx: stageNameToIdx,
+			Network:        opts.RunNetwork,
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -181,7 +221,7 @@ func initConfig(img partial.WithConfigFile, opts *config.KanikoOptions) (*v1.Con
 func (s *stageBuilder) populateCompositeKey(command fmt.Stringer, files []string, compositeKey CompositeCache, args *dockerfile.BuildArgs, env []string) (CompositeCache, error) {
 	// First replace all the environment variables or args in the command
 	replacementEnvs := args.ReplacementEnvs(env)
-	resolvedCmd, err := util.ResolveEnvironmentReplacement(command.String(), replacementEnvs, false)
+	resolvedCmd, err := util.ResolveEnvironmentReplacement(dockerfile.WithoutCacheMounts(command.String()), replacementEnvs, false)
 	if err != nil {
 		return compositeKey, err
 	}
@@ -191,6 +231,15 @@ func (s *stageBuilder) populateCompositeKey(command fmt.Stringer, files []string
 	case *commands.CopyCommand:
 	case *commands.CachingCopyCommand:
 		compositeKey = s.populateCopyCmdCompositeKey(command, v.From(), compositeKey)
+	case *commands.RunCommand:
+		compositeKey, err = s.populateRunCmdCompositeKey(command, v.BindMountsFrom, compositeKey)
+	case *commands.RunMarkerCommand:
+		compositeKey, err = s.populateRunCmdCompositeKey(command, v.BindMountsFrom, compositeKey)
+	case *commands.CachingRunCommand:
+		compositeKey, err = s.populateRunCmdCompositeKey(command, v.BindMountsFrom, compositeKey)
+	}
+	if err != nil {
+		return compositeKey, err
 	}
 
 	for _, f := range files {
@@ -217,10 +266,28 @@ func (s *stageBuilder) populateCopyCmdCompositeKey(command fmt.Stringer, from st
 	return compositeKey
 }
 
-func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) error {
-	if !s.opts.Cache {
+// populateRunCmdCompositeKey adds the cache keys of the previous stages and the digests of
+// the images a RUN command mounts files from. The files it mounts from the context are added
+// with the others it uses.
+func (s *stageBuilder) populateRunCmdCompositeKey(command fmt.Stringer, bindMountsFrom func() ([]string, error), compositeKey CompositeCache) (CompositeCache, error) {
+	froms, err := bindMountsFrom()
+	if err != nil {
+		return compositeKey, err
+	}
+	for _, from := range froms {
+		compositeKey = s.populateCopyCmdCompositeKey(command, from, compositeKey)
+	}
+	return compositeKey, nil
+}
+
+func (s *stageBuilder) optimize(ctx context.Context, compositeKey CompositeCache, cfg v1.Config) error {
+	if !s.opts.Cache && s.plan == nil {
 		return nil
 	}
+	if s.plan != nil {
+		// Stages built on top of this one start from the config the metadata commands produced
+		defer func() { s.cf.Config = cfg }()
+	}
 
 	stopCache := false
 	// Possibly replace commands with their cached implementations.
@@ -250,26 +317,36 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 		logrus.Debugf("optimize: cache key for command %v %v", command.String(), ck)
 		s.finalCacheKey = ck
 
-		if command.ShouldCacheOutput() && !stopCache {
+		action := PlanActionExecute
+		if s.opts.Cache && command.ShouldCacheOutput() && !stopCache {
 			img, err := s.layerCache.RetrieveLayer(ck)
 
+			e := s.event(events.CacheHit)
+			e.Command = command.String()
+			e.CacheKey = ck
 			if err != nil {
 				logrus.Debugf("Failed to retrieve layer: %s", err)
 				logrus.Infof("No cached layer found for cmd %s", command.String())
 				logrus.Debugf("Key missing was: %s", compositeKey.Key())
+				e.Type = events.CacheMiss
+				events.Emit(e)
 				stopCache = true
+				s.planCommand(command, ck, action)
 				continue
 			}
+			events.Emit(e)
 
 			if cacheCmd := command.CacheCommand(img); cacheCmd != nil {
 				logrus.Infof("Using caching version of cmd: %s", command.String())
 				s.cmds[i] = cacheCmd
+				action = PlanActionCacheHit
 			}
 		}
+		s.planCommand(command, ck, action)
 
 		// Mutate the config for any commands that require it.
 		if command.MetadataOnly() {
-			if err := command.ExecuteCommand(&cfg, s.args); err != nil {
+			if err := command.ExecuteCommand(ctx, &cfg, s.args); err != nil {
 				return err
 			}
 		}
@@ -277,17 +354,34 @@ func (s *stageBuilder) optimize(compositeKey CompositeCache, cfg v1.Config) erro
 	return nil
 }
 
-func (s *stageBuilder) build() error {
-	// Set the initial cache key to be the base image digest, the build args and the SrcContext.
-	var compositeKey *CompositeCache
+// initialCompositeKey returns the cache key of the stage's base image
+func (s *stageBuilder) initialCompositeKey() *CompositeCache {
+	base := s.baseImageDigest
 	if cacheKey, ok := s.digestToCacheKey[s.baseImageDigest]; ok {
-		compositeKey = NewCompositeCache(cacheKey)
-	} else {
-		compositeKey = NewCompositeCache(s.baseImageDigest)
+		base = cacheKey
+	}
+	compositeKey := NewCompositeCache(base)
+	if s.opts.Compression == constants.CompressionEstargz || s.opts.Compression == constants.CompressionZstd {
+		// Cached layers are used as they are, keep them apart from gzip layers
+		compositeKey.AddKey("compression=" + s.opts.Compression)
+	}
+	if !s.opts.SourceDateEpoch.IsZero() {
+		// Cached layers carry the timestamps they were clamped to
+		compositeKey.AddKey(fmt.Sprintf("source-date-epoch=%d", s.opts.SourceDateEpoch.Unix()))
+	}
+	if s.opts.RunNetwork == dockerfile.NetworkNone {
+		// Layers cached by commands which had network access mustn't end up in hermetic builds
+		compositeKey.AddKey("run-network=" + s.opts.RunNetwork)
 	}
+	return compositeKey
+}
+
+func (s *stageBuilder) build(ctx context.Context) error {
+	// Set the initial cache key to be the base image digest, the build args and the SrcContext.
+	compositeKey := s.initialCompositeKey()
 
 	// Apply optimizations to the instructions.
-	if err := s.optimize(*compositeKey, s.cf.Config); err != nil {
+	if err := s.optimize(ctx, *compositeKey, s.cf.Config); err != nil {
 		return errors.Wrap(err, "failed to optimize instructions")
 	}
 
@@ -303,12 +397,16 @@ func (s *stageBuilder) build() error {
 	if len(s.crossStageDeps[s.stage.Index]) > 0 {
 		shouldUnpack = true
 	}
+	if s.stage.Final && s.opts.SBOMFormat != "" {
+		// the SBOM is inventoried from the filesystem of the final stage
+		shouldUnpack = true
+	}
 
 	if shouldUnpack {
 		t := timing.Start("FS Unpacking")
 
 		retryFunc := func() error {
-			_, err := util.GetFSFromImage(config.RootDir, s.image, util.ExtractFile)
+			_, err := util.GetFSFromImage(config.RootDir, s.image, util.ExtractFile, util.WithContext(ctx))
 			return err
 		}
 
@@ -330,6 +428,12 @@ func (s *stageBuilder) build() error {
 	}
 
 	cacheGroup := errgroup.Group{}
+	defer func() {
+		// Don't leave cache pushes behind that still read the snapshots
+		if ctx.Err() != nil {
+			waitForCachePushes(&cacheGroup)
+		}
+	}()
 	for index, command := range s.cmds {
 		if command == nil {
 			continue
@@ -369,7 +473,25 @@ func (s *stageBuilder) build() error {
 			initSnapshotTaken = true
 		}
 
-		if err := command.ExecuteCommand(&s.cf.Config, s.args); err != nil {
+		e := s.event(events.CommandStarted)
+		e.Command = command.String()
+		events.Emit(e)
+		start := time.Now()
+		cmdCtx, cancel := s.commandContext(ctx)
+		err = command.ExecuteCommand(cmdCtx, &s.cf.Config, s.args)
+		cancel()
+		e.Type = events.CommandFinished
+		e.DurationMs = events.Since(start)
+		if err != nil {
+			e.Error = err.Error()
+		}
+		events.Emit(e)
+		if err != nil {
+			if s.opts.OnFailureSnapshot != "" && ctx.Err() == nil {
+				if err := s.saveFailureSnapshot(ctx, command, err); err != nil {
+					logrus.Errorf("Failed to save the filesystem of the failed command: %s", err)
+				}
+			}
 			return errors.Wrap(err, "failed to execute command")
 		}
 		files = command.FilesToSnapshot()
@@ -384,6 +506,9 @@ func (s *stageBuilder) build() error {
 			if err := s.saveLayerToImage(layer, command.String()); err != nil {
 				return errors.Wrap(err, "failed to save layer")
 			}
+			if err := s.recordLayer(layer, command.String(), nil, true); err != nil {
+				return err
+			}
 		} else {
 			tarPath, err := s.takeSnapshot(files, command.ShouldDetectDeletedFiles())
 			if err != nil {
@@ -402,7 +527,7 @@ func (s *stageBuilder) build() error {
 				// Push layer to cache (in parallel) now along with new config file
 				if command.ShouldCacheOutput() {
 					cacheGroup.Go(func() error {
-						return s.pushLayerToCache(s.opts, ck, tarPath, command.String())
+						return s.pushLayerToCache(ctx, s.opts, ck, tarPath, command.String())
 					})
 				}
 			}
@@ -416,9 +541,101 @@ func (s *stageBuilder) build() error {
 		logrus.Warnf("error uploading layer to cache: %s", err)
 	}
 
+	if s.shouldSquash() {
+		if err := s.squash(); err != nil {
+			return errors.Wrap(err, "failed to squash layers")
+		}
+	}
 	return nil
 }
 
+// shouldSquash returns true if the layers of the stage should be squashed into one
+func (s *stageBuilder) shouldSquash() bool {
+	if s.opts.Squash {
+		return true
+	}
+	for _, name := range s.opts.SquashStages {
+		if s.stage.Name == strings.ToLower(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// squash replaces the layers added on top of the base image by a single layer
+func (s *stageBuilder) squash() error {
+	baseLayers, err := s.baseImage.Layers()
+	if err != nil {
+		return err
+	}
+	layers, err := s.image.Layers()
+	if err != nil {
+		return err
+	}
+	added := layers[len(baseLayers):]
+	if len(added) < 2 {
+		logrus.Debugf("Not squashing %d layers", len(added))
+		return nil
+	}
+
+	t := timing.Start("Squashing Layers")
+	defer timing.DefaultRun.Stop(t)
+	f, err := ioutil.TempFile(config.KanikoDir, "squash")
+	if err != nil {
+		return err
+	}
+	s.snapshots = append(s.snapshots, f.Name())
+	if err := util.SquashLayers(added, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	layer, err := newLayer(f.Name(), s.opts)
+	if err != nil {
+		return err
+	}
+	mediaType, err := layerMediaType(s.baseImage, s.opts)
+	if err != nil {
+		return err
+	}
+	createdBy := fmt.Sprintf("squashed %d layers", len(added))
+	s.image, err = mutate.Append(s.baseImage,
+		mutate.Addendum{
+			Layer:     layer,
+			MediaType: mediaType,
+			History: v1.History{
+				Author:    constants.Author,
+				Created:   v1.Time{Time: s.opts.SourceDateEpoch},
+				CreatedBy: createdBy,
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	r, err := squashLayerReports(layer, createdBy, s.layerReports)
+	if err != nil {
+		return err
+	}
+	s.layerReports = nil
+	if err := s.addLayerReport(r); err != nil {
+		return err
+	}
+	logrus.Infof("Squashed %d layers into one", len(added))
+	return nil
+}
+
+// commandContext returns the context a single command is executed with,
+// which expires after opts.RunTimeout if it is set
+func (s *stageBuilder) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.opts.RunTimeout > 0 {
+		return context.WithTimeout(ctx, s.opts.RunTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
 func (s *stageBuilder) takeSnapshot(files []string, shdDelete bool) (string, error) {
 	var snapshot string
 	var err error
@@ -432,9 +649,79 @@ func (s *stageBuilder) takeSnapshot(files []string, shdDelete bool) (string, err
 		snapshot, err = s.snapshotter.TakeSnapshot(files, shdDelete)
 	}
 	timing.DefaultRun.Stop(t)
+	if snapshot != "" {
+		s.snapshots = append(s.snapshots, snapshot)
+		if err == nil {
+			s.emitSnapshotTaken(snapshot)
+		}
+	}
 	return snapshot, err
 }
 
+// emitSnapshotTaken reports the number of entries and the size of a snapshot tarball
+func (s *stageBuilder) emitSnapshotTaken(snapshot string) {
+	// Counting the files reads the whole snapshot, which is only worth it for an events file
+	if !events.Enabled() {
+		return
+	}
+	e := s.event(events.SnapshotTaken)
+	if fi, err := os.Stat(snapshot); err == nil {
+		e.Size = fi.Size()
+	}
+	f, err := os.Open(snapshot)
+	if err != nil {
+		logrus.Debugf("Unable to open snapshot %s: %s", snapshot, err)
+		return
+	}
+	defer f.Close()
+	// tar.Reader seeks over the file contents, only the headers are read
+	tr := tar.NewReader(f)
+	for {
+		if _, err := tr.Next(); err != nil {
+			break
+		}
+		e.Files++
+	}
+	events.Emit(e)
+}
+
+// event returns an event of type t for the stage being built
+func (s *stageBuilder) event(t events.Type) events.Event {
+	return stageEvent(t, s.stage)
+}
+
+func stageEvent(t events.Type, stage config.KanikoStage) events.Event {
+	index := stage.Index
+	return events.Event{Type: t, Stage: &index, StageName: stage.Name}
+}
+
+// waitForCachePushes waits for the layers being pushed to the cache,
+// for at most constants.TerminationGracePeriod
+func waitForCachePushes(cacheGroup *errgroup.Group) {
+	done := make(chan error, 1)
+	go func() {
+		done <- cacheGroup.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			logrus.Warnf("error uploading layer to cache: %s", err)
+		}
+	case <-time.After(constants.TerminationGracePeriod):
+		logrus.Warnf("Layers are still being pushed to the cache after %s, abandoning them", constants.TerminationGracePeriod)
+	}
+}
+
+// removeSnapshots deletes the snapshot tarballs of a cancelled build
+func removeSnapshots(snapshots []string) {
+	for _, s := range snapshots {
+		if err := os.Remove(s); err != nil && !os.IsNotExist(err) {
+			logrus.Warnf("Unable to remove snapshot %s: %s", s, err)
+		}
+	}
+	logrus.Infof("Removed %d snapshots of the cancelled build", len(snapshots))
+}
+
 func (s *stageBuilder) shouldTakeSnapshot(index int, isMetadatCmd bool) bool {
 	isLastCommand := index == len(s.cmds)-1
 
@@ -462,7 +749,10 @@ func (s *stageBuilder) saveSnapshotToImage(createdBy string, tarPath string) err
 		return nil
 	}
 
-	return s.saveLayerToImage(layer, createdBy)
+	if err := s.saveLayerToImage(layer, createdBy); err != nil {
+		return err
+	}
+	return s.recordLayer(layer, createdBy, s.snapshotter.Changes(), false)
 }
 
 func (s *stageBuilder) saveSnapshotToLayer(tarPath string) (v1.Layer, error) {
@@ -478,20 +768,140 @@ func (s *stageBuilder) saveSnapshotToLayer(tarPath string) (v1.Layer, error) {
 		return nil, nil
 	}
 
-	layer, err := tarball.LayerFromFile(tarPath, tarball.WithCompressedCaching)
+	layer, err := newLayer(tarPath, s.opts)
 	if err != nil {
 		return nil, err
 	}
 
 	return layer, nil
 }
+
+// newLayer returns the layer of the snapshot at tarPath, compressed as set in opts
+func newLayer(tarPath string, opts *config.KanikoOptions) (v1.Layer, error) {
+	if err := CheckCompression(opts); err != nil {
+		return nil, err
+	}
+	if opts.Compression == constants.CompressionZstd {
+		return util.ZstdLayerFromFile(tarPath, opts.CompressionLevel)
+	}
+	var layerOpts []tarball.LayerOption
+	if opts.CompressionLevel != 0 {
+		layerOpts = append(layerOpts, tarball.WithCompressionLevel(opts.CompressionLevel))
+	}
+	if opts.Compression == constants.CompressionEstargz {
+		layerOpts = append(layerOpts, tarball.WithEstargz)
+	}
+	// the compressed layer is cached last, once its compression is set up
+	layerOpts = append(layerOpts, tarball.WithCompressedCaching)
+	return tarball.LayerFromFile(tarPath, layerOpts...)
+}
+
+// CheckCompression returns an error if the compression set in opts isn't supported
+func CheckCompression(opts *config.KanikoOptions) error {
+	switch opts.Compression {
+	case "", constants.CompressionGzip:
+	case constants.CompressionEstargz:
+		if err := checkEstargz(); err != nil {
+			return err
+		}
+	case constants.CompressionZstd:
+	default:
+		return fmt.Errorf("%s is not a valid compression", opts.Compression)
+	}
+	if opts.CompressionLevel < 0 || opts.CompressionLevel > 9 {
+		return fmt.Errorf("compression level must be between 1 and 9, got %d", opts.CompressionLevel)
+	}
+	return nil
+}
+
+var (
+	estargzOnce sync.Once
+	estargzErr  error
+)
+
+// checkEstargz returns an error if estargz layers can't be created. The footer of
+// estargz layers relies on the output of compress/gzip, which changed in later Go
+// versions and makes the estargz library panic.
+func checkEstargz() error {
+	estargzOnce.Do(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				estargzErr = fmt.Errorf("estargz compression is not supported by the Go version kaniko was built with: %v", r)
+			}
+		}()
+		var buf bytes.Buffer
+		if err := tar.NewWriter(&buf).Close(); err != nil {
+			estargzErr = err
+			return
+		}
+		_, estargzErr = tarball.LayerFromReader(&buf, tarball.WithEstargz)
+	})
+	return estargzErr
+}
+
+// layerMediaType returns the media type of the layers appended to image,
+// matching the format of its manifest and the compression set in opts
+func layerMediaType(image v1.Image, opts *config.KanikoOptions) (types.MediaType, error) {
+	if opts.Compression == constants.CompressionZstd {
+		return util.ZstdLayerMediaType, nil
+	}
+	mt, err := image.MediaType()
+	if err != nil {
+		return "", err
+	}
+	if mt == types.OCIManifestSchema1 {
+		return types.OCILayer, nil
+	}
+	return types.DockerLayer, nil
+}
+
+// reproducibleImage strips the host-dependent bits out of image. Without a
+// SOURCE_DATE_EPOCH every timestamp is zeroed, which rewrites all layers, the
+// base image ones included. With one, the layers kaniko created already carry
+// clamped timestamps and the base image layers are kept as they are.
+func reproducibleImage(image v1.Image, opts *config.KanikoOptions) (v1.Image, error) {
+	if opts.SourceDateEpoch.IsZero() {
+		return mutate.Canonical(image)
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := cf.DeepCopy()
+	cfg.Container = ""
+	cfg.Config.Hostname = ""
+	cfg.DockerVersion = ""
+	return mutate.ConfigFile(image, cfg)
+}
+
+// compressionImage returns image in a manifest format which can reference the layers
+// compressed as set in opts: zstd layers can only be referenced by OCI manifests
+func compressionImage(image v1.Image, opts *config.KanikoOptions) (v1.Image, error) {
+	if opts.Compression != constants.CompressionZstd {
+		return image, nil
+	}
+	mt, err := image.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	if mt == types.OCIManifestSchema1 {
+		return image, nil
+	}
+	return mutate.MediaType(image, types.OCIManifestSchema1), nil
+}
+
 func (s *stageBuilder) saveLayerToImage(layer v1.Layer, createdBy string) error {
-	var err error
+	mediaType, err := layerMediaType(s.image, s.opts)
+	if err != nil {
+		return err
+	}
 	s.image, err = mutate.Append(s.image,
 		mutate.Addendum{
-			Layer: layer,
+			Layer:     layer,
+			MediaType: mediaType,
 			History: v1.History{
 				Author:    constants.Author,
+				Created:   v1.Time{Time: s.opts.SourceDateEpoch},
 				CreatedBy: createdBy,
 			},
 		},
@@ -499,27 +909,44 @@ func (s *stageBuilder) saveLayerToImage(layer v1.Layer, createdBy string) error
 	return err
 }
 
-func CalculateDependencies(stages []config.KanikoStage, opts *config.KanikoOptions, stageNameToIdx map[string]string) (map[int][]string, error) {
+func CalculateDependencies(ctx context.Context, stages []config.KanikoStage, opts *config.KanikoOptions, stageNameToIdx map[string]string) (map[int][]string, error) {
+	depGraph, _, err := calculateDependencies(ctx, stages, opts, stageNameToIdx)
+	return depGraph, err
+}
+
+// calculateDependencies returns the files every stage needs from the stages before it,
+// like CalculateDependencies, and the stages every stage depends on
+func calculateDependencies(ctx context.Context, stages []config.KanikoStage, opts *config.KanikoOptions, stageNameToIdx map[string]string) (map[int][]string, map[int][]int, error) {
 	images := []v1.Image{}
 	depGraph := map[int][]string{}
-	for _, s := range stages {
+	stageDeps := map[int][]int{}
+	addStageDep := func(stage int, dep int) {
+		for _, d := range stageDeps[stage] {
+			if d == dep {
+				return
+			}
+		}
+		stageDeps[stage] = append(stageDeps[stage], dep)
+	}
+	for stageIndex, s := range stages {
 		ba := dockerfile.NewBuildArgs(opts.BuildArgs)
 		ba.AddMetaArgs(s.MetaArgs)
 		var image v1.Image
 		var err error
 		if s.BaseImageStoredLocally {
 			image = images[s.BaseImageIndex]
+			addStageDep(stageIndex, s.BaseImageIndex)
 		} else if s.Name == constants.NoBaseImage {
 			image = empty.Image
 		} else {
-			image, err = image_util.RetrieveSourceImage(s, opts)
+			image, err = image_util.RetrieveSourceImage(ctx, s, opts)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 		}
 		cfg, err := initializeConfig(image, opts)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		cmds, err := dockerfile.GetOnBuildInstructions(&cfg.Config, stageNameToIdx)
@@ -535,41 +962,221 @@ func CalculateDependencies(stages []config.KanikoStage, opts *config.KanikoOptio
 					}
 					resolved, err := util.ResolveEnvironmentReplacementList(cmd.SourcesAndDest, ba.ReplacementEnvs(cfg.Config.Env), true)
 					if err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 					depGraph[i] = append(depGraph[i], resolved[0:len(resolved)-1]...)
+					addStageDep(stageIndex, i)
+				}
+			case *instructions.RunCommand:
+				mounts, err := dockerfile.RunMounts(cmd)
+				if err != nil {
+					return nil, nil, err
+				}
+				for _, m := range mounts {
+					if m.Type != dockerfile.MountTypeBind || m.From == "" {
+						continue
+					}
+					i, err := strconv.Atoi(m.ResolveFrom(stageNameToIdx))
+					if err != nil {
+						continue
+					}
+					depGraph[i] = append(depGraph[i], filepath.Clean("/"+m.Source))
+					addStageDep(stageIndex, i)
 				}
 			case *instructions.EnvCommand:
 				if err := util.UpdateConfigEnv(cmd.Env, &cfg.Config, ba.ReplacementEnvs(cfg.Config.Env)); err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 				image, err = mutate.Config(image, cfg.Config)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 			case *instructions.ArgCommand:
 				k, v, err := commands.ParseArg(cmd.Key, cmd.Value, cfg.Config.Env, ba)
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 				ba.AddArg(k, v)
 			}
 		}
 		images = append(images, image)
 	}
-	return depGraph, nil
+	return depGraph, stageDeps, nil
+}
+
+// stageSourceImage returns the image of the stage built by sb, for the platform of the build
+func stageSourceImage(sb *stageBuilder, opts *config.KanikoOptions) (v1.Image, error) {
+	sourceImage, err := mutate.Config(sb.image, sb.cf.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	configFile, err := sourceImage.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if opts.CustomPlatform == "" {
+		configFile.OS = runtime.GOOS
+		configFile.Architecture = runtime.GOARCH
+	} else {
+		configFile.OS = strings.Split(opts.CustomPlatform, "/")[0]
+		configFile.Architecture = strings.Split(opts.CustomPlatform, "/")[1]
+	}
+	return mutate.ConfigFile(sourceImage, configFile)
+}
+
+// saveStage saves what later stages use of the stage at index: its image, if a stage is
+// based on it, and the files deps of its filesystem they copy
+func saveStage(index int, stage config.KanikoStage, sourceImage v1.Image, deps []string) error {
+	if stage.SaveStage {
+		if err := saveStageAsTarball(strconv.Itoa(index), sourceImage); err != nil {
+			return err
+		}
+	}
+
+	filesToSave, err := filesToSave(deps)
+	if err != nil {
+		return err
+	}
+	dstDir := filepath.Join(config.KanikoDir, strconv.Itoa(index))
+	// Files saved by a previous build for another platform must not leak into this one
+	if err := os.RemoveAll(dstDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dstDir, 0644); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("to create workspace for stage %d", index))
+	}
+	for _, p := range filesToSave {
+		logrus.Infof("Saving file %s for later use", p)
+		if err := util.CopyFileOrSymlink(p, dstDir, config.RootDir); err != nil {
+			return errors.Wrap(err, "could not save file")
+		}
+	}
+	return nil
 }
 
-// DoBuild executes building the Dockerfile
-func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
+// stageResults are what the stages of a build hand to the stages after them. They are
+// safe for concurrent use, stages may be built concurrently with --max-parallel-stages.
+type stageResults struct {
+	mu               sync.Mutex
+	digestToCacheKey map[string]string     // protected by mu
+	stageIdxToDigest map[string]string     // protected by mu
+	bases            map[int]baseImage     // protected by mu
+	layers           map[int][]LayerReport // protected by mu
+}
+
+func newStageResults() *stageResults {
+	return &stageResults{
+		digestToCacheKey: map[string]string{},
+		stageIdxToDigest: map[string]string{},
+		bases:            map[int]baseImage{},
+		layers:           map[int][]LayerReport{},
+	}
+}
+
+// add records the result of the stage at index: the digest of its image, its cache key,
+// the digest of its base image and the reports of its layers
+func (r *stageResults) add(index int, stage config.KanikoStage, digest string, cacheKey string, baseImageDigest string, layers []LayerReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stageIdxToDigest[strconv.Itoa(stage.Index)] = digest
+	logrus.Debugf("mapping stage idx %v to digest %v", stage.Index, digest)
+
+	r.digestToCacheKey[digest] = cacheKey
+	logrus.Debugf("mapping digest %v to cachekey %v", digest, cacheKey)
+
+	switch {
+	case stage.BaseImageStoredLocally:
+		r.bases[index] = r.bases[stage.BaseImageIndex]
+	case stage.BaseName != constants.NoBaseImage:
+		r.bases[index] = baseImage{name: stage.BaseName, digest: baseImageDigest}
+	}
+	r.layers[index] = layers
+}
+
+// addImage records the digest of the image name, which commands use files from like from a
+// stage. Its digest is its cache key: the commands must miss the cache when it changes.
+func (r *stageResults) addImage(name string, digest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stageIdxToDigest[name] = digest
+	r.digestToCacheKey[digest] = digest
+	logrus.Debugf("mapping image %v to digest %v", name, digest)
+}
+
+// cacheKeys returns copies of the digests of the images of the stages built so far and
+// of their cache keys, for the stage builder of a later stage
+func (r *stageResults) cacheKeys() (map[string]string, map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digestToCacheKey := make(map[string]string, len(r.digestToCacheKey))
+	for k, v := range r.digestToCacheKey {
+		digestToCacheKey[k] = v
+	}
+	stageIdxToDigest := make(map[string]string, len(r.stageIdxToDigest))
+	for k, v := range r.stageIdxToDigest {
+		stageIdxToDigest[k] = v
+	}
+	return digestToCacheKey, stageIdxToDigest
+}
+
+// base returns the base image of the stage at index
+func (r *stageResults) base(index int) baseImage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bases[index]
+}
+
+// baseLayers returns the reports of the layers of the stage stage is based on, if any
+func (r *stageResults) baseLayers(stage config.KanikoStage) []LayerReport {
+	if !stage.BaseImageStoredLocally {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.layers[stage.BaseImageIndex]
+}
+
+// DoBuild executes building the Dockerfile, it stops once ctx is done
+func DoBuild(ctx context.Context, opts *config.KanikoOptions) (v1.Image, error) {
+	images, err := doBuild(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) > 1 {
+		return nil, fmt.Errorf("%d images were built, several targets are built with DoMultiTargetBuild", len(images))
+	}
+	if len(opts.Targets) > 0 {
+		image, ok := images[opts.Targets[0]]
+		if !ok {
+			return nil, fmt.Errorf("no image was built for target %s", opts.Targets[0])
+		}
+		return image, nil
+	}
+	// Without a target, the only image is the one of the last stage
+	for _, image := range images {
+		return image, nil
+	}
+	return nil, errors.New("no image was built")
+}
+
+// doBuild builds the stages the targets of opts need, each of them once, and
+// returns the images of the targets by the name of their stage
+func doBuild(ctx context.Context, opts *config.KanikoOptions) (map[string]v1.Image, error) {
 	t := timing.Start("Total Build Time")
-	digestToCacheKey := make(map[string]string)
-	stageIdxToDigest := make(map[string]string)
+	images := make(map[string]v1.Image)
+	stageImages := make(map[string]v1.Image)
+	results := newStageResults()
 
-	stages, metaArgs, err := dockerfile.ParseStages(opts)
+	d, err := dockerfile.ReadDockerfile(opts.DockerfilePath)
 	if err != nil {
 		return nil, err
 	}
+	stages, metaArgs, err := dockerfile.ParseStagesFrom(d, opts)
+	if err != nil {
+		return nil, err
+	}
+	provenance.AddMaterial(opts.DockerfilePath, fmt.Sprintf("sha256:%x", sha256.Sum256(d)))
 
 	kanikoStages, err := dockerfile.MakeKanikoStages(opts, stages, metaArgs)
 	if err != nil {
@@ -582,109 +1189,214 @@ func DoBuild(opts *config.KanikoOptions) (v1.Image, error) {
 		return nil, err
 	}
 
+	if err := checkBaseImagePolicy(kanikoStages, opts, stageNameToIdx); err != nil {
+		return nil, err
+	}
+
 	// Some stages may refer to other random images, not previous stages
-	if err := fetchExtraStages(kanikoStages, opts); err != nil {
+	if err := fetchExtraStages(ctx, kanikoStages, opts, stageNameToIdx, results); err != nil {
 		return nil, err
 	}
-	crossStageDependencies, err := CalculateDependencies(kanikoStages, opts, stageNameToIdx)
+	crossStageDependencies, stageDependencies, err := calculateDependencies(ctx, kanikoStages, opts, stageNameToIdx)
 	if err != nil {
 		return nil, err
 	}
 	logrus.Infof("Built cross stage deps: %v", crossStageDependencies)
 
+	scheduler := newStageScheduler(kanikoStages, stageDependencies, opts)
+	if scheduler != nil {
+		scheduler.start(ctx, func(ctx context.Context, index int) error {
+			return buildIsolatedStage(ctx, opts, kanikoStages[index], index, crossStageDependencies, results)
+		})
+		defer scheduler.stop()
+	}
+
+	var snapshots []string
+	defer func() {
+		if ctx.Err() != nil {
+			removeSnapshots(snapshots)
+		}
+	}()
+
 	for index, stage := range kanikoStages {
-		sb, err := newStageBuilder(opts, stage, crossStageDependencies, digestToCacheKey, stageIdxToDigest, stageNameToIdx, fileContext)
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("building stage %d", index))
+		}
+		if scheduler.isolated(index) {
+			// It is built in its own root, and has saved what later stages need
+			if err := scheduler.wait(ctx, index); err != nil {
+				return nil, errors.Wrap(err, "error building stage")
+			}
+			continue
+		}
+		if err := scheduler.acquire(ctx); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("building stage %d", index))
+		}
+		e := stageEvent(events.StageStarted, stage)
+		events.Emit(e)
+		start := time.Now()
+		digestToCacheKey, stageIdxToDigest := results.cacheKeys()
+		sb, err := newStageBuilder(ctx, opts, stage, crossStageDependencies, digestToCacheKey, stageIdxToDigest, stageNameToIdx, fileContext)
 		if err != nil {
 			return nil, err
 		}
-		if err := sb.build(); err != nil {
+		err = sb.build(ctx)
+		snapshots = append(snapshots, sb.snapshots...)
+		e.Type = events.StageFinished
+		e.DurationMs = events.Since(start)
+		if err != nil {
+			e.Error = err.Error()
+		}
+		events.Emit(e)
+		if err != nil {
 			return nil, errors.Wrap(err, "error building stage")
 		}
 
 		reviewConfig(stage, &sb.cf.Config)
 
-		sourceImage, err := mutate.Config(sb.image, sb.cf.Config)
-		if err != nil {
-			return nil, err
-		}
-
-		configFile, err := sourceImage.ConfigFile()
+		layers, err := stageLayerReports(sb, results.baseLayers(stage))
 		if err != nil {
 			return nil, err
 		}
-		if opts.CustomPlatform == "" {
-			configFile.OS = runtime.GOOS
-			configFile.Architecture = runtime.GOARCH
-		} else {
-			configFile.OS = strings.Split(opts.CustomPlatform, "/")[0]
-			configFile.Architecture = strings.Split(opts.CustomPlatform, "/")[1]
-		}
-		sourceImage, err = mutate.ConfigFile(sourceImage, configFile)
+		sourceImage, err := stageSourceImage(sb, opts)
 		if err != nil {
 			return nil, err
 		}
-
 		d, err := sourceImage.Digest()
 		if err != nil {
 			return nil, err
 		}
+		results.add(index, stage, d.String(), sb.finalCacheKey, sb.baseImageDigest, layers)
 
-		stageIdxToDigest[fmt.Sprintf("%d", sb.stage.Index)] = d.String()
-		logrus.Debugf("mapping stage idx %v to digest %v", sb.stage.Index, d.String())
-
-		digestToCacheKey[d.String()] = sb.finalCacheKey
-		logrus.Debugf("mapping digest %v to cachekey %v", d.String(), sb.finalCacheKey)
+		if outputStage(stage.Name, opts) {
+			stageImages[stage.Name] = sourceImage
+		}
 
 		if stage.Final {
-			sourceImage, err = mutate.CreatedAt(sourceImage, v1.Time{Time: time.Now()})
+			created := time.Now()
+			if !opts.SourceDateEpoch.IsZero() {
+				created = opts.SourceDateEpoch
+			}
+			finalImage, err := mutate.CreatedAt(sourceImage, v1.Time{Time: created})
 			if err != nil {
 				return nil, err
 			}
 			if opts.Reproducible {
-				sourceImage, err = mutate.Canonical(sourceImage)
+				finalImage, err = reproducibleImage(finalImage, opts)
 				if err != nil {
 					return nil, err
 				}
 			}
-			if opts.Cleanup {
-				if err = util.DeleteFilesystem(); err != nil {
+			cf, err := finalImage.ConfigFile()
+			if err != nil {
+				return nil, err
+			}
+			finalImage = annotateImage(finalImage, imageAnnotations(opts, cf.Created.Time, results.base(index)))
+			if err := checkImagePolicy(finalImage, stage.Name, opts); err != nil {
+				return nil, err
+			}
+			if err := checkLayers(finalImage, stage.Name, layers, opts); err != nil {
+				return nil, err
+			}
+			if opts.SBOMFormat != "" {
+				if err := recordSBOM(finalImage); err != nil {
 					return nil, err
 				}
 			}
-			timing.DefaultRun.Stop(t)
-			return sourceImage, nil
-		}
-		if stage.SaveStage {
-			if err := saveStageAsTarball(strconv.Itoa(index), sourceImage); err != nil {
-				return nil, err
+			images[stage.Name] = finalImage
+			if index == len(kanikoStages)-1 {
+				if opts.Cleanup {
+					if err = util.DeleteFilesystem(); err != nil {
+						return nil, err
+					}
+				}
+				timing.DefaultRun.Stop(t)
+				if err := writeOutputs(images, stageImages, opts); err != nil {
+					return nil, err
+				}
+				return images, nil
 			}
+			// Later targets may still build on this one or copy files from it
 		}
-
-		filesToSave, err := filesToSave(crossStageDependencies[index])
-		if err != nil {
+		if err := saveStage(index, stage, sourceImage, crossStageDependencies[index]); err != nil {
 			return nil, err
 		}
-		dstDir := filepath.Join(config.KanikoDir, strconv.Itoa(index))
-		if err := os.MkdirAll(dstDir, 0644); err != nil {
-			return nil, errors.Wrap(err,
-				fmt.Sprintf("to create workspace for stage %s",
-					stageIdxToDigest[strconv.Itoa(index)],
-				))
-		}
-		for _, p := range filesToSave {
-			logrus.Infof("Saving file %s for later use", p)
-			if err := util.CopyFileOrSymlink(p, dstDir, config.RootDir); err != nil {
-				return nil, errors.Wrap(err, "could not save file")
-			}
-		}
 
 		// Delete the filesystem
 		if err := util.DeleteFilesystem(); err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintf("deleting file system after stage %d", index))
 		}
+		scheduler.finish(index)
 	}
 
-	return nil, err
+	// The last stage was only built to be exported
+	timing.DefaultRun.Stop(t)
+	if err := writeOutputs(images, stageImages, opts); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// DoMultiPlatformBuild builds the Dockerfile once for every platform in opts.Platforms
+// and assembles the resulting images into an image index
+func DoMultiPlatformBuild(ctx context.Context, opts *config.KanikoOptions) (v1.ImageIndex, error) {
+	t := timing.Start("Total Multi-Platform Build Time")
+	defer timing.DefaultRun.Stop(t)
+
+	stagesDir := config.IntermediateStagesDir
+	defer func() { config.IntermediateStagesDir = stagesDir }()
+
+	var index v1.ImageIndex = empty.Index
+	for i, platform := range opts.Platforms {
+		p := remote.CurrentPlatform(platform)
+		if p.OS == "" || p.Architecture == "" {
+			return nil, fmt.Errorf("platform must be of the form os/arch[/variant], got %s", platform)
+		}
+		logrus.Infof("Building image for platform %s", platform)
+
+		platformOpts := *opts
+		platformOpts.CustomPlatform = platform
+		// The images of previous platforms may still read layers from their
+		// intermediate stage tarballs, so every platform gets its own directory.
+		config.IntermediateStagesDir = filepath.Join(stagesDir, strings.Join([]string{p.OS, p.Architecture, p.Variant}, "_"))
+
+		image, err := DoBuild(ctx, &platformOpts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "building image for platform %s", platform)
+		}
+
+		if i == 0 {
+			mt, err := image.MediaType()
+			if err != nil {
+				return nil, err
+			}
+			if mt == types.DockerManifestSchema2 {
+				index = mutate.IndexMediaType(index, types.DockerManifestList)
+			}
+		}
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add: image,
+			Descriptor: v1.Descriptor{
+				Platform: &p,
+			},
+		})
+
+		// DoBuild leaves the filesystem of the final stage behind unless cleanup is requested
+		if i < len(opts.Platforms)-1 && !opts.Cleanup {
+			if err := util.DeleteFilesystem(); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("deleting file system after platform %s", platform))
+			}
+		}
+	}
+	// the index is created when its images are, see reproducibleImage
+	var created time.Time
+	switch {
+	case !opts.SourceDateEpoch.IsZero():
+		created = opts.SourceDateEpoch
+	case !opts.Reproducible:
+		created = time.Now()
+	}
+	return annotateIndex(index, imageAnnotations(opts, created, baseImage{})), nil
 }
 
 // fileToSave returns all the files matching the given pattern in deps.
@@ -723,7 +1435,7 @@ func filesToSave(deps []string) ([]string, error) {
 	return deduped, nil
 }
 
-func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) error {
+func fetchExtraStages(ctx context.Context, stages []config.KanikoStage, opts *config.KanikoOptions, stageNameToIdx map[string]string, results *stageResults) error {
 	t := timing.Start("Fetching Extra Stages")
 	defer timing.DefaultRun.Stop(t)
 
@@ -731,6 +1443,12 @@ func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) e
 
 	for stageIndex, s := range stages {
 		for _, cmd := range s.Commands {
+			if r, ok := cmd.(*instructions.RunCommand); ok {
+				if err := fetchBindMountImages(ctx, r, stageIndex, opts, stageNameToIdx, results); err != nil {
+					return err
+				}
+				continue
+			}
 			c, ok := cmd.(*instructions.CopyCommand)
 			if !ok || c.From == "" {
 				continue
@@ -750,14 +1468,7 @@ func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) e
 
 			// This must be an image name, fetch it.
 			logrus.Debugf("Found extra base image stage %s", c.From)
-			sourceImage, err := remote.RetrieveRemoteImage(c.From, opts.RegistryOptions, opts.CustomPlatform)
-			if err != nil {
-				return err
-			}
-			if err := saveStageAsTarball(c.From, sourceImage); err != nil {
-				return err
-			}
-			if err := extractImageToDependencyDir(c.From, sourceImage); err != nil {
+			if err := fetchExtraImage(ctx, c.From, opts, results); err != nil {
 				return err
 			}
 		}
@@ -769,6 +1480,75 @@ func fetchExtraStages(stages []config.KanikoStage, opts *config.KanikoOptions) e
 	return nil
 }
 
+// fetchBindMountImages fetches the images the bind mounts of cmd use files from.
+// Those naming a stage must name one before the stage at stageIndex.
+func fetchBindMountImages(ctx context.Context, cmd *instructions.RunCommand, stageIndex int, opts *config.KanikoOptions, stageNameToIdx map[string]string, results *stageResults) error {
+	mounts, err := dockerfile.RunMounts(cmd)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if m.Type != dockerfile.MountTypeBind || m.From == "" {
+			continue
+		}
+		from := m.ResolveFrom(stageNameToIdx)
+		if fromIndex, err := strconv.Atoi(from); err == nil {
+			if fromIndex >= stageIndex || fromIndex < 0 {
+				return fmt.Errorf("%s mounts files from stage %s which isn't a previous stage", cmd.String(), m.From)
+			}
+			continue
+		}
+		logrus.Debugf("Found extra bind mount image %s", from)
+		if err := fetchExtraImage(ctx, from, opts, results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchExtraImage fetches the image name and extracts it for the stages to use its files,
+// and adds its digest to results
+func fetchExtraImage(ctx context.Context, name string, opts *config.KanikoOptions, results *stageResults) error {
+	if err := policy.CheckBaseImage(opts.BaseImagePolicy, name, "--from="+name); err != nil {
+		return err
+	}
+	lockedName, err := image_util.LockedReference(name, opts)
+	if err != nil {
+		return err
+	}
+	var sourceImage v1.Image
+	if opts.BaseImageLayout != "" {
+		layoutImage, err := image_util.LayoutImage(opts.BaseImageLayout, name, opts.CustomPlatform)
+		if err != nil {
+			return err
+		}
+		sourceImage = layoutImage
+	}
+	if sourceImage == nil {
+		remoteImage, err := remote.RetrieveRemoteImage(ctx, lockedName, opts.RegistryOptions, opts.CustomPlatform)
+		if err != nil {
+			return err
+		}
+		sourceImage = remoteImage
+	}
+	if err := image_util.RecordImage(name, sourceImage, opts); err != nil {
+		return err
+	}
+	if err := image_util.VerifyImage(ctx, name, sourceImage, opts); err != nil {
+		return err
+	}
+	digest, err := sourceImage.Digest()
+	if err != nil {
+		return err
+	}
+	provenance.AddMaterial(name, digest.String())
+	results.addImage(name, digest.String())
+	if err := saveStageAsTarball(name, sourceImage); err != nil {
+		return err
+	}
+	return extractImageToDependencyDir(ctx, name, sourceImage)
+}
+
 func fromPreviousStage(copyCommand *instructions.CopyCommand, previousStageNames []string) bool {
 	for _, previousStageName := range previousStageNames {
 		if previousStageName == copyCommand.From {
@@ -778,15 +1558,18 @@ func fromPreviousStage(copyCommand *instructions.CopyCommand, previousStageNames
 	return false
 }
 
-func extractImageToDependencyDir(name string, image v1.Image) error {
+func extractImageToDependencyDir(ctx context.Context, name string, image v1.Image) error {
 	t := timing.Start("Extracting Image to Dependency Dir")
 	defer timing.DefaultRun.Stop(t)
 	dependencyDir := filepath.Join(config.KanikoDir, name)
+	if err := os.RemoveAll(dependencyDir); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(dependencyDir, 0755); err != nil {
 		return err
 	}
 	logrus.Debugf("trying to extract to %s", dependencyDir)
-	_, err := util.GetFSFromImage(dependencyDir, image, util.ExtractFile)
+	_, err := util.GetFSFromImage(dependencyDir, image, util.ExtractFile, util.WithContext(ctx))
 	return err
 }
 
@@ -797,7 +1580,7 @@ func saveStageAsTarball(path string, image v1.Image) error {
 	if err != nil {
 		return err
 	}
-	tarPath := filepath.Join(constants.KanikoIntermediateStagesDir, path)
+	tarPath := filepath.Join(config.IntermediateStagesDir, path)
 	logrus.Infof("Storing source image from stage %s at path %s", path, tarPath)
 	if err := os.MkdirAll(filepath.Dir(tarPath), 0750); err != nil {
 		return err
@@ -819,11 +1602,14 @@ func getHasher(snapshotMode string) (func(string) (string, error), error) {
 	}
 }
 
-func resolveOnBuild(stage *config.KanikoStage, config *v1.Config, stageNameToIdx map[string]string) error {
+func resolveOnBuild(stage *config.KanikoStage, config *v1.Config, opts *config.KanikoOptions, stageNameToIdx map[string]string) error {
 	cmds, err := dockerfile.GetOnBuildInstructions(config, stageNameToIdx)
 	if err != nil {
 		return err
 	}
+	if err := checkOnBuildPolicy(cmds, opts, stageNameToIdx); err != nil {
+		return err
+	}
 
 	// Append to the beginning of the commands in the stage
 	stage.Commands = append(cmds, stage.Commands...)
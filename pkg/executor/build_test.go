@@ -19,6 +19,8 @@ package executor
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -27,10 +29,13 @@ import (
 	"sort"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/commands"
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	"github.com/google/go-cmp/cmp"
@@ -38,6 +43,8 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/moby/buildkit/frontend/dockerfile/instructions"
 )
 
@@ -358,6 +365,22 @@ COPY --from=second /bar /bat
 				1: {"/bar"},
 			},
 		},
+		{
+			name: "run bind mounts",
+			args: args{
+				dockerfile: `
+FROM scratch as Builder
+RUN foo
+FROM scratch
+RUN --mount=type=bind,from=builder,source=/out,target=/in \
+    --mount=type=bind,source=src,target=/src \
+    --mount=type=bind,from=0,target=/root bar
+`,
+			},
+			want: map[int][]string{
+				0: {"/out", "/"},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -383,7 +406,7 @@ COPY --from=second /bar /bat
 			}
 			stageNameToIdx := ResolveCrossStageInstructions(kanikoStages)
 
-			got, err := CalculateDependencies(kanikoStages, opts, stageNameToIdx)
+			got, err := CalculateDependencies(context.TODO(), kanikoStages, opts, stageNameToIdx)
 			if err != nil {
 				t.Errorf("got error: %s,", err)
 			}
@@ -566,7 +589,7 @@ func Test_stageBuilder_optimize(t *testing.T) {
 				cacheCommand: MockCachedDockerCommand{},
 			}
 			sb.cmds = []commands.DockerCommand{command}
-			err = sb.optimize(ck, cf.Config)
+			err = sb.optimize(context.TODO(), ck, cf.Config)
 			if err != nil {
 				t.Errorf("Expected error to be nil but was %v", err)
 			}
@@ -699,6 +722,33 @@ func Test_stageBuilder_populateCompositeKey(t *testing.T) {
 	}
 }
 
+func Test_stageBuilder_populateCompositeKey_bindMountImage(t *testing.T) {
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nRUN --mount=type=bind,from=alpine:3,target=/in cat /in/etc/os-release\n"))
+	testutil.CheckError(t, false, err)
+	cmd, err := commands.GetCommand(stages[0].Commands[0], util.FileContext{Root: "workspace"}, false, false, commands.RunOptions{})
+	testutil.CheckError(t, false, err)
+
+	keyFor := func(digest string) string {
+		results := newStageResults()
+		results.addImage("alpine:3", digest)
+		digestToCacheKey, stageIdxToDigest := results.cacheKeys()
+		sb := &stageBuilder{
+			fileContext:      util.FileContext{Root: "workspace"},
+			digestToCacheKey: digestToCacheKey,
+			stageIdxToDigest: stageIdxToDigest,
+		}
+		ck, err := sb.populateCompositeKey(cmd, []string{}, CompositeCache{}, dockerfile.NewBuildArgs([]string{}), []string{})
+		testutil.CheckError(t, false, err)
+		key, err := ck.Hash()
+		testutil.CheckError(t, false, err)
+		return key
+	}
+	if keyFor("sha256:aaaa") == keyFor("sha256:bbbb") {
+		t.Errorf("expected the cache key to change with the digest of the image mounted from")
+	}
+	testutil.CheckDeepEqual(t, keyFor("sha256:aaaa"), keyFor("sha256:aaaa"))
+}
+
 func Test_stageBuilder_build(t *testing.T) {
 	type testcase struct {
 		description       string
@@ -1231,7 +1281,7 @@ RUN foobar
 				cf:          cf,
 				snapshotter: snap,
 				layerCache:  lc,
-				pushLayerToCache: func(_ *config.KanikoOptions, cacheKey, _, _ string) error {
+				pushLayerToCache: func(_ context.Context, _ *config.KanikoOptions, cacheKey, _, _ string) error {
 					keys = append(keys, cacheKey)
 					return nil
 				},
@@ -1244,7 +1294,7 @@ RUN foobar
 			if tc.rootDir != "" {
 				config.RootDir = tc.rootDir
 			}
-			err := sb.build()
+			err := sb.build(context.TODO())
 			if err != nil {
 				t.Errorf("Expected error to be nil but was %v", err)
 			}
@@ -1289,6 +1339,7 @@ func getCommands(fileContext util.FileContext, cmds []instructions.Command, cach
 			fileContext,
 			false,
 			cacheCopy,
+			commands.RunOptions{},
 		)
 		if err != nil {
 			panic(err)
@@ -1404,3 +1455,249 @@ func Test_ResolveCrossStageInstructions(t *testing.T) {
 		testutil.CheckDeepEqual(t, expectedMap, stageToIdx)
 	}
 }
+
+func Test_stageBuilder_emitSnapshotTaken(t *testing.T) {
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	tw := tar.NewWriter(f)
+	for _, name := range []string{"foo", "bar/.wh.baz"} {
+		content := []byte("meow")
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	f.Close()
+
+	b := bytes.Buffer{}
+	events.DefaultStream.SetOutput(&b)
+	defer events.DefaultStream.SetOutput(nil)
+	sb := &stageBuilder{stage: config.KanikoStage{Index: 1}}
+	sb.emitSnapshotTaken(f.Name())
+
+	var e events.Event
+	if err := json.Unmarshal(b.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, events.SnapshotTaken, e.Type)
+	testutil.CheckDeepEqual(t, 1, *e.Stage)
+	testutil.CheckDeepEqual(t, 2, e.Files)
+	testutil.CheckDeepEqual(t, fi.Size(), e.Size)
+}
+
+func Test_stageBuilder_squash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	original := config.KanikoDir
+	config.KanikoDir = dir
+	defer func() { config.KanikoDir = original }()
+
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := base
+	for i := 0; i < 3; i++ {
+		layer, err := random.Layer(1024, types.DockerLayer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		image, err = mutate.AppendLayers(image, layer)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		opts           *config.KanikoOptions
+		expectedLayers int
+	}{
+		{name: "no squash", opts: &config.KanikoOptions{}, expectedLayers: 5},
+		{name: "squash", opts: &config.KanikoOptions{Squash: true}, expectedLayers: 3},
+		{name: "squash stage", opts: &config.KanikoOptions{SquashStages: []string{"Builder"}}, expectedLayers: 3},
+		{name: "squash other stage", opts: &config.KanikoOptions{SquashStages: []string{"other"}}, expectedLayers: 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sb := &stageBuilder{
+				stage:     config.KanikoStage{Stage: instructions.Stage{Name: "builder"}},
+				baseImage: base,
+				image:     image,
+				opts:      test.opts,
+			}
+			if sb.shouldSquash() {
+				if err := sb.squash(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			layers, err := sb.image.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckDeepEqual(t, test.expectedLayers, len(layers))
+		})
+	}
+}
+
+func Test_stageBuilder_saveSnapshotToLayer_compression(t *testing.T) {
+	f, err := ioutil.TempFile("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	tw := tar.NewWriter(f)
+	content := bytes.Repeat([]byte("meow"), 1024)
+	if err := tw.WriteHeader(&tar.Header{Name: "foo", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	f.Close()
+
+	// estargz can't be used with every Go version, see checkEstargz
+	estargzUnsupported := checkEstargz() != nil
+
+	tests := []struct {
+		name              string
+		opts              *config.KanikoOptions
+		shouldErr         bool
+		expectedTOC       bool
+		expectedMediaType types.MediaType
+	}{
+		{name: "default", opts: &config.KanikoOptions{}},
+		{name: "gzip level", opts: &config.KanikoOptions{Compression: constants.CompressionGzip, CompressionLevel: 9}},
+		{name: "estargz", opts: &config.KanikoOptions{Compression: constants.CompressionEstargz}, shouldErr: estargzUnsupported, expectedTOC: true},
+		{name: "zstd", opts: &config.KanikoOptions{Compression: constants.CompressionZstd, CompressionLevel: 9}, expectedMediaType: util.ZstdLayerMediaType},
+		{name: "invalid", opts: &config.KanikoOptions{Compression: "lz4"}, shouldErr: true},
+		{name: "invalid level", opts: &config.KanikoOptions{CompressionLevel: 10}, shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sb := &stageBuilder{opts: test.opts}
+			layer, err := sb.saveSnapshotToLayer(f.Name())
+			testutil.CheckError(t, test.shouldErr, err)
+			if test.shouldErr {
+				return
+			}
+			desc, err := partial.Descriptor(layer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, ok := desc.Annotations["containerd.io/snapshot/stargz/toc.digest"]
+			testutil.CheckDeepEqual(t, test.expectedTOC, ok)
+			if test.expectedMediaType != "" {
+				testutil.CheckDeepEqual(t, test.expectedMediaType, desc.MediaType)
+			}
+			rc, err := layer.Uncompressed()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			hdr, err := tar.NewReader(rc).Next()
+			testutil.CheckErrorAndDeepEqual(t, false, err, "foo", hdr.Name)
+		})
+	}
+}
+
+func Test_layerMediaType(t *testing.T) {
+	opts := &config.KanikoOptions{}
+	docker, err := layerMediaType(empty.Image, opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, types.DockerLayer, docker)
+	oci, err := layerMediaType(mutate.MediaType(empty.Image, types.OCIManifestSchema1), opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, types.OCILayer, oci)
+	zstd, err := layerMediaType(empty.Image, &config.KanikoOptions{Compression: constants.CompressionZstd})
+	testutil.CheckErrorAndDeepEqual(t, false, err, util.ZstdLayerMediaType, zstd)
+}
+
+func Test_compressionImage(t *testing.T) {
+	image, err := compressionImage(empty.Image, &config.KanikoOptions{})
+	testutil.CheckErrorAndDeepEqual(t, false, err, empty.Image, image)
+	image, err = compressionImage(empty.Image, &config.KanikoOptions{Compression: constants.CompressionZstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt, err := image.MediaType()
+	testutil.CheckErrorAndDeepEqual(t, false, err, types.OCIManifestSchema1, mt)
+}
+
+func Test_reproducibleImage(t *testing.T) {
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := base.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf = cf.DeepCopy()
+	cf.Config.Hostname = "builder"
+	cf.DockerVersion = "20.10"
+	cf.Created = v1.Time{Time: time.Unix(1700000000, 0)}
+	base, err = mutate.ConfigFile(base, cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseLayers, err := base.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseDigest, err := baseLayers[0].Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		description string
+		epoch       time.Time
+		created     time.Time
+	}{
+		{
+			description: "without source date epoch",
+		},
+		{
+			description: "with source date epoch",
+			epoch:       time.Unix(1600000000, 0),
+			created:     time.Unix(1700000000, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			img, err := reproducibleImage(base, &config.KanikoOptions{SourceDateEpoch: tt.epoch})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cf, err := img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckDeepEqual(t, "", cf.Config.Hostname)
+			testutil.CheckDeepEqual(t, "", cf.DockerVersion)
+			testutil.CheckDeepEqual(t, tt.created.Unix(), cf.Created.Unix())
+			layers, err := img.Layers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := layers[0].Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckDeepEqual(t, baseDigest, d)
+		})
+	}
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package executor
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -44,7 +45,7 @@ COPY --from=first copied/bam.txt output/bam.txt`)
 			SrcContext:     filepath.Join(testDir, "workspace"),
 			SnapshotMode:   constants.SnapshotModeFull,
 		}
-		_, err := DoBuild(opts)
+		_, err := DoBuild(context.TODO(), opts)
 		testutil.CheckNoError(t, err)
 		// Check Image has one layer bam.txt
 		files, err := ioutil.ReadDir(filepath.Join(testDir, "output"))
@@ -72,7 +73,7 @@ COPY --from=first copied/bam.txt output/`)
 			SrcContext:     filepath.Join(testDir, "workspace"),
 			SnapshotMode:   constants.SnapshotModeFull,
 		}
-		_, err := DoBuild(opts)
+		_, err := DoBuild(context.TODO(), opts)
 		testutil.CheckNoError(t, err)
 		files, err := ioutil.ReadDir(filepath.Join(testDir, "output"))
 		if err != nil {
@@ -97,7 +98,7 @@ COPY --from=first copied another`)
 			SrcContext:     filepath.Join(testDir, "workspace"),
 			SnapshotMode:   constants.SnapshotModeFull,
 		}
-		_, err := DoBuild(opts)
+		_, err := DoBuild(context.TODO(), opts)
 		testutil.CheckNoError(t, err)
 		// Check Image has one layer bam.txt
 		files, err := ioutil.ReadDir(filepath.Join(testDir, "another"))
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/commands"
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+)
+
+// failureSnapshotTag is the tag of the image in --on-failure-snapshot tarballs and layouts
+const failureSnapshotTag = "kaniko-failure:latest"
+
+// The prefixes of --on-failure-snapshot, saying where the image is written to. The
+// filesystem of a failed command may hold secrets, it is never pushed to a registry
+// unless asked to explicitly.
+const (
+	failureSnapshotTarballPrefix  = "tar:"
+	failureSnapshotLayoutPrefix   = "oci:"
+	failureSnapshotRegistryPrefix = "docker://"
+)
+
+// saveFailureSnapshot snapshots the filesystem command left behind when it failed with
+// cmdErr, appends it to the image of the stage and writes the image to --on-failure-snapshot
+func (s *stageBuilder) saveFailureSnapshot(ctx context.Context, command commands.DockerCommand, cmdErr error) error {
+	t := timing.Start("Failure Snapshot")
+	defer timing.DefaultRun.Stop(t)
+
+	logrus.Infof("Taking a snapshot of the filesystem %s failed with", command.String())
+	tarPath, err := s.snapshotter.TakeSnapshotFS()
+	if err != nil {
+		return errors.Wrap(err, "taking snapshot")
+	}
+	layer, err := s.saveSnapshotToLayer(tarPath)
+	if err != nil {
+		return err
+	}
+	image, err := failureImage(s.image, layer, s.cf.Config, command.String(), cmdErr, s.opts)
+	if err != nil {
+		return err
+	}
+	return writeFailureSnapshot(ctx, image, s.opts)
+}
+
+// failureImage returns image with layer appended, recording the failure of createdBy in
+// its history, and the config the stage had when the command failed
+func failureImage(image v1.Image, layer v1.Layer, cfg v1.Config, createdBy string, cmdErr error, opts *config.KanikoOptions) (v1.Image, error) {
+	history := v1.History{
+		Author:    constants.Author,
+		CreatedBy: createdBy,
+		Comment:   "failed: " + cmdErr.Error(),
+	}
+	var err error
+	if layer != nil {
+		mediaType, err := layerMediaType(image, opts)
+		if err != nil {
+			return nil, err
+		}
+		image, err = mutate.Append(image, mutate.Addendum{
+			Layer:     layer,
+			MediaType: mediaType,
+			History:   history,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cf, err := image.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		cf = cf.DeepCopy()
+		history.EmptyLayer = true
+		cf.History = append(cf.History, history)
+		image, err = mutate.ConfigFile(image, cf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	image, err = mutate.Config(image, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// CheckOnFailureSnapshot returns an error if the --on-failure-snapshot of opts doesn't
+// start with tar:, oci: or docker://, or names no path or no valid registry reference
+func CheckOnFailureSnapshot(opts *config.KanikoOptions) error {
+	dest := opts.OnFailureSnapshot
+	if dest == "" {
+		return nil
+	}
+	prefix, target := splitFailureSnapshot(dest)
+	switch prefix {
+	case "":
+		return fmt.Errorf("%s must start with %s, %s or %s", dest, failureSnapshotTarballPrefix, failureSnapshotLayoutPrefix, failureSnapshotRegistryPrefix)
+	case failureSnapshotRegistryPrefix:
+		_, err := name.NewTag(target, name.WeakValidation)
+		return err
+	}
+	if target == "" {
+		return fmt.Errorf("%s names no path", dest)
+	}
+	return nil
+}
+
+// FailureSnapshotPath returns the path of the tarball or the OCI layout the
+// --on-failure-snapshot dest names, or "" if it names a registry reference
+func FailureSnapshotPath(dest string) string {
+	prefix, target := splitFailureSnapshot(dest)
+	if prefix == failureSnapshotTarballPrefix || prefix == failureSnapshotLayoutPrefix {
+		return target
+	}
+	return ""
+}
+
+// splitFailureSnapshot splits the --on-failure-snapshot dest into its prefix and the
+// path or reference after it. The prefix is "" if dest has none of the known ones.
+func splitFailureSnapshot(dest string) (string, string) {
+	for _, prefix := range []string{failureSnapshotTarballPrefix, failureSnapshotLayoutPrefix, failureSnapshotRegistryPrefix} {
+		if strings.HasPrefix(dest, prefix) {
+			return prefix, strings.TrimPrefix(dest, prefix)
+		}
+	}
+	return "", dest
+}
+
+// writeFailureSnapshot writes image to the --on-failure-snapshot of opts: a tarball,
+// an OCI layout or a registry reference, depending on its prefix
+func writeFailureSnapshot(ctx context.Context, image v1.Image, opts *config.KanikoOptions) error {
+	prefix, target := splitFailureSnapshot(opts.OnFailureSnapshot)
+	d, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	saved := events.Event{Type: events.FailureSnapshotSaved, Digest: d.String()}
+	tag, err := name.NewTag(failureSnapshotTag)
+	if err != nil {
+		return err
+	}
+	switch prefix {
+	case failureSnapshotTarballPrefix:
+		if err := tarball.WriteToFile(target, tag, image); err != nil {
+			return errors.Wrap(err, "writing failure snapshot tarball")
+		}
+	case failureSnapshotLayoutPrefix:
+		// Other images in the layout are kept, the snapshot of an earlier failure is replaced
+		if err := writeToLayout(target, image, []name.Tag{tag}); err != nil {
+			return errors.Wrap(err, "writing failure snapshot to layout")
+		}
+	case failureSnapshotRegistryPrefix:
+		ref, err := name.NewTag(target, name.WeakValidation)
+		if err != nil {
+			return errors.Wrap(err, "getting tag for failure snapshot")
+		}
+		writeFunc := func(ref name.Tag, options ...remote.Option) error {
+			return remote.Write(ref, image, options...)
+		}
+		return pushToDestinations(ctx, []name.Tag{ref}, opts, writeFunc, saved)
+	default:
+		return fmt.Errorf("unknown failure snapshot destination %s", opts.OnFailureSnapshot)
+	}
+	logrus.Infof("Saved the failed filesystem to %s", target)
+	saved.Image = target
+	events.Emit(saved)
+	return nil
+}
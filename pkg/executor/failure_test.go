@@ -0,0 +1,192 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	image_util "github.com/GoogleContainerTools/kaniko/pkg/image"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func Test_stageBuilder_saveFailureSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot := filepath.Join(dir, "snapshot.tar")
+	f, err := os.Create(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	content := []byte("half-written output")
+	if err := tw.WriteHeader(&tar.Header{Name: "out/log", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	f.Close()
+
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdErr := errors.New("exit status 2")
+	cfg := v1.Config{Env: []string{"STEP=test"}, WorkingDir: "/src"}
+
+	check := func(t *testing.T, image v1.Image) {
+		layers, err := image.Layers()
+		testutil.CheckErrorAndDeepEqual(t, false, err, 3, len(layers))
+		cf, err := image.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckDeepEqual(t, cfg.Env, cf.Config.Env)
+		testutil.CheckDeepEqual(t, cfg.WorkingDir, cf.Config.WorkingDir)
+		last := cf.History[len(cf.History)-1]
+		testutil.CheckDeepEqual(t, "RUN make test", last.CreatedBy)
+		testutil.CheckDeepEqual(t, "failed: exit status 2", last.Comment)
+	}
+
+	t.Run("tarball", func(t *testing.T) {
+		dest := filepath.Join(dir, "failure.tar")
+		sb := &stageBuilder{
+			image:       base,
+			cf:          &v1.ConfigFile{Config: cfg},
+			snapshotter: fakeSnapShotter{tarPath: snapshot},
+			opts:        &config.KanikoOptions{OnFailureSnapshot: "tar:" + dest},
+		}
+		if err := sb.saveFailureSnapshot(context.Background(), MockDockerCommand{command: "RUN make test"}, cmdErr); err != nil {
+			t.Fatal(err)
+		}
+		tag, err := name.NewTag(failureSnapshotTag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		image, err := tarball.ImageFromPath(dest, &tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, image)
+	})
+
+	t.Run("oci layout", func(t *testing.T) {
+		dest := filepath.Join(dir, "layout")
+		// An image already in the layout is kept
+		if err := writeToLayout(dest, base, nil); err != nil {
+			t.Fatal(err)
+		}
+		sb := &stageBuilder{
+			image:       base,
+			cf:          &v1.ConfigFile{Config: cfg},
+			snapshotter: fakeSnapShotter{tarPath: snapshot},
+			opts:        &config.KanikoOptions{OnFailureSnapshot: "oci:" + dest},
+		}
+		// The snapshot of the second failure replaces the one of the first
+		for i := 0; i < 2; i++ {
+			if err := sb.saveFailureSnapshot(context.Background(), MockDockerCommand{command: "RUN make test"}, cmdErr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		index, err := layout.ImageIndexFromPath(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil || len(manifest.Manifests) != 2 {
+			t.Fatalf("expected two images in the layout, got %v %v", manifest, err)
+		}
+		tag, err := name.NewTag(failureSnapshotTag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckDeepEqual(t, image_util.LayoutRefName(tag), manifest.Manifests[1].Annotations[image_util.RefNameAnnotation])
+		image, err := index.Image(manifest.Manifests[1].Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, image)
+	})
+}
+
+func Test_failureImage_noChanges(t *testing.T) {
+	base, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := failureImage(base, nil, v1.Config{}, "RUN false", errors.New("exit status 1"), &config.KanikoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := image.Layers()
+	testutil.CheckErrorAndDeepEqual(t, false, err, 2, len(layers))
+	cf, err := image.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := cf.History[len(cf.History)-1]
+	testutil.CheckDeepEqual(t, true, last.EmptyLayer)
+	testutil.CheckDeepEqual(t, "RUN false", last.CreatedBy)
+}
+
+func TestCheckOnFailureSnapshot(t *testing.T) {
+	tests := []struct {
+		dest      string
+		shouldErr bool
+	}{
+		{dest: ""},
+		{dest: "tar:failure.tar"},
+		{dest: "oci:/workspace/failure"},
+		{dest: "docker://gcr.io/project/app:failed"},
+		{dest: "docker://gcr.io/project/App:failed", shouldErr: true},
+		{dest: "tar:", shouldErr: true},
+		{dest: "failure.tar", shouldErr: true},
+		{dest: "/workspace/failure", shouldErr: true},
+		{dest: "debug", shouldErr: true},
+		{dest: "gcr.io/project/app:failed", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.dest, func(t *testing.T) {
+			err := CheckOnFailureSnapshot(&config.KanikoOptions{OnFailureSnapshot: test.dest})
+			testutil.CheckError(t, test.shouldErr, err)
+		})
+	}
+}
+
+func TestFailureSnapshotPath(t *testing.T) {
+	testutil.CheckDeepEqual(t, "/workspace/failure.tar", FailureSnapshotPath("tar:/workspace/failure.tar"))
+	testutil.CheckDeepEqual(t, "failure", FailureSnapshotPath("oci:failure"))
+	testutil.CheckDeepEqual(t, "", FailureSnapshotPath("docker://gcr.io/project/app:failed"))
+	testutil.CheckDeepEqual(t, "", FailureSnapshotPath("/workspace/failure.tar"))
+}
@@ -19,12 +19,14 @@ package executor
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"io/ioutil"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/commands"
 	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
@@ -41,6 +43,7 @@ func (f fakeSnapShotter) TakeSnapshotFS() (string, error) {
 func (f fakeSnapShotter) TakeSnapshot(_ []string, _ bool) (string, error) {
 	return f.tarPath, nil
 }
+func (f fakeSnapShotter) Changes() []snapshot.Change { return nil }
 
 type MockDockerCommand struct {
 	command      string
@@ -48,7 +51,9 @@ type MockDockerCommand struct {
 	cacheCommand commands.DockerCommand
 }
 
-func (m MockDockerCommand) ExecuteCommand(c *v1.Config, args *dockerfile.BuildArgs) error { return nil }
+func (m MockDockerCommand) ExecuteCommand(ctx context.Context, c *v1.Config, args *dockerfile.BuildArgs) error {
+	return nil
+}
 func (m MockDockerCommand) String() string {
 	return m.command
 }
@@ -81,7 +86,7 @@ type MockCachedDockerCommand struct {
 	contextFiles []string
 }
 
-func (m MockCachedDockerCommand) ExecuteCommand(c *v1.Config, args *dockerfile.BuildArgs) error {
+func (m MockCachedDockerCommand) ExecuteCommand(ctx context.Context, c *v1.Config, args *dockerfile.BuildArgs) error {
 	return nil
 }
 func (m MockCachedDockerCommand) String() string {
@@ -0,0 +1,280 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-units"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
+)
+
+const (
+	// largestFiles is the number of the largest files a LayerReport lists
+	largestFiles = 10
+	// largestLayers is the number of the largest layers listed when the image is over --max-image-size
+	largestLayers = 3
+)
+
+// LayerReport attributes a layer of the image to the Dockerfile command which created it
+type LayerReport struct {
+	Digest string `json:"digest"`
+	// Size is the compressed size of the layer
+	Size      int64  `json:"size"`
+	Stage     *int   `json:"stage,omitempty"`
+	StageName string `json:"stageName,omitempty"`
+	// Command is empty for the layers of the base image
+	Command string `json:"command,omitempty"`
+	// Cached is true for layers taken from the cache, whose changes aren't known
+	Cached   bool `json:"cached,omitempty"`
+	Added    int  `json:"added"`
+	Modified int  `json:"modified"`
+	Deleted  int  `json:"deleted"`
+	// Largest are the largest files the layer adds or modifies
+	Largest []snapshot.Change `json:"largest,omitempty"`
+	Changes []snapshot.Change `json:"changes,omitempty"`
+}
+
+// ImageLayerReport is the layer report of the image of a target
+type ImageLayerReport struct {
+	Stage    string `json:"stage,omitempty"`
+	Platform string `json:"platform,omitempty"`
+	// Size is the compressed size of the layers and the config of the image
+	Size   int64         `json:"size"`
+	Layers []LayerReport `json:"layers"`
+}
+
+// imageLayerReports collects the layer reports of the images of the build for --layer-report
+var imageLayerReports = &layerReports{}
+
+// layerReports is a list of image layer reports which is safe for concurrent use
+type layerReports struct {
+	mu      sync.Mutex
+	reports []ImageLayerReport // protected by mu
+}
+
+func (r *layerReports) add(report ImageLayerReport) []ImageLayerReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, report)
+	return append([]ImageLayerReport{}, r.reports...)
+}
+
+// newLayerReport returns the report of layer, created by command with the given changes
+func newLayerReport(layer v1.Layer, command string, changes []snapshot.Change) (LayerReport, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return LayerReport{}, err
+	}
+	size, err := layer.Size()
+	if err != nil {
+		return LayerReport{}, err
+	}
+	r := LayerReport{Digest: digest.String(), Size: size, Command: command, Changes: changes}
+	r.summarize()
+	return r, nil
+}
+
+// summarize counts the changes of r and picks its largest files
+func (r *LayerReport) summarize() {
+	r.Added, r.Modified, r.Deleted = 0, 0, 0
+	var files []snapshot.Change
+	for _, c := range r.Changes {
+		switch c.Type {
+		case snapshot.ChangeAdded:
+			r.Added++
+		case snapshot.ChangeModified:
+			r.Modified++
+		case snapshot.ChangeDeleted:
+			r.Deleted++
+		}
+		if c.Size > 0 {
+			files = append(files, c)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Size > files[j].Size
+	})
+	if len(files) > largestFiles {
+		files = files[:largestFiles]
+	}
+	r.Largest = files
+}
+
+// squashLayerReports returns the report of the layer which squashes the layers of reports
+func squashLayerReports(layer v1.Layer, command string, reports []LayerReport) (LayerReport, error) {
+	latest := map[string]snapshot.Change{}
+	for _, r := range reports {
+		for _, c := range r.Changes {
+			latest[c.Path] = c
+		}
+	}
+	changes := make([]snapshot.Change, 0, len(latest))
+	for _, c := range latest {
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return newLayerReport(layer, command, changes)
+}
+
+// recordLayer attributes layer, just added to the image of the stage, to the command
+// createdBy which made the given changes, and checks it against --max-layer-size
+func (s *stageBuilder) recordLayer(layer v1.Layer, createdBy string, changes []snapshot.Change, cached bool) error {
+	r, err := newLayerReport(layer, createdBy, changes)
+	if err != nil {
+		return err
+	}
+	r.Cached = cached
+	return s.addLayerReport(r)
+}
+
+// addLayerReport adds r to the reports of the layers of the stage and checks it against --max-layer-size
+func (s *stageBuilder) addLayerReport(r LayerReport) error {
+	stage := s.stage.Index
+	r.Stage = &stage
+	r.StageName = s.stage.Name
+	s.layerReports = append(s.layerReports, r)
+
+	if max := int64(s.opts.MaxLayerSize); max > 0 && r.Size > max {
+		return fmt.Errorf("the layer of %s is %s, over --max-layer-size %s, its largest files are:%s", r.Command, units.BytesSize(float64(r.Size)), units.BytesSize(float64(max)), largestFilesList(r, ""))
+	}
+	return nil
+}
+
+// largestFilesList lists the largest files of r, one per line after indent
+func largestFilesList(r LayerReport, indent string) string {
+	if r.Cached {
+		return "\n" + indent + "  the layer was taken from the cache, its files aren't known"
+	}
+	var lines []string
+	for _, c := range r.Largest {
+		lines = append(lines, fmt.Sprintf("\n%s  %s %s", indent, c.Path, units.BytesSize(float64(c.Size))))
+	}
+	return strings.Join(lines, "")
+}
+
+// stageLayerReports returns the reports of the layers of the image of a stage built by sb,
+// those of its base image first, given those of the stage it is based on if any
+func stageLayerReports(sb *stageBuilder, base []LayerReport) ([]LayerReport, error) {
+	if sb.opts.LayerReport == "" && sb.opts.MaxImageSize == 0 {
+		return nil, nil
+	}
+	var reports []LayerReport
+	if sb.stage.BaseImageStoredLocally {
+		reports = append(reports, base...)
+	} else {
+		layers, err := sb.baseImage.Layers()
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range layers {
+			digest, err := l.Digest()
+			if err != nil {
+				return nil, err
+			}
+			size, err := l.Size()
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, LayerReport{Digest: digest.String(), Size: size})
+		}
+	}
+	return append(reports, sb.layerReports...), nil
+}
+
+// checkLayers writes the layer report of image, the image of the stage stageName whose
+// layers are described by reports, to --layer-report and checks it against --max-image-size
+func checkLayers(image v1.Image, stageName string, reports []LayerReport, opts *config.KanikoOptions) error {
+	if opts.LayerReport == "" && opts.MaxImageSize == 0 {
+		return nil
+	}
+	m, err := image.Manifest()
+	if err != nil {
+		return err
+	}
+	report := ImageLayerReport{Stage: stageName, Platform: opts.CustomPlatform, Size: m.Config.Size}
+	if len(reports) != len(m.Layers) {
+		logrus.Debugf("The image has %d layers but %d are reported, only reporting their sizes", len(m.Layers), len(reports))
+		reports = make([]LayerReport, len(m.Layers))
+	}
+	for i, l := range m.Layers {
+		// the layers may have been rewritten since, e.g. by --reproducible
+		r := reports[i]
+		r.Digest = l.Digest.String()
+		r.Size = l.Size
+		report.Size += l.Size
+		report.Layers = append(report.Layers, r)
+	}
+
+	all := imageLayerReports.add(report)
+	if opts.LayerReport != "" {
+		if err := writeLayerReports(opts.LayerReport, all); err != nil {
+			return errors.Wrap(err, "writing layer report")
+		}
+	}
+
+	max := int64(opts.MaxImageSize)
+	if max == 0 || report.Size <= max {
+		return nil
+	}
+	layers := append([]LayerReport{}, report.Layers...)
+	sort.SliceStable(layers, func(i, j int) bool {
+		return layers[i].Size > layers[j].Size
+	})
+	if len(layers) > largestLayers {
+		layers = layers[:largestLayers]
+	}
+	var lines []string
+	for _, l := range layers {
+		command := l.Command
+		if command == "" {
+			command = "the base image"
+		}
+		line := fmt.Sprintf("\n  %s %s", units.BytesSize(float64(l.Size)), command)
+		if l.Command != "" {
+			line += largestFilesList(l, "  ")
+		}
+		lines = append(lines, line)
+	}
+	return fmt.Errorf("the image is %s, over --max-image-size %s, its largest layers are:%s", units.BytesSize(float64(report.Size)), units.BytesSize(float64(max)), strings.Join(lines, ""))
+}
+
+// writeLayerReports writes reports as JSON to path
+func writeLayerReports(path string, reports []ImageLayerReport) error {
+	b, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
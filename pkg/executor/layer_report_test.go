@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/snapshot"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func Test_squashLayerReports(t *testing.T) {
+	layer, err := random.Layer(1024, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := []LayerReport{
+		{Command: "COPY . /app", Changes: []snapshot.Change{
+			{Path: "/app/big", Type: snapshot.ChangeAdded, Size: 300},
+			{Path: "/app/small", Type: snapshot.ChangeAdded, Size: 10},
+		}},
+		{Command: "RUN rm /app/big && echo > /app/small", Changes: []snapshot.Change{
+			{Path: "/app/big", Type: snapshot.ChangeDeleted},
+			{Path: "/app/small", Type: snapshot.ChangeModified, Size: 1},
+		}},
+	}
+	r, err := squashLayerReports(layer, "squashed 2 layers", reports)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []snapshot.Change{
+		{Path: "/app/big", Type: snapshot.ChangeDeleted},
+		{Path: "/app/small", Type: snapshot.ChangeModified, Size: 1},
+	}, r.Changes)
+	testutil.CheckDeepEqual(t, []snapshot.Change{{Path: "/app/small", Type: snapshot.ChangeModified, Size: 1}}, r.Largest)
+	testutil.CheckDeepEqual(t, 0, r.Added)
+	testutil.CheckDeepEqual(t, 1, r.Modified)
+	testutil.CheckDeepEqual(t, 1, r.Deleted)
+	size, _ := layer.Size()
+	testutil.CheckDeepEqual(t, size, r.Size)
+}
+
+func TestDoBuild_LayerReport(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	// the context is outside of the root, which is deleted between the stages
+	srcContext, err := ioutil.TempDir("", "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcContext)
+	ioutil.WriteFile(filepath.Join(srcContext, "bam.txt"), []byte("meow"), 0644)
+	ioutil.WriteFile(filepath.Join(srcContext, "exec"), []byte("woof"), 0755)
+	dockerFile := `
+FROM scratch AS base
+COPY bam.txt copied/
+FROM base
+COPY exec bin/`
+	ioutil.WriteFile(filepath.Join(srcContext, "Dockerfile"), []byte(dockerFile), 0644)
+	reportPath := filepath.Join(testDir, "layers.json")
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(srcContext, "Dockerfile"),
+		SrcContext:     srcContext,
+		SnapshotMode:   constants.SnapshotModeFull,
+		LayerReport:    reportPath,
+		NoPush:         true,
+	}
+	original := imageLayerReports
+	defer func() { imageLayerReports = original }()
+
+	imageLayerReports = &layerReports{}
+	_, err = DoBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	b, err := ioutil.ReadFile(reportPath)
+	testutil.CheckNoError(t, err)
+	var reports []ImageLayerReport
+	testutil.CheckNoError(t, json.Unmarshal(b, &reports))
+	testutil.CheckDeepEqual(t, 1, len(reports))
+	layers := reports[0].Layers
+	testutil.CheckDeepEqual(t, 2, len(layers))
+	testutil.CheckDeepEqual(t, "COPY bam.txt copied/", layers[0].Command)
+	testutil.CheckDeepEqual(t, "base", layers[0].StageName)
+	testutil.CheckDeepEqual(t, "COPY exec bin/", layers[1].Command)
+	testutil.CheckDeepEqual(t, 1, *layers[1].Stage)
+	testutil.CheckDeepEqual(t, []snapshot.Change{{Path: "/bin/exec", Type: snapshot.ChangeAdded, Size: 4}}, layers[1].Largest)
+
+	// the image is over --max-image-size
+	imageLayerReports = &layerReports{}
+	opts.LayerReport = ""
+	testutil.CheckNoError(t, opts.MaxImageSize.Set("1KB"))
+	_, err = DoBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	opts.MaxImageSize = 1
+	_, err = DoBuild(context.TODO(), opts)
+	testutil.CheckError(t, true, err)
+	if !strings.Contains(err.Error(), "COPY exec bin/") {
+		t.Errorf("expected the error to list the largest layers, got %s", err)
+	}
+
+	// a layer is over --max-layer-size
+	opts.MaxImageSize = 0
+	opts.MaxLayerSize = 1
+	_, err = DoBuild(context.TODO(), opts)
+	testutil.CheckError(t, true, err)
+	if !strings.Contains(err.Error(), "/copied/bam.txt") {
+		t.Errorf("expected the error to list the largest files, got %s", err)
+	}
+}
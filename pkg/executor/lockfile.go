@@ -0,0 +1,37 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	image_util "github.com/GoogleContainerTools/kaniko/pkg/image"
+)
+
+// DoLockfile writes the digests the images of the build resolved to to opts.LockfileOut
+func DoLockfile(opts *config.KanikoOptions) error {
+	if opts.LockfileOut == "" {
+		return nil
+	}
+	if err := image_util.WriteLockfile(opts.LockfileOut, image_util.DefaultResolvedImages.Lockfile()); err != nil {
+		return errors.Wrap(err, "writing lockfile")
+	}
+	logrus.Infof("Wrote lockfile to %s", opts.LockfileOut)
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+)
+
+// CheckOutputs returns an error if the outputs of opts can't be written
+func CheckOutputs(opts *config.KanikoOptions) error {
+	if len(opts.Outputs) == 0 {
+		return nil
+	}
+	if len(opts.Platforms) > 0 {
+		return errors.New("--output can't be used with --platform")
+	}
+	for _, o := range opts.Outputs {
+		if o.Stage == "" && len(opts.Targets) > 1 {
+			return fmt.Errorf("output %s must set the stage to export when building several targets", o)
+		}
+	}
+	return nil
+}
+
+// outputStage returns true if the filesystem of the stage named name is exported
+func outputStage(name string, opts *config.KanikoOptions) bool {
+	for _, o := range opts.Outputs {
+		if name != "" && o.Stage == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeOutputs exports the filesystems of the images of the stages of opts.Outputs,
+// found in stageImages by stage name, or of the image of the target if they don't name one
+func writeOutputs(targetImages map[string]v1.Image, stageImages map[string]v1.Image, opts *config.KanikoOptions) error {
+	if len(opts.Outputs) == 0 {
+		return nil
+	}
+	t := timing.Start("Output Time")
+	defer timing.DefaultRun.Stop(t)
+
+	for _, o := range opts.Outputs {
+		var image v1.Image
+		if o.Stage != "" {
+			image = stageImages[o.Stage]
+		} else if len(targetImages) == 1 {
+			for _, targetImage := range targetImages {
+				image = targetImage
+			}
+		}
+		if image == nil {
+			return fmt.Errorf("no image was built for output %s", o)
+		}
+		if err := writeOutput(image, o); err != nil {
+			return errors.Wrapf(err, "writing output %s", o)
+		}
+		logrus.Infof("Filesystem written to %s", o.Dest)
+	}
+	return nil
+}
+
+// writeOutput writes the filesystem of image to the directory or the tarball of o,
+// leaving out the ignored paths and the kaniko directory
+func writeOutput(image v1.Image, o config.Output) error {
+	rc := mutate.Extract(util.ZstdImage(image))
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+
+	var tw *tar.Writer
+	switch o.Type {
+	case config.OutputTypeTar:
+		if err := os.MkdirAll(filepath.Dir(o.Dest), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(o.Dest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tw = tar.NewWriter(f)
+	case config.OutputTypeLocal:
+		if err := os.MkdirAll(o.Dest, 0755); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported output type %s", o.Type)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join("/", filepath.Clean(hdr.Name))
+		if util.CheckIgnoreList(path) || util.HasFilepathPrefix(path, config.KanikoDir, false) {
+			logrus.Debugf("Not writing %s to the output, as it's ignored", path)
+			continue
+		}
+		if tw == nil {
+			if err := util.ExtractFile(o.Dest, hdr, tr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	if tw != nil {
+		return tw.Close()
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func outputTestImage(t *testing.T) v1.Image {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "app/bin", Typeflag: tar.TypeReg, Mode: 0755, Size: 3},
+		{Name: "etc/mtab", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+		{Name: "kaniko/secret", Typeflag: tar.TypeReg, Mode: 0600, Size: 3},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte("abc"))
+		}
+	}
+	tw.Close()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func Test_writeOutput(t *testing.T) {
+	image := outputTestImage(t)
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("tar", func(t *testing.T) {
+		dest := filepath.Join(dir, "out", "fs.tar")
+		testutil.CheckNoError(t, writeOutput(image, config.Output{Type: config.OutputTypeTar, Dest: dest}))
+		f, err := os.Open(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var names []string
+		tr := tar.NewReader(f)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			testutil.CheckNoError(t, err)
+			names = append(names, hdr.Name)
+		}
+		testutil.CheckDeepEqual(t, []string{"app/", "app/bin"}, names)
+	})
+
+	t.Run("local", func(t *testing.T) {
+		dest := filepath.Join(dir, "local")
+		testutil.CheckNoError(t, writeOutput(image, config.Output{Type: config.OutputTypeLocal, Dest: dest}))
+		content, err := ioutil.ReadFile(filepath.Join(dest, "app", "bin"))
+		testutil.CheckErrorAndDeepEqual(t, false, err, "abc", string(content))
+		for _, ignored := range []string{"etc/mtab", "kaniko/secret"} {
+			if _, err := os.Stat(filepath.Join(dest, ignored)); !os.IsNotExist(err) {
+				t.Errorf("expected %s not to be written, got %v", ignored, err)
+			}
+		}
+	})
+}
+
+func TestCheckOutputs(t *testing.T) {
+	local := config.Output{Type: config.OutputTypeLocal, Dest: "/out"}
+	testutil.CheckError(t, false, CheckOutputs(&config.KanikoOptions{Outputs: []config.Output{local}}))
+	testutil.CheckError(t, true, CheckOutputs(&config.KanikoOptions{Outputs: []config.Output{local}, Platforms: []string{"linux/amd64"}}))
+	testutil.CheckError(t, true, CheckOutputs(&config.KanikoOptions{Outputs: []config.Output{local}, Targets: []string{"api", "worker"}}))
+	local.Stage = "api"
+	testutil.CheckError(t, false, CheckOutputs(&config.KanikoOptions{Outputs: []config.Output{local}, Targets: []string{"api", "worker"}}))
+}
+
+func TestDoBuild_OutputStage(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch as first
+COPY foo/bam.txt copied/
+
+FROM scratch as final
+ENV target final
+
+FROM first as export
+COPY --from=first copied/bam.txt exported/bam.txt`
+	ioutil.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755)
+	dest := filepath.Join(testDir, "export.tar")
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		Targets:        []string{"final"},
+		Outputs:        []config.Output{{Type: config.OutputTypeTar, Dest: dest, Stage: "export"}},
+		NoPush:         true,
+	}
+	image, err := DoBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	cf, err := image.ConfigFile()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "target=final", cf.Config.Env[len(cf.Config.Env)-1])
+
+	f, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	files := map[string]bool{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		testutil.CheckNoError(t, err)
+		files[filepath.Clean(hdr.Name)] = true
+	}
+	if !files["copied/bam.txt"] || !files["exported/bam.txt"] {
+		t.Errorf("expected the files of the export stage, got %v", files)
+	}
+}
@@ -0,0 +1,482 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
+	image_util "github.com/GoogleContainerTools/kaniko/pkg/image"
+	"github.com/GoogleContainerTools/kaniko/pkg/provenance"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+)
+
+const (
+	// IsolatedStageCommand is the command of the executor which builds one stage in its own root
+	IsolatedStageCommand = "stage"
+
+	isolatedStateFile  = "state.json"
+	isolatedResultFile = "result.json"
+)
+
+// stageScheduler builds the stages which aren't targets concurrently, each in its own
+// root and as soon as the stages it depends on are built. The targets are built in the
+// root of the executor, one at a time, by doBuild.
+type stageScheduler struct {
+	deps     map[int][]int
+	isolate  []bool
+	slots    chan struct{}
+	done     []chan struct{}
+	errs     []error // written before done is closed
+	cancel   context.CancelFunc
+	routines sync.WaitGroup
+}
+
+// newStageScheduler returns the scheduler of stages, given the stages every stage depends on,
+// or nil if the stages are built one at a time
+func newStageScheduler(stages []config.KanikoStage, deps map[int][]int, opts *config.KanikoOptions) *stageScheduler {
+	if opts.MaxParallelStages <= 1 {
+		return nil
+	}
+	s := &stageScheduler{
+		deps:    deps,
+		isolate: make([]bool, len(stages)),
+		slots:   make(chan struct{}, opts.MaxParallelStages),
+		done:    make([]chan struct{}, len(stages)),
+		errs:    make([]error, len(stages)),
+	}
+	isolated := 0
+	for index, stage := range stages {
+		s.done[index] = make(chan struct{})
+		// Targets and the stages exported with --output need the root of the executor
+		if !stage.Final && !outputStage(stage.Name, opts) {
+			s.isolate[index] = true
+			isolated++
+		}
+	}
+	if isolated == 0 {
+		return nil
+	}
+	return s
+}
+
+// start builds every isolated stage with build in the background
+func (s *stageScheduler) start(ctx context.Context, build func(ctx context.Context, index int) error) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	for index, isolate := range s.isolate {
+		if !isolate {
+			continue
+		}
+		s.routines.Add(1)
+		go func(index int) {
+			defer s.routines.Done()
+			s.errs[index] = s.run(ctx, index, build)
+			close(s.done[index])
+		}(index)
+	}
+}
+
+func (s *stageScheduler) run(ctx context.Context, index int, build func(ctx context.Context, index int) error) error {
+	for _, dep := range s.deps[index] {
+		if err := s.wait(ctx, dep); err != nil {
+			return errors.Wrapf(err, "stage %d depends on stage %d", index, dep)
+		}
+	}
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+	return build(ctx, index)
+}
+
+// stop cancels the stages which are still being built and waits for them
+func (s *stageScheduler) stop() {
+	if s == nil {
+		return
+	}
+	s.cancel()
+	s.routines.Wait()
+}
+
+// isolated returns true if the stage at index is built in its own root
+func (s *stageScheduler) isolated(index int) bool {
+	return s != nil && s.isolate[index]
+}
+
+// wait waits until the stage at index is built
+func (s *stageScheduler) wait(ctx context.Context, index int) error {
+	select {
+	case <-s.done[index]:
+		return s.errs[index]
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquire waits until fewer than --max-parallel-stages stages are being built
+func (s *stageScheduler) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *stageScheduler) release() {
+	<-s.slots
+}
+
+// finish marks the stage at index, built in the root of the executor, as built
+func (s *stageScheduler) finish(index int) {
+	if s == nil {
+		return
+	}
+	s.release()
+	close(s.done[index])
+}
+
+// isolatedStageState is what the executor building a stage in its own root is handed
+type isolatedStageState struct {
+	Options          *config.KanikoOptions `json:"options"`
+	Index            int                   `json:"index"`
+	Root             string                `json:"root"`
+	Paths            []string              `json:"paths"`
+	CrossStageDeps   map[int][]string      `json:"crossStageDeps"`
+	DigestToCacheKey map[string]string     `json:"digestToCacheKey"`
+	StageIdxToDigest map[string]string     `json:"stageIdxToDigest"`
+	BaseLayers       []LayerReport         `json:"baseLayers,omitempty"`
+}
+
+// isolatedStageResult is what the executor building a stage in its own root hands back
+type isolatedStageResult struct {
+	Error           string                       `json:"error,omitempty"`
+	Digest          string                       `json:"digest"`
+	CacheKey        string                       `json:"cacheKey"`
+	BaseImageDigest string                       `json:"baseImageDigest"`
+	Layers          []LayerReport                `json:"layers,omitempty"`
+	Materials       []provenance.Material        `json:"materials,omitempty"`
+	ResolvedImages  map[string]map[string]string `json:"resolvedImages,omitempty"`
+}
+
+// isolatedStageDir returns the directory of the root and the state of the stage at index
+func isolatedStageDir(index int) string {
+	return filepath.Join(config.KanikoDir, "parallel", strconv.Itoa(index))
+}
+
+// buildIsolatedStage builds the stage at index in its own root with another executor,
+// and adds its result to results
+func buildIsolatedStage(ctx context.Context, opts *config.KanikoOptions, stage config.KanikoStage, index int, crossStageDeps map[int][]string, results *stageResults) error {
+	paths, err := isolatedPaths(opts)
+	if err != nil {
+		return err
+	}
+	dir := isolatedStageDir(index)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	// The mounts of the executor live in its own mount namespace, none are left in dir
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	digestToCacheKey, stageIdxToDigest := results.cacheKeys()
+	state := isolatedStageState{
+		Options:          opts,
+		Index:            index,
+		Root:             root,
+		Paths:            paths,
+		CrossStageDeps:   crossStageDeps,
+		DigestToCacheKey: digestToCacheKey,
+		StageIdxToDigest: stageIdxToDigest,
+		BaseLayers:       results.baseLayers(stage),
+	}
+	if err := writeJSON(filepath.Join(dir, isolatedStateFile), state); err != nil {
+		return err
+	}
+
+	if err := runIsolatedStage(ctx, dir, index); err != nil {
+		var result isolatedStageResult
+		if readJSON(filepath.Join(dir, isolatedResultFile), &result) == nil && result.Error != "" {
+			return errors.New(result.Error)
+		}
+		return err
+	}
+	var result isolatedStageResult
+	if err := readJSON(filepath.Join(dir, isolatedResultFile), &result); err != nil {
+		return errors.Wrapf(err, "reading the result of stage %d", index)
+	}
+	for _, m := range result.Materials {
+		for algorithm, digest := range m.Digest {
+			provenance.AddMaterial(m.URI, algorithm+":"+digest)
+		}
+	}
+	for image, digests := range result.ResolvedImages {
+		for platform, digest := range digests {
+			image_util.DefaultResolvedImages.Add(image, platform, digest)
+		}
+	}
+	results.add(index, stage, result.Digest, result.CacheKey, result.BaseImageDigest, result.Layers)
+	return nil
+}
+
+// runIsolatedStage runs the executor building the stage at index with the state in dir, in
+// its own mount namespace. Its logs are forwarded with the index of the stage, and its events
+// to the events of this executor.
+func runIsolatedStage(ctx context.Context, dir string, index int) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, executable, IsolatedStageCommand, "--verbosity", logrus.GetLevel().String(), dir)
+	cmd.SysProcAttr = isolatedSysProcAttr()
+	cmd.Stdout = os.Stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	eventsReader, eventsWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer eventsReader.Close()
+	cmd.ExtraFiles = []*os.File{eventsWriter}
+
+	logrus.Infof("Building stage %d in its own root %s", index, filepath.Join(dir, "root"))
+	err = cmd.Start()
+	eventsWriter.Close()
+	if err != nil {
+		return errors.Wrapf(err, "starting the executor of stage %d, --max-parallel-stages needs the CAP_SYS_ADMIN capability", index)
+	}
+	var forwarding sync.WaitGroup
+	forwarding.Add(2)
+	go func() {
+		defer forwarding.Done()
+		forwardLogs(stderr, index)
+	}()
+	go func() {
+		defer forwarding.Done()
+		if err := events.DefaultStream.Forward(eventsReader); err != nil {
+			logrus.Warnf("Unable to forward the events of stage %d: %s", index, err)
+		}
+	}()
+	forwarding.Wait()
+	return errors.Wrapf(cmd.Wait(), "building stage %d", index)
+}
+
+// forwardLogs logs the JSON log entries read from r with the index of the stage they come
+// from, and writes lines which aren't log entries, like the output of RUN, to stderr
+func forwardLogs(r io.Reader, index int) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var fields logrus.Fields
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			fmt.Fprintln(os.Stderr, scanner.Text())
+			continue
+		}
+		level, err := logrus.ParseLevel(fmt.Sprint(fields[logrus.FieldKeyLevel]))
+		msg, ok := fields[logrus.FieldKeyMsg]
+		if err != nil || !ok {
+			fmt.Fprintln(os.Stderr, scanner.Text())
+			continue
+		}
+		delete(fields, logrus.FieldKeyLevel)
+		delete(fields, logrus.FieldKeyMsg)
+		delete(fields, logrus.FieldKeyTime)
+		fields["stage"] = index
+		logrus.WithFields(fields).Log(level, msg)
+	}
+}
+
+// isolatedPaths returns the paths of the root of this executor which an executor building
+// a stage in its own root needs, mounted at the same paths in its root
+func isolatedPaths(opts *config.KanikoOptions) ([]string, error) {
+	candidates := []string{
+		config.KanikoDir, "/proc", "/dev", "/sys", "/etc/resolv.conf", "/etc/hosts",
+		opts.SrcContext, filepath.Dir(opts.DockerfilePath),
+		opts.CacheDir, opts.CacheMountDir, opts.BaseImageLayout,
+		opts.Lockfile, opts.BaseImagePolicy, opts.VerifyBaseImageKey,
+	}
+	for _, s := range opts.Secrets {
+		candidates = append(candidates, s.Src)
+	}
+	for _, certificate := range opts.RegistriesCertificates {
+		candidates = append(candidates, certificate)
+	}
+	for _, env := range []string{"DOCKER_CONFIG", "SSL_CERT_DIR", "SSL_CERT_FILE"} {
+		candidates = append(candidates, os.Getenv(env))
+	}
+	if path := FailureSnapshotPath(opts.OnFailureSnapshot); path != "" {
+		candidates = append(candidates, filepath.Dir(path))
+	}
+
+	var paths []string
+	for _, p := range candidates {
+		if p == "" || !filepath.IsAbs(p) {
+			continue
+		}
+		p = filepath.Clean(p)
+		if p == config.RootDir {
+			return nil, fmt.Errorf("stages can't be built in their own root with %s in the root", p)
+		}
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	// Mount every path once, those under another path come with it
+	sort.Strings(paths)
+	var mounts []string
+	for _, p := range paths {
+		if len(mounts) > 0 {
+			last := mounts[len(mounts)-1]
+			if p == last || strings.HasPrefix(p, last+"/") {
+				continue
+			}
+		}
+		mounts = append(mounts, p)
+	}
+	return mounts, nil
+}
+
+// DoIsolatedStage builds one stage of a build whose stages are built concurrently, in the
+// root and with the state of the directory dir, and writes its result to dir
+func DoIsolatedStage(ctx context.Context, dir string) error {
+	var state isolatedStageState
+	if err := readJSON(filepath.Join(dir, isolatedStateFile), &state); err != nil {
+		return errors.Wrap(err, "reading the state of the stage")
+	}
+	result, err := doIsolatedStage(ctx, &state)
+	if err != nil {
+		result = &isolatedStageResult{Error: err.Error()}
+	}
+	if err := writeJSON(filepath.Join(dir, isolatedResultFile), result); err != nil {
+		return errors.Wrap(err, "writing the result of the stage")
+	}
+	return err
+}
+
+func doIsolatedStage(ctx context.Context, state *isolatedStageState) (*isolatedStageResult, error) {
+	// The events are written to the pipe the executor of the build passes
+	events.DefaultStream.SetOutput(os.NewFile(3, "events"))
+	if err := isolateRoot(state.Root, state.Paths); err != nil {
+		return nil, err
+	}
+	opts := state.Options
+	util.AddOptionsToDefaultIgnoreList(opts)
+
+	stages, metaArgs, err := dockerfile.ParseStages(opts)
+	if err != nil {
+		return nil, err
+	}
+	kanikoStages, err := dockerfile.MakeKanikoStages(opts, stages, metaArgs)
+	if err != nil {
+		return nil, err
+	}
+	stageNameToIdx := ResolveCrossStageInstructions(kanikoStages)
+	fileContext, err := util.NewFileContextFromDockerfile(opts.DockerfilePath, opts.SrcContext)
+	if err != nil {
+		return nil, err
+	}
+	index := state.Index
+	if index < 0 || index >= len(kanikoStages) {
+		return nil, fmt.Errorf("the build has no stage %d", index)
+	}
+	stage := kanikoStages[index]
+
+	e := stageEvent(events.StageStarted, stage)
+	events.Emit(e)
+	start := time.Now()
+	sb, err := newStageBuilder(ctx, opts, stage, state.CrossStageDeps, state.DigestToCacheKey, state.StageIdxToDigest, stageNameToIdx, fileContext)
+	if err != nil {
+		return nil, err
+	}
+	err = sb.build(ctx)
+	e.Type = events.StageFinished
+	e.DurationMs = events.Since(start)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	events.Emit(e)
+	if err != nil {
+		return nil, errors.Wrap(err, "error building stage")
+	}
+
+	reviewConfig(stage, &sb.cf.Config)
+	layers, err := stageLayerReports(sb, state.BaseLayers)
+	if err != nil {
+		return nil, err
+	}
+	sourceImage, err := stageSourceImage(sb, opts)
+	if err != nil {
+		return nil, err
+	}
+	d, err := sourceImage.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if err := saveStage(index, stage, sourceImage, state.CrossStageDeps[index]); err != nil {
+		return nil, err
+	}
+	return &isolatedStageResult{
+		Digest:          d.String(),
+		CacheKey:        sb.finalCacheKey,
+		BaseImageDigest: sb.baseImageDigest,
+		Layers:          layers,
+		Materials:       provenance.DefaultMaterials.List(),
+		ResolvedImages:  image_util.DefaultResolvedImages.Lockfile().Images,
+	}, nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+func readJSON(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// isolatedSysProcAttr starts the executor of a stage in its own mount namespace
+func isolatedSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS}
+}
+
+// isolateRoot makes root the root directory of this executor, with paths of the root
+// directory it had mounted at the same paths
+func isolateRoot(root string, paths []string) error {
+	// Nothing mounted from here on may propagate to the mount namespace of the build
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return errors.Wrap(err, "making the mounts private")
+	}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, p)
+		if fi.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		} else {
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			f.Close()
+		}
+		if err := syscall.Mount(p, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return errors.Wrapf(err, "bind mounting %s in the root of the stage", p)
+		}
+	}
+	if err := syscall.Chroot(root); err != nil {
+		return errors.Wrapf(err, "changing the root to %s", root)
+	}
+	return os.Chdir("/")
+}
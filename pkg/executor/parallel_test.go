@@ -0,0 +1,169 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func Test_newStageScheduler(t *testing.T) {
+	stages := []config.KanikoStage{{}, {Final: true}}
+	opts := &config.KanikoOptions{MaxParallelStages: 1}
+	if newStageScheduler(stages, nil, opts) != nil {
+		t.Errorf("expected no scheduler with --max-parallel-stages=1")
+	}
+	opts.MaxParallelStages = 2
+	s := newStageScheduler(stages, nil, opts)
+	testutil.CheckDeepEqual(t, []bool{true, false}, s.isolate)
+	if newStageScheduler([]config.KanikoStage{{Final: true}}, nil, opts) != nil {
+		t.Errorf("expected no scheduler without stages to build in their own root")
+	}
+}
+
+func TestStageScheduler_Parallel(t *testing.T) {
+	// two builders and a final stage copying from both
+	stages := []config.KanikoStage{{}, {}, {Final: true}}
+	deps := map[int][]int{2: {0, 1}}
+	s := newStageScheduler(stages, deps, &config.KanikoOptions{MaxParallelStages: 3})
+
+	started := make(chan int, 2)
+	both := make(chan struct{})
+	s.start(context.Background(), func(ctx context.Context, index int) error {
+		started <- index
+		select {
+		case <-both:
+			return nil
+		case <-time.After(10 * time.Second):
+			return errors.New("the builders weren't built at the same time")
+		}
+	})
+	defer s.stop()
+	<-started
+	<-started
+	close(both)
+
+	testutil.CheckNoError(t, s.wait(context.Background(), 0))
+	testutil.CheckNoError(t, s.wait(context.Background(), 1))
+	testutil.CheckNoError(t, s.acquire(context.Background()))
+	s.finish(2)
+	testutil.CheckNoError(t, s.wait(context.Background(), 2))
+}
+
+func TestStageScheduler_Dependencies(t *testing.T) {
+	// 1 is based on 0, 2 copies from 1, 3 is independent
+	stages := []config.KanikoStage{{}, {}, {}, {}}
+	deps := map[int][]int{1: {0}, 2: {1}}
+	s := newStageScheduler(stages, deps, &config.KanikoOptions{MaxParallelStages: 2})
+
+	var mu sync.Mutex
+	built := map[int]bool{}
+	running, maxRunning := 0, 0
+	s.start(context.Background(), func(ctx context.Context, index int) error {
+		mu.Lock()
+		for _, dep := range deps[index] {
+			if !built[dep] {
+				t.Errorf("stage %d started before stage %d was built", index, dep)
+			}
+		}
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		built[index] = true
+		mu.Unlock()
+		return nil
+	})
+	defer s.stop()
+	for index := range stages {
+		testutil.CheckNoError(t, s.wait(context.Background(), index))
+	}
+	if maxRunning > 2 {
+		t.Errorf("%d stages were built at the same time with --max-parallel-stages=2", maxRunning)
+	}
+}
+
+func TestStageScheduler_Failure(t *testing.T) {
+	stages := []config.KanikoStage{{}, {}, {Final: true}}
+	deps := map[int][]int{1: {0}, 2: {1}}
+	s := newStageScheduler(stages, deps, &config.KanikoOptions{MaxParallelStages: 2})
+	s.start(context.Background(), func(ctx context.Context, index int) error {
+		if index == 0 {
+			return errors.New("RUN failed")
+		}
+		t.Errorf("stage %d was built after the stage it depends on failed", index)
+		return nil
+	})
+	defer s.stop()
+	testutil.CheckError(t, true, s.wait(context.Background(), 0))
+	err := s.wait(context.Background(), 1)
+	testutil.CheckErrorAndDeepEqual(t, true, err, "stage 1 depends on stage 0: RUN failed", err.Error())
+}
+
+func Test_isolatedPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	original := config.KanikoDir
+	defer func() { config.KanikoDir = original }()
+	config.KanikoDir = filepath.Join(dir, "kaniko")
+	os.MkdirAll(filepath.Join(dir, "kaniko", "buildcontext"), 0755)
+	os.MkdirAll(filepath.Join(dir, "secrets"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "secrets", "token"), []byte("s3cr3t"), 0600)
+
+	opts := &config.KanikoOptions{
+		SrcContext:     filepath.Join(dir, "kaniko", "buildcontext"),
+		DockerfilePath: filepath.Join(dir, "kaniko", "buildcontext", "Dockerfile"),
+		CacheMountDir:  filepath.Join(dir, "missing"),
+		Secrets:        map[string]config.Secret{"token": {Src: filepath.Join(dir, "secrets", "token")}},
+	}
+	paths, err := isolatedPaths(opts)
+	testutil.CheckNoError(t, err)
+	for _, p := range paths {
+		if p == opts.SrcContext || p == opts.CacheMountDir {
+			t.Errorf("unexpected path %s", p)
+		}
+	}
+	for _, expected := range []string{config.KanikoDir, filepath.Join(dir, "secrets", "token")} {
+		found := false
+		for _, p := range paths {
+			found = found || p == expected
+		}
+		if !found {
+			t.Errorf("expected %s to be mounted, got %v", expected, paths)
+		}
+	}
+
+	opts.SrcContext = "/"
+	_, err = isolatedPaths(opts)
+	testutil.CheckError(t, true, err)
+}
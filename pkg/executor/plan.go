@@ -0,0 +1,196 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/pkg/errors"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	image_util "github.com/GoogleContainerTools/kaniko/pkg/image"
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+)
+
+// PlanAction describes what a build would do with a command
+type PlanAction string
+
+const (
+	// PlanActionCacheHit means the layer of the command would be taken from the cache
+	PlanActionCacheHit PlanAction = "cache-hit"
+	// PlanActionExecute means the command would be executed
+	PlanActionExecute PlanAction = "execute"
+	// PlanActionSkip means the command would not be executed because its stage is not built
+	PlanActionSkip PlanAction = "skip"
+)
+
+// Plan is the result of a dry run: what building the Dockerfile would do
+type Plan struct {
+	Stages []StagePlan `json:"stages"`
+}
+
+// StagePlan describes how a stage of the Dockerfile would be built
+type StagePlan struct {
+	// Index is the position of the stage in the Dockerfile
+	Index           int           `json:"index"`
+	Name            string        `json:"name,omitempty"`
+	BaseImage       string        `json:"baseImage"`
+	BaseImageDigest string        `json:"baseImageDigest,omitempty"`
+	Final           bool          `json:"final,omitempty"`
+	Skipped         bool          `json:"skipped,omitempty"`
+	Commands        []CommandPlan `json:"commands"`
+}
+
+// CommandPlan describes what would happen to a command of a stage
+type CommandPlan struct {
+	Command  string     `json:"command"`
+	CacheKey string     `json:"cacheKey,omitempty"`
+	Action   PlanAction `json:"action"`
+}
+
+// planCommand records the cache key and action of command, if the stage is being planned
+func (s *stageBuilder) planCommand(command fmt.Stringer, cacheKey string, action PlanAction) {
+	if s.plan == nil {
+		return
+	}
+	s.plan.Commands = append(s.plan.Commands, CommandPlan{
+		Command:  command.String(),
+		CacheKey: cacheKey,
+		Action:   action,
+	})
+}
+
+// DoDryRun computes the cache keys of all commands in the Dockerfile and looks them up
+// in the layer cache. Nothing is extracted, executed or pushed.
+func DoDryRun(ctx context.Context, opts *config.KanikoOptions) (*Plan, error) {
+	t := timing.Start("Total Dry Run Time")
+	defer timing.DefaultRun.Stop(t)
+	digestToCacheKey := make(map[string]string)
+	stageIdxToDigest := make(map[string]string)
+
+	stages, metaArgs, err := dockerfile.ParseStages(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	kanikoStages, err := dockerfile.MakeKanikoStages(opts, stages, metaArgs)
+	if err != nil {
+		return nil, err
+	}
+	stageNameToIdx := ResolveCrossStageInstructions(kanikoStages)
+	if err := checkBaseImagePolicy(kanikoStages, opts, stageNameToIdx); err != nil {
+		return nil, err
+	}
+
+	fileContext, err := util.NewFileContextFromDockerfile(opts.DockerfilePath, opts.SrcContext)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	// The images the stages would produce, with the config but without the layers of the stage
+	var images []v1.Image
+	next := 0
+	for index, stage := range stages {
+		if next >= len(kanikoStages) || !sameStage(kanikoStages[next].Stage, stage) {
+			plan.Stages = append(plan.Stages, skippedStagePlan(index, stage))
+			continue
+		}
+		kanikoStage := kanikoStages[next]
+		next++
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("planning stage %d", index))
+		}
+
+		stagePlan := &StagePlan{
+			Index:     index,
+			Name:      stage.Name,
+			BaseImage: kanikoStage.BaseName,
+			Final:     kanikoStage.Final,
+		}
+		var sourceImage v1.Image
+		if kanikoStage.BaseImageStoredLocally {
+			sourceImage = images[kanikoStage.BaseImageIndex]
+		} else {
+			sourceImage, err = image_util.RetrieveSourceImage(ctx, kanikoStage, opts)
+			if err != nil {
+				return nil, err
+			}
+			digest, err := sourceImage.Digest()
+			if err != nil {
+				return nil, err
+			}
+			stagePlan.BaseImageDigest = digest.String()
+		}
+
+		sb, err := newStageBuilderFromImage(sourceImage, opts, kanikoStage, nil, digestToCacheKey, stageIdxToDigest, stageNameToIdx, fileContext)
+		if err != nil {
+			return nil, err
+		}
+		sb.plan = stagePlan
+		if err := sb.optimize(ctx, *sb.initialCompositeKey(), sb.cf.Config); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("planning stage %d", index))
+		}
+		plan.Stages = append(plan.Stages, *stagePlan)
+
+		// Later stages refer to this one through the digest of its image, map it
+		// to the stage's cache key the same way DoBuild does.
+		image, err := mutate.Config(sb.image, sb.cf.Config)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+		d, err := image.Digest()
+		if err != nil {
+			return nil, err
+		}
+		stageIdxToDigest[fmt.Sprintf("%d", kanikoStage.Index)] = d.String()
+		digestToCacheKey[d.String()] = sb.finalCacheKey
+	}
+	return plan, nil
+}
+
+// sameStage returns true if the kaniko stage was made from the parsed stage
+func sameStage(kanikoStage instructions.Stage, stage instructions.Stage) bool {
+	return kanikoStage.Name == stage.Name && kanikoStage.SourceCode == stage.SourceCode
+}
+
+func skippedStagePlan(index int, stage instructions.Stage) StagePlan {
+	stagePlan := StagePlan{
+		Index:     index,
+		Name:      stage.Name,
+		BaseImage: stage.BaseName,
+		Skipped:   true,
+	}
+	for _, c := range stage.Commands {
+		command := c.Name()
+		if s, ok := c.(fmt.Stringer); ok {
+			command = s.String()
+		}
+		stagePlan.Commands = append(stagePlan.Commands, CommandPlan{
+			Command: command,
+			Action:  PlanActionSkip,
+		})
+	}
+	return stagePlan
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/commands"
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func Test_stageBuilder_optimize_plan(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     *config.KanikoOptions
+		retrieve bool
+		expected PlanAction
+	}{
+		{
+			name:     "layer present in cache",
+			opts:     &config.KanikoOptions{Cache: true},
+			retrieve: true,
+			expected: PlanActionCacheHit,
+		},
+		{
+			name:     "layer not present in cache",
+			opts:     &config.KanikoOptions{Cache: true},
+			expected: PlanActionExecute,
+		},
+		{
+			name:     "cache disabled",
+			opts:     &config.KanikoOptions{Cache: false},
+			retrieve: true,
+			expected: PlanActionExecute,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cf := &v1.ConfigFile{}
+			sb := &stageBuilder{opts: tc.opts, cf: cf, layerCache: &fakeLayerCache{retrieve: tc.retrieve},
+				args: dockerfile.NewBuildArgs([]string{}), plan: &StagePlan{}}
+			sb.cmds = []commands.DockerCommand{MockDockerCommand{
+				command:      "RUN foo",
+				cacheCommand: MockCachedDockerCommand{},
+			}}
+			if err := sb.optimize(context.TODO(), CompositeCache{}, cf.Config); err != nil {
+				t.Fatalf("Expected error to be nil but was %v", err)
+			}
+			testutil.CheckDeepEqual(t, 1, len(sb.plan.Commands))
+			testutil.CheckDeepEqual(t, tc.expected, sb.plan.Commands[0].Action)
+			testutil.CheckDeepEqual(t, sb.finalCacheKey, sb.plan.Commands[0].CacheKey)
+		})
+	}
+}
+
+func TestDoDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo"), []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dockerfilePath := filepath.Join(dir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfilePath, []byte(`
+FROM scratch AS builder
+COPY foo /foo
+FROM scratch AS unused
+COPY foo /bar
+FROM builder
+ENV A=b
+COPY --from=builder /foo /baz
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := DoDryRun(context.TODO(), &config.KanikoOptions{
+		DockerfilePath:   dockerfilePath,
+		SrcContext:       dir,
+		SnapshotMode:     constants.SnapshotModeFull,
+		SkipUnusedStages: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.CheckDeepEqual(t, 3, len(plan.Stages))
+	builder, unused, final := plan.Stages[0], plan.Stages[1], plan.Stages[2]
+	testutil.CheckDeepEqual(t, "builder", builder.Name)
+	testutil.CheckDeepEqual(t, false, builder.Skipped)
+	testutil.CheckDeepEqual(t, 1, len(builder.Commands))
+	testutil.CheckDeepEqual(t, PlanActionExecute, builder.Commands[0].Action)
+
+	testutil.CheckDeepEqual(t, true, unused.Skipped)
+	testutil.CheckDeepEqual(t, []CommandPlan{{Command: "COPY foo /bar", Action: PlanActionSkip}}, unused.Commands)
+
+	testutil.CheckDeepEqual(t, 2, final.Index)
+	testutil.CheckDeepEqual(t, true, final.Final)
+	testutil.CheckDeepEqual(t, 2, len(final.Commands))
+	if final.Commands[0].CacheKey == "" || final.Commands[0].CacheKey == final.Commands[1].CacheKey {
+		t.Errorf("expected distinct cache keys for every command, got %v", final.Commands)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"strconv"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/policy"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+)
+
+// checkBaseImagePolicy checks the images the stages use against --base-image-policy
+// before any of them is retrieved, and returns an error listing all the violations
+func checkBaseImagePolicy(stages []config.KanikoStage, opts *config.KanikoOptions, stageNameToIdx map[string]string) error {
+	if opts.BaseImagePolicy == "" {
+		return nil
+	}
+	p, err := policy.ReadBaseImagePolicy(opts.BaseImagePolicy)
+	if err != nil {
+		return err
+	}
+	var violations policy.Violations
+	for _, s := range stages {
+		if !s.BaseImageStoredLocally && s.BaseName != constants.NoBaseImage {
+			violations = append(violations, p.Check(s.BaseName, s.SourceCode)...)
+		}
+		v, err := commandsPolicyViolations(p, s.Commands, stageNameToIdx, "")
+		if err != nil {
+			return err
+		}
+		violations = append(violations, v...)
+	}
+	return violations.Err()
+}
+
+// checkOnBuildPolicy checks the images the ONBUILD triggers cmds of a base image
+// use against --base-image-policy
+func checkOnBuildPolicy(cmds []instructions.Command, opts *config.KanikoOptions, stageNameToIdx map[string]string) error {
+	if opts.BaseImagePolicy == "" {
+		return nil
+	}
+	p, err := policy.ReadBaseImagePolicy(opts.BaseImagePolicy)
+	if err != nil {
+		return err
+	}
+	violations, err := commandsPolicyViolations(p, cmds, stageNameToIdx, "ONBUILD ")
+	if err != nil {
+		return err
+	}
+	return violations.Err()
+}
+
+// commandsPolicyViolations returns the violations of p by the images cmds copy or mount files from
+func commandsPolicyViolations(p *policy.BaseImagePolicy, cmds []instructions.Command, stageNameToIdx map[string]string, prefix string) (policy.Violations, error) {
+	var violations policy.Violations
+	for _, cmd := range cmds {
+		switch c := cmd.(type) {
+		case *instructions.CopyCommand:
+			if c.From == "" || isStageReference(c.From, stageNameToIdx) {
+				continue
+			}
+			violations = append(violations, p.Check(c.From, prefix+c.String())...)
+		case *instructions.RunCommand:
+			mounts, err := dockerfile.RunMounts(c)
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range mounts {
+				if m.Type != dockerfile.MountTypeBind || m.From == "" {
+					continue
+				}
+				from := m.ResolveFrom(stageNameToIdx)
+				if isStageReference(from, stageNameToIdx) {
+					continue
+				}
+				violations = append(violations, p.Check(from, prefix+c.String())...)
+			}
+		}
+	}
+	return violations, nil
+}
+
+// isStageReference returns true if from names a stage rather than an image
+func isStageReference(from string, stageNameToIdx map[string]string) bool {
+	if _, err := strconv.Atoi(from); err == nil {
+		return true
+	}
+	_, ok := stageNameToIdx[from]
+	return ok
+}
+
+// checkImagePolicy evaluates --image-policy against image, the image of the stage
+// stageName, records the report for --image-policy-report and returns an error if a
+// rule failed
+func checkImagePolicy(image v1.Image, stageName string, opts *config.KanikoOptions) error {
+	if opts.ImagePolicy == "" {
+		return nil
+	}
+	p, err := policy.ReadImagePolicy(opts.ImagePolicy)
+	if err != nil {
+		return err
+	}
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	m, err := image.Manifest()
+	if err != nil {
+		return err
+	}
+	size := m.Config.Size
+	for _, l := range m.Layers {
+		size += l.Size
+	}
+	// The filesystem of the stage isn't unpacked if none of its commands needed it
+	fs := mutate.Extract(util.ZstdImage(image))
+	defer fs.Close()
+	report, err := p.Evaluate(cf, size, fs)
+	if err != nil {
+		return errors.Wrap(err, "evaluating image policy")
+	}
+	report.Stage = stageName
+	report.Platform = opts.CustomPlatform
+	for _, r := range report.Failed(policy.ActionWarn) {
+		logrus.Warnf("Image policy rule %s", r)
+	}
+	policy.DefaultReports.Add(report)
+	if opts.ImagePolicyReport != "" {
+		if err := policy.WriteReports(opts.ImagePolicyReport, policy.DefaultReports.List()); err != nil {
+			return errors.Wrap(err, "writing image policy report")
+		}
+	}
+	return report.Err()
+}
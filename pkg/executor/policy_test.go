@@ -0,0 +1,152 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/dockerfile"
+	"github.com/GoogleContainerTools/kaniko/pkg/policy"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func writePolicyTestFiles(t *testing.T, dockerFile string) (string, *config.KanikoOptions) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerFile), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "policy.json"), []byte(`{"allow": ["registry.internal"]}`), 0644)
+	return dir, &config.KanikoOptions{
+		DockerfilePath:  filepath.Join(dir, "Dockerfile"),
+		BaseImagePolicy: filepath.Join(dir, "policy.json"),
+		BuildArgs:       []string{"BASE=ubuntu:20.04"},
+	}
+}
+
+func Test_checkBaseImagePolicy(t *testing.T) {
+	dir, opts := writePolicyTestFiles(t, `
+ARG BASE
+FROM registry.internal/base:v1 AS build
+COPY --from=gcr.io/tools/protoc /bin/protoc /bin/protoc
+RUN --mount=type=bind,from=registry.internal/tools,target=/tools /tools/gen
+
+FROM ${BASE}
+COPY --from=build /out /out
+RUN --mount=type=bind,from=build,target=/build ls /build`)
+	defer os.RemoveAll(dir)
+
+	stages, metaArgs, err := dockerfile.ParseStages(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kanikoStages, err := dockerfile.MakeKanikoStages(opts, stages, metaArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageNameToIdx := ResolveCrossStageInstructions(kanikoStages)
+
+	err = checkBaseImagePolicy(kanikoStages, opts, stageNameToIdx)
+	violations, ok := err.(policy.Violations)
+	if !ok {
+		t.Fatalf("expected policy violations, got %v", err)
+	}
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.Instruction+" "+v.Image)
+	}
+	testutil.CheckDeepEqual(t, []string{
+		"COPY --from=gcr.io/tools/protoc /bin/protoc /bin/protoc gcr.io/tools/protoc",
+		"FROM ${BASE} ubuntu:20.04",
+	}, got)
+
+	opts.BaseImagePolicy = ""
+	testutil.CheckNoError(t, checkBaseImagePolicy(kanikoStages, opts, stageNameToIdx))
+}
+
+func Test_resolveOnBuild_BaseImagePolicy(t *testing.T) {
+	dir, opts := writePolicyTestFiles(t, "FROM scratch")
+	defer os.RemoveAll(dir)
+
+	cfg := &v1.Config{OnBuild: []string{"COPY --from=gcr.io/tools/protoc /bin/protoc /bin/protoc"}}
+	err := resolveOnBuild(&config.KanikoStage{}, cfg, opts, map[string]string{})
+	testutil.CheckError(t, true, err)
+
+	cfg = &v1.Config{OnBuild: []string{"COPY --from=registry.internal/tools /bin/protoc /bin/protoc"}}
+	stage := &config.KanikoStage{}
+	err = resolveOnBuild(stage, cfg, opts, map[string]string{})
+	testutil.CheckErrorAndDeepEqual(t, false, err, 1, len(stage.Commands))
+}
+
+func TestDoBuild_ImagePolicy(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/bam.txt copied/`
+	ioutil.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755)
+	policyPath := filepath.Join(testDir, "policy.json")
+	reportPath := filepath.Join(testDir, "report.json")
+	opts := &config.KanikoOptions{
+		DockerfilePath:    filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:        filepath.Join(testDir, "workspace"),
+		SnapshotMode:      constants.SnapshotModeFull,
+		ImagePolicy:       policyPath,
+		ImagePolicyReport: reportPath,
+		NoPush:            true,
+	}
+	original := policy.DefaultReports
+	defer func() { policy.DefaultReports = original }()
+
+	policy.DefaultReports = &policy.Reports{}
+	ioutil.WriteFile(policyPath, []byte(`{"rules": [{"type": "non-root-user"}]}`), 0644)
+	_, err := DoBuild(context.TODO(), opts)
+	testutil.CheckError(t, true, err)
+
+	policy.DefaultReports = &policy.Reports{}
+	ioutil.WriteFile(policyPath, []byte(`{"rules": [{"type": "non-root-user", "action": "warn"}]}`), 0644)
+	_, err = DoBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	b, err := ioutil.ReadFile(reportPath)
+	testutil.CheckNoError(t, err)
+	var reports []policy.Report
+	testutil.CheckNoError(t, json.Unmarshal(b, &reports))
+	testutil.CheckDeepEqual(t, 1, len(reports))
+	testutil.CheckDeepEqual(t, []policy.Result{
+		{Rule: policy.NonRootUser, Action: policy.ActionWarn, Message: `USER is "", the image runs as root`},
+	}, reports[0].Results)
+
+	// The files are those of the image, whether or not the stage was unpacked
+	policy.DefaultReports = &policy.Reports{}
+	ioutil.WriteFile(policyPath, []byte(`{"rules": [{"type": "forbidden-paths", "paths": ["/copied"], "action": "warn"}]}`), 0644)
+	_, err = DoBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	b, err = ioutil.ReadFile(reportPath)
+	testutil.CheckNoError(t, err)
+	testutil.CheckNoError(t, json.Unmarshal(b, &reports))
+	testutil.CheckDeepEqual(t, []policy.Result{
+		{Rule: policy.ForbiddenPaths, Action: policy.ActionWarn, Message: "files are under /copied", Files: []string{"/copied/bam.txt"}},
+	}, reports[0].Results)
+}
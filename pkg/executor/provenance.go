@@ -0,0 +1,135 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
+	"github.com/GoogleContainerTools/kaniko/pkg/provenance"
+	"github.com/GoogleContainerTools/kaniko/pkg/signing"
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+)
+
+// DoProvenance generates the in-toto provenance of the built image, writes it to
+// opts.ProvenanceFile and pushes it next to the image if opts.PushProvenance is set
+func DoProvenance(ctx context.Context, image partial.Describable, opts *config.KanikoOptions, b provenance.Build) error {
+	if opts.ProvenanceFile == "" && !opts.PushProvenance {
+		return nil
+	}
+	t := timing.Start("Provenance Time")
+	defer timing.DefaultRun.Stop(t)
+
+	digest, err := image.Digest()
+	if err != nil {
+		return errors.Wrap(err, "getting image digest")
+	}
+	repos, err := destinationRepositories(opts)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(repos))
+	for _, r := range repos {
+		names = append(names, r.Name())
+	}
+	if len(names) == 0 {
+		// the image is only written to a tarball or an OCI layout
+		names = []string{"image"}
+	}
+
+	b.BuildArgs = opts.BuildArgs
+	b.Target = opts.Targets.String()
+	b.Platforms = opts.Platforms
+	if len(b.Platforms) == 0 && opts.CustomPlatform != "" {
+		b.Platforms = []string{opts.CustomPlatform}
+	}
+	b.Reproducible = opts.Reproducible
+	if b.Source.EntryPoint == "" {
+		b.Source.EntryPoint = opts.DockerfilePath
+		if rel, err := filepath.Rel(opts.SrcContext, opts.DockerfilePath); err == nil && !strings.HasPrefix(rel, "..") {
+			b.Source.EntryPoint = rel
+		}
+	}
+	statement := provenance.NewStatement(digest, names, b, provenance.DefaultMaterials.List())
+
+	if opts.ProvenanceFile != "" {
+		content, err := json.MarshalIndent(statement, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(opts.ProvenanceFile, content, 0644); err != nil {
+			return errors.Wrap(err, "writing provenance file")
+		}
+		logrus.Infof("Provenance written to %s", opts.ProvenanceFile)
+	}
+
+	if !opts.PushProvenance {
+		return nil
+	}
+	var signer crypto.Signer
+	if opts.SignKey != "" {
+		if signer, err = signing.LoadSigner(opts.SignKey); err != nil {
+			return errors.Wrap(err, "loading signing key")
+		}
+	}
+	artifact, err := provenance.Image(statement, signer)
+	if err != nil {
+		return errors.Wrap(err, "creating provenance artifact")
+	}
+	var tags []name.Tag
+	for _, r := range repos {
+		tags = append(tags, r.Tag(provenance.Tag(digest)))
+	}
+	writeFunc := func(ref name.Tag, options ...remote.Option) error {
+		return remote.Write(ref, artifact, options...)
+	}
+	d, err := artifact.Digest()
+	if err != nil {
+		return err
+	}
+	return pushToDestinations(ctx, tags, opts, writeFunc, events.Event{Type: events.AttestationPushed, Digest: d.String()})
+}
+
+// destinationRepositories returns the distinct repositories of the destinations in opts
+func destinationRepositories(opts *config.KanikoOptions) ([]name.Repository, error) {
+	var repos []name.Repository
+	seen := map[string]bool{}
+	for _, d := range opts.Destinations {
+		ref, err := name.NewTag(d, name.WeakValidation)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting tag for destination")
+		}
+		if seen[ref.Context().Name()] {
+			continue
+		}
+		seen[ref.Context().Name()] = true
+		repos = append(repos, ref.Context())
+	}
+	return repos, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/provenance"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestDoProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	image, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{
+		Destinations:   []string{"gcr.io/foo/bar:latest", "gcr.io/foo/bar:v1", "gcr.io/foo/baz"},
+		NoPush:         true,
+		SrcContext:     dir,
+		DockerfilePath: filepath.Join(dir, "build", "Dockerfile"),
+		BuildArgs:      []string{"FOO=bar"},
+		ProvenanceFile: filepath.Join(dir, "provenance.json"),
+	}
+	build := provenance.Build{Source: provenance.ConfigSource{URI: "dir://" + dir}}
+	if err := DoProvenance(context.TODO(), image, opts, build); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(opts.ProvenanceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statement provenance.Statement
+	if err := json.Unmarshal(content, &statement); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []provenance.Subject{
+		{Name: "gcr.io/foo/bar", Digest: provenance.DigestSet{"sha256": digest.Hex}},
+		{Name: "gcr.io/foo/baz", Digest: provenance.DigestSet{"sha256": digest.Hex}},
+	}
+	testutil.CheckDeepEqual(t, expected, statement.Subject)
+	testutil.CheckDeepEqual(t, "build/Dockerfile", statement.Predicate.Invocation.ConfigSource.EntryPoint)
+	testutil.CheckDeepEqual(t, map[string]string{"FOO": "bar"}, statement.Predicate.Invocation.Parameters.BuildArgs)
+}
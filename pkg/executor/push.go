@@ -18,6 +18,7 @@ package executor
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -32,6 +33,8 @@ import (
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/creds"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
+	image_util "github.com/GoogleContainerTools/kaniko/pkg/image"
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 	"github.com/GoogleContainerTools/kaniko/pkg/version"
@@ -39,7 +42,9 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
@@ -156,7 +161,7 @@ func CheckPushPermissions(opts *config.KanikoOptions) error {
 	return nil
 }
 
-func getDigest(image v1.Image) ([]byte, error) {
+func getDigest(image partial.Describable) ([]byte, error) {
 	digest, err := image.Digest()
 	if err != nil {
 		return nil, err
@@ -177,32 +182,187 @@ func writeDigestFile(path string, digestByteArray []byte) error {
 }
 
 // DoPush is responsible for pushing image to the destinations specified in opts
-func DoPush(image v1.Image, opts *config.KanikoOptions) error {
+func DoPush(ctx context.Context, image v1.Image, opts *config.KanikoOptions) error {
+	if err := pushImage(ctx, image, opts, events.Event{Type: events.ImagePushed}); err != nil {
+		return err
+	}
+	return signPushed(ctx, image, opts)
+}
+
+// pushImage pushes image to the destinations specified in opts and emits
+// the pushed event for every destination
+func pushImage(ctx context.Context, image v1.Image, opts *config.KanikoOptions, pushed events.Event) error {
 	t := timing.Start("Total Push Time")
-	var digestByteArray []byte
-	var builder strings.Builder
-	if opts.DigestFile != "" || opts.ImageNameDigestFile != "" || opts.ImageNameTagDigestFile != "" {
-		var err error
-		digestByteArray, err = getDigest(image)
+	if err := writeDigestFiles(image, opts); err != nil {
+		return err
+	}
+
+	destRefs, err := destinationRefs(image, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.OCILayoutPath != "" {
+		if err := writeToLayout(opts.OCILayoutPath, image, destRefs); err != nil {
+			return err
+		}
+	}
+
+	if opts.TarPath != "" {
+		if err := writeTarball(opts.TarPath, image, destRefs); err != nil {
+			return err
+		}
+	}
+
+	if opts.NoPush {
+		logrus.Info("Skipping push to container registry due to --no-push flag")
+		return nil
+	}
+
+	writeFunc := func(ref name.Tag, options ...remote.Option) error {
+		if err := remote.Write(ref, image, options...); err != nil {
+			return err
+		}
+		// The layer of a cache image is reported by the pushed event itself
+		if pushed.Type != events.ImagePushed {
+			return nil
+		}
+		return emitLayersPushed(image, ref)
+	}
+	d, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	pushed.Digest = d.String()
+	if err := pushToDestinations(ctx, destRefs, opts, writeFunc, pushed); err != nil {
+		return err
+	}
+	timing.DefaultRun.Stop(t)
+	logrus.Infof("Pushed image to %d destinations", len(destRefs))
+	// $BUILDER_OUTPUT lists the built image, not the cached layers
+	if pushed.Type != events.ImagePushed {
+		return nil
+	}
+	return writeImageOutputs(image, destRefs)
+}
+
+// DoPushIndex is responsible for pushing a multi-platform image index to the destinations specified in opts
+func DoPushIndex(ctx context.Context, index v1.ImageIndex, opts *config.KanikoOptions) error {
+	t := timing.Start("Total Push Time")
+	if err := writeDigestFiles(index, opts); err != nil {
+		return err
+	}
+
+	destRefs, err := destinationRefs(index, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.OCILayoutPath != "" {
+		if err := writeToLayout(opts.OCILayoutPath, index, destRefs); err != nil {
+			return err
+		}
+	}
+
+	if opts.TarPath != "" {
+		// The docker tarball format has no notion of an index, so every
+		// platform is written to a tarball of its own.
+		im, err := index.IndexManifest()
 		if err != nil {
-			return errors.Wrap(err, "error fetching digest")
+			return err
+		}
+		for _, desc := range im.Manifests {
+			image, err := index.Image(desc.Digest)
+			if err != nil {
+				return errors.Wrapf(err, "getting image %s from index", desc.Digest)
+			}
+			if err := writeTarball(platformTarPath(opts.TarPath, desc.Platform), image, destRefs); err != nil {
+				return err
+			}
 		}
 	}
 
-	if opts.DigestFile != "" {
-		err := writeDigestFile(opts.DigestFile, digestByteArray)
+	if opts.NoPush {
+		logrus.Info("Skipping push to container registry due to --no-push flag")
+		return nil
+	}
+
+	writeFunc := func(ref name.Tag, options ...remote.Option) error {
+		if err := remote.WriteIndex(ref, index, options...); err != nil {
+			return err
+		}
+		im, err := index.IndexManifest()
 		if err != nil {
-			return errors.Wrap(err, "writing digest to file failed")
+			return err
 		}
+		for _, desc := range im.Manifests {
+			image, err := index.Image(desc.Digest)
+			if err != nil {
+				return errors.Wrapf(err, "getting image %s from index", desc.Digest)
+			}
+			if err := emitLayersPushed(image, ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	d, err := index.Digest()
+	if err != nil {
+		return err
+	}
+	pushed := events.Event{Type: events.ImagePushed, Digest: d.String()}
+	if err := pushToDestinations(ctx, destRefs, opts, writeFunc, pushed); err != nil {
+		return err
 	}
+	timing.DefaultRun.Stop(t)
+	logrus.Infof("Pushed image index to %d destinations", len(destRefs))
+	if err := writeImageOutputs(index, destRefs); err != nil {
+		return err
+	}
+	return signPushed(ctx, index, opts)
+}
 
-	if opts.OCILayoutPath != "" {
-		path, err := layout.Write(opts.OCILayoutPath, empty.Index)
+// emitLayersPushed emits the layer-pushed event for every layer of image, once image was written to ref
+func emitLayersPushed(image v1.Image, ref name.Tag) error {
+	layers, err := image.Layers()
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		d, err := l.Digest()
 		if err != nil {
-			return errors.Wrap(err, "writing empty layout")
+			return err
 		}
-		if err := path.AppendImage(image); err != nil {
-			return errors.Wrap(err, "appending image")
+		events.Emit(events.Event{Type: events.LayerPushed, Image: ref.String(), Digest: d.String()})
+	}
+	return nil
+}
+
+// writeDigestFiles writes the digest of image to the digest file specified in opts
+func writeDigestFiles(image partial.Describable, opts *config.KanikoOptions) error {
+	if opts.DigestFile == "" {
+		return nil
+	}
+	digestByteArray, err := getDigest(image)
+	if err != nil {
+		return errors.Wrap(err, "error fetching digest")
+	}
+	if err := writeDigestFile(opts.DigestFile, digestByteArray); err != nil {
+		return errors.Wrap(err, "writing digest to file failed")
+	}
+	return nil
+}
+
+// destinationRefs parses the destinations specified in opts and writes the
+// image name files that refer to them
+func destinationRefs(image partial.Describable, opts *config.KanikoOptions) ([]name.Tag, error) {
+	var digestByteArray []byte
+	var builder strings.Builder
+	if opts.ImageNameDigestFile != "" || opts.ImageNameTagDigestFile != "" {
+		var err error
+		digestByteArray, err = getDigest(image)
+		if err != nil {
+			return nil, errors.Wrap(err, "error fetching digest")
 		}
 	}
 
@@ -210,7 +370,7 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 	for _, destination := range opts.Destinations {
 		destRef, err := name.NewTag(destination, name.WeakValidation)
 		if err != nil {
-			return errors.Wrap(err, "getting tag for destination")
+			return nil, errors.Wrap(err, "getting tag for destination")
 		}
 		if opts.ImageNameDigestFile != "" || opts.ImageNameTagDigestFile != "" {
 			tag := ""
@@ -227,39 +387,101 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 	if opts.ImageNameDigestFile != "" {
 		err := writeDigestFile(opts.ImageNameDigestFile, []byte(builder.String()))
 		if err != nil {
-			return errors.Wrap(err, "writing image name with digest to file failed")
+			return nil, errors.Wrap(err, "writing image name with digest to file failed")
 		}
 	}
 
 	if opts.ImageNameTagDigestFile != "" {
 		err := writeDigestFile(opts.ImageNameTagDigestFile, []byte(builder.String()))
 		if err != nil {
-			return errors.Wrap(err, "writing image name with image tag and digest to file failed")
+			return nil, errors.Wrap(err, "writing image name with image tag and digest to file failed")
 		}
 	}
+	return destRefs, nil
+}
 
-	if opts.TarPath != "" {
-		tagToImage := map[name.Tag]v1.Image{}
+func writeTarball(tarPath string, image v1.Image, destRefs []name.Tag) error {
+	tagToImage := map[name.Tag]v1.Image{}
+
+	if len(destRefs) == 0 {
+		return errors.New("must provide at least one destination when tarPath is specified")
+	}
+
+	for _, destRef := range destRefs {
+		tagToImage[destRef] = image
+	}
+	err := tarball.MultiWriteToFile(tarPath, tagToImage)
+	if err != nil {
+		return errors.Wrap(err, "writing tarball to file failed")
+	}
+	return nil
+}
 
-		if len(destRefs) == 0 {
-			return errors.New("must provide at least one destination when tarPath is specified")
+// writeToLayout adds image, an image or an image index, to the OCI layout at path,
+// which is created if it doesn't exist. It is annotated with the name of every
+// destination, replacing what the layout had under the same name.
+func writeToLayout(path string, image partial.Describable, destRefs []name.Tag) error {
+	p, err := layout.FromPath(path)
+	if os.IsNotExist(err) {
+		p, err = layout.Write(path, empty.Index)
+	}
+	if err != nil {
+		return errors.Wrap(err, "opening layout")
+	}
+	replace := func(matcher match.Matcher, options ...layout.Option) error {
+		switch v := image.(type) {
+		case v1.ImageIndex:
+			return p.ReplaceIndex(v, matcher, options...)
+		case v1.Image:
+			return p.ReplaceImage(v, matcher, options...)
 		}
+		return fmt.Errorf("unexpected image type %T", image)
+	}
 
-		for _, destRef := range destRefs {
-			tagToImage[destRef] = image
+	if len(destRefs) == 0 {
+		// Without a name, there is nothing to replace
+		if err := replace(func(v1.Descriptor) bool { return false }); err != nil {
+			return errors.Wrap(err, "appending image to layout")
 		}
-		err := tarball.MultiWriteToFile(opts.TarPath, tagToImage)
-		if err != nil {
-			return errors.Wrap(err, "writing tarball to file failed")
+		return nil
+	}
+	for _, destRef := range destRefs {
+		refName := image_util.LayoutRefName(destRef)
+		annotations := map[string]string{image_util.RefNameAnnotation: refName}
+		if err := replace(layoutRefMatcher(refName), layout.WithAnnotations(annotations)); err != nil {
+			return errors.Wrapf(err, "writing %s to layout", refName)
 		}
+		logrus.Infof("Wrote %s to the OCI layout %s", refName, path)
 	}
+	return nil
+}
 
-	if opts.NoPush {
-		logrus.Info("Skipping push to container registry due to --no-push flag")
-		return nil
+// layoutRefMatcher matches the descriptors of an OCI layout named refName
+func layoutRefMatcher(refName string) match.Matcher {
+	return func(desc v1.Descriptor) bool {
+		ref, err := name.ParseReference(desc.Annotations[image_util.RefNameAnnotation], name.WeakValidation)
+		return err == nil && image_util.LayoutRefName(ref) == refName
+	}
+}
+
+// platformTarPath returns the path of the tarball for the given platform,
+// e.g. image.tar becomes image_linux_arm64.tar
+func platformTarPath(tarPath string, platform *v1.Platform) string {
+	if platform == nil {
+		return tarPath
 	}
+	suffix := platform.OS + "_" + platform.Architecture
+	if platform.Variant != "" {
+		suffix += "_" + platform.Variant
+	}
+	ext := filepath.Ext(tarPath)
+	return strings.TrimSuffix(tarPath, ext) + "_" + suffix + ext
+}
 
-	// continue pushing unless an error occurs
+// pushToDestinations calls write for every destination with the remote options
+// needed to reach its registry, continuing unless an error occurs. The pushed
+// event is emitted for every destination once it was written.
+func pushToDestinations(ctx context.Context, destRefs []name.Tag, opts *config.KanikoOptions, write func(name.Tag, ...remote.Option) error, pushed events.Event) error {
 	for _, destRef := range destRefs {
 		registryName := destRef.Repository.Registry.Name()
 		if opts.Insecure || opts.InsecureRegistries.Contains(registryName) {
@@ -281,19 +503,20 @@ func DoPush(image v1.Image, opts *config.KanikoOptions) error {
 		logrus.Infof("Pushing image to %s", destRef.String())
 
 		retryFunc := func() error {
-			return remote.Write(destRef, image, remote.WithAuth(pushAuth), remote.WithTransport(rt))
+			return write(destRef, remote.WithAuth(pushAuth), remote.WithTransport(rt), remote.WithContext(ctx))
 		}
 
 		if err := util.Retry(retryFunc, opts.PushRetry, 1000); err != nil {
 			return errors.Wrap(err, fmt.Sprintf("failed to push to destination %s", destRef))
 		}
+		e := pushed
+		e.Image = destRef.String()
+		events.Emit(e)
 	}
-	timing.DefaultRun.Stop(t)
-	logrus.Infof("Pushed image to %d destinations", len(destRefs))
-	return writeImageOutputs(image, destRefs)
+	return nil
 }
 
-func writeImageOutputs(image v1.Image, destRefs []name.Tag) error {
+func writeImageOutputs(image partial.Describable, destRefs []name.Tag) error {
 	dir := os.Getenv("BUILDER_OUTPUT")
 	if dir == "" {
 		return nil
@@ -326,8 +549,8 @@ func writeImageOutputs(image v1.Image, destRefs []name.Tag) error {
 
 // pushLayerToCache pushes layer (tagged with cacheKey) to opts.Cache
 // if opts.Cache doesn't exist, infer the cache from the given destination
-func pushLayerToCache(opts *config.KanikoOptions, cacheKey string, tarPath string, createdBy string) error {
-	layer, err := tarball.LayerFromFile(tarPath, tarball.WithCompressedCaching)
+func pushLayerToCache(ctx context.Context, opts *config.KanikoOptions, cacheKey string, tarPath string, createdBy string) error {
+	layer, err := newLayer(tarPath, opts)
 	if err != nil {
 		return err
 	}
@@ -336,7 +559,10 @@ func pushLayerToCache(opts *config.KanikoOptions, cacheKey string, tarPath strin
 		return errors.Wrap(err, "getting cache destination")
 	}
 	logrus.Infof("Pushing layer %s to cache now", cache)
-	empty := empty.Image
+	empty, err := compressionImage(empty.Image, opts)
+	if err != nil {
+		return err
+	}
 	empty, err = mutate.CreatedAt(empty, v1.Time{Time: time.Now()})
 	if err != nil {
 		return errors.Wrap(err, "setting empty image created time")
@@ -357,8 +583,14 @@ func pushLayerToCache(opts *config.KanikoOptions, cacheKey string, tarPath strin
 	cacheOpts := *opts
 	cacheOpts.TarPath = ""   // tarPath doesn't make sense for Docker layers
 	cacheOpts.NoPush = false // we want to push cached layers
+	// The layout and the digest files are those of the built image, cached layers
+	// are pushed concurrently and mustn't end up in them
+	cacheOpts.OCILayoutPath = ""
+	cacheOpts.DigestFile = ""
+	cacheOpts.ImageNameDigestFile = ""
+	cacheOpts.ImageNameTagDigestFile = ""
 	cacheOpts.Destinations = []string{cache}
 	cacheOpts.InsecureRegistries = opts.InsecureRegistries
 	cacheOpts.SkipTLSVerifyRegistries = opts.SkipTLSVerifyRegistries
-	return DoPush(empty, &cacheOpts)
+	return pushImage(ctx, empty, &cacheOpts, events.Event{Type: events.LayerPushed, Command: createdBy, CacheKey: cacheKey})
 }
@@ -18,18 +18,27 @@ package executor
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
+	image_util "github.com/GoogleContainerTools/kaniko/pkg/image"
 	"github.com/GoogleContainerTools/kaniko/testutil"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/validate"
@@ -282,7 +291,7 @@ func TestOCILayoutPath(t *testing.T) {
 		OCILayoutPath: tmpDir,
 	}
 
-	if err := DoPush(image, &opts); err != nil {
+	if err := DoPush(context.TODO(), image, &opts); err != nil {
 		t.Fatalf("could not push image: %s", err)
 	}
 
@@ -301,6 +310,80 @@ func TestOCILayoutPath(t *testing.T) {
 	testutil.CheckErrorAndDeepEqual(t, false, err, want, got)
 }
 
+func TestOCILayoutPathIndex(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	index, err := random.Index(1024, 2, 2)
+	if err != nil {
+		t.Fatalf("could not create index: %s", err)
+	}
+
+	digest, err := index.Digest()
+	if err != nil {
+		t.Fatalf("could not get index digest: %s", err)
+	}
+
+	want, err := index.IndexManifest()
+	if err != nil {
+		t.Fatalf("could not get index manifest: %s", err)
+	}
+
+	opts := config.KanikoOptions{
+		NoPush:        true,
+		OCILayoutPath: tmpDir,
+	}
+
+	if err := DoPushIndex(context.TODO(), index, &opts); err != nil {
+		t.Fatalf("could not push index: %s", err)
+	}
+
+	layoutIndex, err := layout.ImageIndexFromPath(tmpDir)
+	if err != nil {
+		t.Fatalf("could not get index from layout: %s", err)
+	}
+	testutil.CheckError(t, false, validate.Index(layoutIndex))
+
+	pushedIndex, err := layoutIndex.ImageIndex(digest)
+	if err != nil {
+		t.Fatalf("could not get image index from layout: %s", err)
+	}
+
+	got, err := pushedIndex.IndexManifest()
+	testutil.CheckErrorAndDeepEqual(t, false, err, want, got)
+}
+
+func TestPlatformTarPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		tarPath  string
+		platform *v1.Platform
+		expected string
+	}{{
+		name:     "no platform",
+		tarPath:  "/workspace/image.tar",
+		expected: "/workspace/image.tar",
+	}, {
+		name:     "os and architecture",
+		tarPath:  "/workspace/image.tar",
+		platform: &v1.Platform{OS: "linux", Architecture: "arm64"},
+		expected: "/workspace/image_linux_arm64.tar",
+	}, {
+		name:     "variant and no extension",
+		tarPath:  "/workspace/image",
+		platform: &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+		expected: "/workspace/image_linux_arm_v7",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, platformTarPath(test.tarPath, test.platform))
+		})
+	}
+}
+
 func TestImageNameDigestFile(t *testing.T) {
 	image, err := random.Image(1024, 4)
 	if err != nil {
@@ -320,7 +403,7 @@ func TestImageNameDigestFile(t *testing.T) {
 
 	defer os.Remove("tmpFile")
 
-	if err := DoPush(image, &opts); err != nil {
+	if err := DoPush(context.TODO(), image, &opts); err != nil {
 		t.Fatalf("could not push image: %s", err)
 	}
 
@@ -351,7 +434,7 @@ func TestImageNameTagDigestFile(t *testing.T) {
 
 	defer os.Remove("tmpFile")
 
-	if err := DoPush(image, &opts); err != nil {
+	if err := DoPush(context.TODO(), image, &opts); err != nil {
 		t.Fatalf("could not push image: %s", err)
 	}
 
@@ -474,3 +557,130 @@ func TestWriteDigestFile(t *testing.T) {
 		}
 	})
 }
+
+func TestOCILayoutPathAppend(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	first, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("could not create image: %s", err)
+	}
+	second, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("could not create image: %s", err)
+	}
+
+	opts := config.KanikoOptions{
+		NoPush:        true,
+		OCILayoutPath: tmpDir,
+		Destinations:  []string{"gcr.io/foo/app:v1", "gcr.io/foo/app:latest"},
+	}
+	if err := DoPush(context.TODO(), first, &opts); err != nil {
+		t.Fatalf("could not push image: %s", err)
+	}
+	opts.Destinations = []string{"gcr.io/foo/app:latest"}
+	if err := DoPush(context.TODO(), second, &opts); err != nil {
+		t.Fatalf("could not push image: %s", err)
+	}
+
+	layoutIndex, err := layout.ImageIndexFromPath(tmpDir)
+	if err != nil {
+		t.Fatalf("could not get index from layout: %s", err)
+	}
+	testutil.CheckError(t, false, validate.Index(layoutIndex))
+	m, err := layoutIndex.IndexManifest()
+	if err != nil {
+		t.Fatalf("could not get index manifest: %s", err)
+	}
+	got := map[string]v1.Hash{}
+	for _, desc := range m.Manifests {
+		got[desc.Annotations[image_util.RefNameAnnotation]] = desc.Digest
+	}
+	firstDigest, _ := first.Digest()
+	secondDigest, _ := second.Digest()
+	want := map[string]v1.Hash{"gcr.io/foo/app:v1": firstDigest, "gcr.io/foo/app:latest": secondDigest}
+	testutil.CheckDeepEqual(t, want, got)
+}
+
+func TestEmitLayersPushed(t *testing.T) {
+	image, err := random.Image(1024, 2)
+	testutil.CheckError(t, false, err)
+	ref, err := name.NewTag("gcr.io/foo/bar:latest")
+	testutil.CheckError(t, false, err)
+
+	b := bytes.Buffer{}
+	events.DefaultStream.SetOutput(&b)
+	defer events.DefaultStream.SetOutput(nil)
+	testutil.CheckError(t, false, emitLayersPushed(image, ref))
+
+	layers, err := image.Layers()
+	testutil.CheckError(t, false, err)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	testutil.CheckDeepEqual(t, len(layers), len(lines))
+	for i, l := range layers {
+		d, err := l.Digest()
+		testutil.CheckError(t, false, err)
+		var e events.Event
+		testutil.CheckError(t, false, json.Unmarshal([]byte(lines[i]), &e))
+		testutil.CheckDeepEqual(t, events.LayerPushed, e.Type)
+		testutil.CheckDeepEqual(t, ref.String(), e.Image)
+		testutil.CheckDeepEqual(t, d.String(), e.Digest)
+	}
+}
+
+func TestDoBuild_cacheOCILayoutPath(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch
+COPY foo/bam.txt copied/
+COPY foo/bam.txt copied/again/`
+	// The build deletes the filesystem it ran in, the outputs are kept elsewhere
+	dir, err := ioutil.TempDir("", "outputs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerFile), 0644)
+	opts := &config.KanikoOptions{
+		DockerfilePath:  filepath.Join(dir, "Dockerfile"),
+		SrcContext:      filepath.Join(testDir, "workspace"),
+		SnapshotMode:    constants.SnapshotModeFull,
+		Cache:           true,
+		CacheCopyLayers: true,
+		CacheRepo:       host + "/cache",
+		OCILayoutPath:   filepath.Join(dir, "layout"),
+		DigestFile:      filepath.Join(dir, "digest"),
+		Destinations:    []string{host + "/app:latest"},
+	}
+	image, err := DoBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	// The layers were pushed to the cache, but nothing was written to the outputs
+	if _, err := os.Stat(opts.OCILayoutPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no layout before pushing, got %v", err)
+	}
+	if _, err := os.Stat(opts.DigestFile); !os.IsNotExist(err) {
+		t.Fatalf("expected no digest file before pushing, got %v", err)
+	}
+	testutil.CheckNoError(t, DoPush(context.TODO(), image, opts))
+
+	index, err := layout.ImageIndexFromPath(opts.OCILayoutPath)
+	testutil.CheckNoError(t, err)
+	manifest, err := index.IndexManifest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 1, len(manifest.Manifests))
+	d, err := image.Digest()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, d, manifest.Manifests[0].Digest)
+	b, err := ioutil.ReadFile(opts.DigestFile)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, d.String(), string(b))
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/artifact"
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
+	"github.com/GoogleContainerTools/kaniko/pkg/sbom"
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+)
+
+// recordSBOM inventories the filesystem of the final stage, which is still
+// unpacked at the root, as the SBOM of image
+func recordSBOM(image v1.Image) error {
+	pkgs, err := sbom.Inventory(config.RootDir)
+	if err != nil {
+		return errors.Wrap(err, "inventorying packages for the SBOM")
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Found %d packages for the SBOM", len(pkgs))
+	sbom.Record(digest, pkgs)
+	return nil
+}
+
+// DoSBOM renders the SBOM of every image that was built, writes it to
+// opts.SBOMFile and pushes it next to the image unless opts.NoPush is set
+func DoSBOM(ctx context.Context, built partial.Describable, opts *config.KanikoOptions) error {
+	if opts.SBOMFormat == "" {
+		return nil
+	}
+	t := timing.Start("SBOM Time")
+	defer timing.DefaultRun.Stop(t)
+
+	repos, err := destinationRepositories(opts)
+	if err != nil {
+		return err
+	}
+	docName := "image"
+	if len(repos) > 0 {
+		docName = repos[0].Name()
+	}
+
+	type target struct {
+		digest   v1.Hash
+		platform *v1.Platform
+	}
+	var targets []target
+	if index, ok := built.(v1.ImageIndex); ok {
+		m, err := index.IndexManifest()
+		if err != nil {
+			return err
+		}
+		for _, desc := range m.Manifests {
+			targets = append(targets, target{digest: desc.Digest, platform: desc.Platform})
+		}
+	} else {
+		digest, err := built.Digest()
+		if err != nil {
+			return errors.Wrap(err, "getting image digest")
+		}
+		targets = append(targets, target{digest: digest})
+	}
+
+	for _, tg := range targets {
+		pkgs, ok := sbom.Lookup(tg.digest)
+		if !ok {
+			return fmt.Errorf("no SBOM was recorded for image %s", tg.digest)
+		}
+		content, err := sbom.Document(opts.SBOMFormat, docName, tg.digest, pkgs)
+		if err != nil {
+			return errors.Wrap(err, "rendering SBOM")
+		}
+
+		if opts.SBOMFile != "" {
+			path := platformTarPath(opts.SBOMFile, tg.platform)
+			if err := ioutil.WriteFile(path, content, 0644); err != nil {
+				return errors.Wrap(err, "writing SBOM file")
+			}
+			logrus.Infof("SBOM written to %s", path)
+		}
+
+		if opts.NoPush || len(repos) == 0 {
+			continue
+		}
+		image, err := artifact.Image(content, sbom.MediaType(opts.SBOMFormat))
+		if err != nil {
+			return errors.Wrap(err, "creating SBOM artifact")
+		}
+		var tags []name.Tag
+		for _, r := range repos {
+			tags = append(tags, r.Tag(artifact.Tag(tg.digest, "sbom")))
+		}
+		writeFunc := func(ref name.Tag, options ...remote.Option) error {
+			return remote.Write(ref, image, options...)
+		}
+		d, err := image.Digest()
+		if err != nil {
+			return err
+		}
+		if err := pushToDestinations(ctx, tags, opts, writeFunc, events.Event{Type: events.SBOMPushed, Digest: d.String()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
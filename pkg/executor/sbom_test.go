@@ -0,0 +1,97 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/sbom"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestDoSBOM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	amd64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []v1.Image{amd64, arm64} {
+		digest, err := image.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sbom.Record(digest, []sbom.Package{{Name: "musl", Version: "1.2.2-r3", Type: "apk", Namespace: "alpine"}})
+	}
+
+	if err := DoSBOM(context.TODO(), amd64, &config.KanikoOptions{
+		NoPush:     true,
+		SBOMFormat: sbom.FormatCycloneDX,
+		SBOMFile:   filepath.Join(dir, "sbom.json"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "sbom.json"))
+	testutil.CheckError(t, false, err)
+	if !strings.Contains(string(content), "pkg:apk/alpine/musl@1.2.2-r3") {
+		t.Errorf("expected the SBOM to contain the musl package, got %s", content)
+	}
+
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}}},
+	)
+	opts := &config.KanikoOptions{
+		NoPush:     true,
+		SBOMFormat: sbom.FormatSPDX,
+		SBOMFile:   filepath.Join(dir, "sbom.spdx.json"),
+	}
+	if err := DoSBOM(context.TODO(), index, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sbom.spdx_linux_amd64.json", "sbom.spdx_linux_arm64_v8.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected the SBOM of a platform at %s: %s", name, err)
+		}
+	}
+
+	unknown, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DoSBOM(context.TODO(), unknown, opts)
+	testutil.CheckError(t, true, err)
+}
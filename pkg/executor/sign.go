@@ -0,0 +1,89 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/events"
+	"github.com/GoogleContainerTools/kaniko/pkg/signing"
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+)
+
+// signPushed signs the pushed image with opts.SignKey and pushes the signature
+// to every destination repository, next to the signatures already there
+func signPushed(ctx context.Context, image partial.Describable, opts *config.KanikoOptions) error {
+	if opts.SignKey == "" || opts.NoPush {
+		return nil
+	}
+	t := timing.Start("Signing Time")
+	defer timing.DefaultRun.Stop(t)
+
+	signer, err := signing.LoadSigner(opts.SignKey)
+	if err != nil {
+		return errors.Wrap(err, "loading signing key")
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return errors.Wrap(err, "getting image digest")
+	}
+	repos, err := destinationRepositories(opts)
+	if err != nil {
+		return err
+	}
+	for _, r := range repos {
+		// the payload names the repository, so every repository gets its own signature
+		payload, err := signing.NewPayload(r.Name(), digest)
+		if err != nil {
+			return err
+		}
+		signature, err := signing.Sign(signer, payload)
+		if err != nil {
+			return errors.Wrap(err, "signing image")
+		}
+		writeFunc := func(ref name.Tag, options ...remote.Option) error {
+			var base v1.Image = empty.Image
+			existing, err := remote.Image(ref, options...)
+			switch {
+			case err == nil:
+				logrus.Debugf("Appending signature to the existing signatures in %s", ref)
+				base = existing
+			case !signing.IsNotFound(err):
+				return errors.Wrap(err, "retrieving existing signatures")
+			}
+			sigs, err := signing.Append(base, payload, signature)
+			if err != nil {
+				return err
+			}
+			return remote.Write(ref, sigs, options...)
+		}
+		tags := []name.Tag{r.Tag(signing.Tag(digest))}
+		if err := pushToDestinations(ctx, tags, opts, writeFunc, events.Event{Type: events.SignaturePushed, Digest: digest.String()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+)
+
+// MultiTarget returns true if opts asks for the images of several targets,
+// which are pushed to the destinations given with --destination-for
+func MultiTarget(opts *config.KanikoOptions) bool {
+	return len(opts.Targets) > 1 || len(opts.TargetDestinations) > 0
+}
+
+// CheckTargets returns an error if the targets of opts and their destinations don't go together
+func CheckTargets(opts *config.KanikoOptions) error {
+	if !MultiTarget(opts) {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, target := range opts.Targets {
+		if target == "" {
+			return errors.New("the stage of every target must be named")
+		}
+		if seen[target] {
+			return fmt.Errorf("target %s is set more than once", target)
+		}
+		seen[target] = true
+	}
+	if len(opts.Destinations) > 0 {
+		return errors.New("--destination can't be used with several targets, use --destination-for <target>=<destination>")
+	}
+	if len(opts.Platforms) > 0 {
+		return errors.New("--platform can't be used with several targets")
+	}
+	// The layers the targets share are cached once, not in a repo next to one of them
+	if opts.Cache && opts.CacheRepo == "" {
+		return errors.New("--cache needs --cache-repo with several targets")
+	}
+	for target := range opts.TargetDestinations {
+		if !seen[target] {
+			return fmt.Errorf("--destination-for is set for %s, which isn't a target", target)
+		}
+	}
+	if !opts.NoPush && !opts.DryRun {
+		for _, target := range opts.Targets {
+			if len(opts.TargetDestinations[target]) == 0 {
+				return fmt.Errorf("You must provide --destination-for %s=<destination>, or use --no-push", target)
+			}
+		}
+	}
+	return nil
+}
+
+// TargetOptions returns the options the image of target is pushed with: its own
+// destinations, and files suffixed with the name of the target
+func TargetOptions(opts *config.KanikoOptions, target string) *config.KanikoOptions {
+	targetOpts := *opts
+	targetOpts.Targets = []string{target}
+	targetOpts.TargetDestinations = nil
+	targetOpts.Destinations = opts.TargetDestinations[target]
+	for _, p := range []*string{
+		&targetOpts.TarPath,
+		&targetOpts.DigestFile,
+		&targetOpts.ImageNameDigestFile,
+		&targetOpts.ImageNameTagDigestFile,
+		&targetOpts.ProvenanceFile,
+		&targetOpts.SBOMFile,
+	} {
+		if *p != "" {
+			*p = targetPath(*p, target)
+		}
+	}
+	return &targetOpts
+}
+
+// targetPath returns the path of the file for the given target,
+// e.g. digest.txt becomes digest_api.txt
+func targetPath(path string, target string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_" + target + ext
+}
+
+// DoMultiTargetBuild builds the Dockerfile once for all the targets in opts.Targets,
+// the stages they share being built once, and returns their images by target
+func DoMultiTargetBuild(ctx context.Context, opts *config.KanikoOptions) (map[string]v1.Image, error) {
+	images, err := doBuild(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range opts.Targets {
+		if _, ok := images[target]; !ok {
+			return nil, fmt.Errorf("no image was built for target %s", target)
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/constants"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func TestCheckTargets(t *testing.T) {
+	tests := []struct {
+		description string
+		opts        config.KanikoOptions
+		shouldErr   bool
+	}{
+		{
+			description: "single target",
+			opts:        config.KanikoOptions{Targets: []string{"api"}, Destinations: []string{"reg/api"}},
+		},
+		{
+			description: "destination for every target",
+			opts: config.KanikoOptions{
+				Targets:            []string{"api", "worker"},
+				TargetDestinations: map[string][]string{"api": {"reg/api"}, "worker": {"reg/worker"}},
+			},
+		},
+		{
+			description: "no destinations without pushing",
+			opts:        config.KanikoOptions{Targets: []string{"api", "worker"}, NoPush: true},
+		},
+		{
+			description: "target without destination",
+			opts: config.KanikoOptions{
+				Targets:            []string{"api", "worker"},
+				TargetDestinations: map[string][]string{"api": {"reg/api"}},
+			},
+			shouldErr: true,
+		},
+		{
+			description: "destination for something else",
+			opts: config.KanikoOptions{
+				Targets:            []string{"api"},
+				TargetDestinations: map[string][]string{"api": {"reg/api"}, "worker": {"reg/worker"}},
+			},
+			shouldErr: true,
+		},
+		{
+			description: "destination along with several targets",
+			opts:        config.KanikoOptions{Targets: []string{"api", "worker"}, Destinations: []string{"reg/api"}, NoPush: true},
+			shouldErr:   true,
+		},
+		{
+			description: "platforms along with several targets",
+			opts:        config.KanikoOptions{Targets: []string{"api", "worker"}, Platforms: []string{"linux/amd64"}, NoPush: true},
+			shouldErr:   true,
+		},
+		{
+			description: "cache along with several targets",
+			opts:        config.KanikoOptions{Targets: []string{"api", "worker"}, NoPush: true, CacheRepo: "reg/cache", Cache: true},
+		},
+		{
+			description: "cache without cache repo along with several targets",
+			opts: config.KanikoOptions{
+				Targets:            []string{"api", "worker"},
+				TargetDestinations: map[string][]string{"api": {"reg/api"}, "worker": {"reg/worker"}},
+				Cache:              true,
+			},
+			shouldErr: true,
+		},
+		{
+			description: "target set twice",
+			opts:        config.KanikoOptions{Targets: []string{"api", "api"}, NoPush: true},
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			testutil.CheckError(t, test.shouldErr, CheckTargets(&test.opts))
+		})
+	}
+}
+
+func TestTargetOptions(t *testing.T) {
+	opts := &config.KanikoOptions{
+		Targets:             []string{"api", "worker"},
+		TargetDestinations:  map[string][]string{"api": {"reg/api:tag", "reg/api:latest"}, "worker": {"reg/worker:tag"}},
+		DigestFile:          "/workspace/digest",
+		ImageNameDigestFile: "/workspace/name-digest.txt",
+		TarPath:             "/workspace/image.tar",
+	}
+	apiOpts := TargetOptions(opts, "api")
+	testutil.CheckDeepEqual(t, []string{"api"}, []string(apiOpts.Targets))
+	testutil.CheckDeepEqual(t, []string{"reg/api:tag", "reg/api:latest"}, []string(apiOpts.Destinations))
+	testutil.CheckDeepEqual(t, "/workspace/digest_api", apiOpts.DigestFile)
+	testutil.CheckDeepEqual(t, "/workspace/name-digest_api.txt", apiOpts.ImageNameDigestFile)
+	testutil.CheckDeepEqual(t, "/workspace/image_api.tar", apiOpts.TarPath)
+	testutil.CheckDeepEqual(t, "", apiOpts.ImageNameTagDigestFile)
+	testutil.CheckDeepEqual(t, false, MultiTarget(apiOpts))
+	testutil.CheckDeepEqual(t, "/workspace/digest", opts.DigestFile)
+}
+
+func TestDoMultiTargetBuild(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch as first
+COPY foo/bam.txt copied/
+
+FROM first as api
+ENV target api
+
+FROM scratch as worker
+COPY --from=first copied/bam.txt output/bam.txt
+ENV target worker`
+	ioutil.WriteFile(filepath.Join(testDir, "workspace", "Dockerfile"), []byte(dockerFile), 0755)
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(testDir, "workspace", "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		Targets:        []string{"api", "worker"},
+		NoPush:         true,
+	}
+	images, err := DoMultiTargetBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, 2, len(images))
+	for target, image := range images {
+		cf, err := image.ConfigFile()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, "target="+target, cf.Config.Env[len(cf.Config.Env)-1])
+		layers, err := image.Layers()
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, 1, len(layers))
+	}
+}
+
+func TestDoBuild_targets(t *testing.T) {
+	testDir, fn := setupMultistageTests(t)
+	defer fn()
+	dockerFile := `
+FROM scratch as api
+ENV target api
+
+FROM scratch as worker
+ENV target worker`
+	// The build deletes the filesystem it ran in, the Dockerfile is kept elsewhere
+	dir, err := ioutil.TempDir("", "dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerFile), 0644)
+	opts := &config.KanikoOptions{
+		DockerfilePath: filepath.Join(dir, "Dockerfile"),
+		SrcContext:     filepath.Join(testDir, "workspace"),
+		SnapshotMode:   constants.SnapshotModeFull,
+		Targets:        []string{"api", "worker"},
+		NoPush:         true,
+	}
+	// DoBuild returns the image of a single target only
+	_, err = DoBuild(context.TODO(), opts)
+	testutil.CheckError(t, true, err)
+
+	opts.Targets = []string{"worker"}
+	image, err := DoBuild(context.TODO(), opts)
+	testutil.CheckNoError(t, err)
+	cf, err := image.ConfigFile()
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, "target=worker", cf.Config.Env[len(cf.Config.Env)-1])
+}
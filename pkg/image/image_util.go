@@ -17,6 +17,7 @@ limitations under the License.
 package image
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strconv"
@@ -25,25 +26,28 @@ import (
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/constants"
 	"github.com/GoogleContainerTools/kaniko/pkg/image/remote"
+	"github.com/GoogleContainerTools/kaniko/pkg/policy"
+	"github.com/GoogleContainerTools/kaniko/pkg/signing"
 	"github.com/GoogleContainerTools/kaniko/pkg/timing"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
-	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
 
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	// RetrieveRemoteImage downloads an image from a remote location
-	RetrieveRemoteImage = remote.RetrieveRemoteImage
-	retrieveTarImage    = tarballImage
+	RetrieveRemoteImage  = remote.RetrieveRemoteImage
+	retrieveTarImage     = tarballImage
+	verifyImageSignature = remote.VerifySignature
 )
 
 // RetrieveSourceImage returns the base image of the stage at index
-func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (v1.Image, error) {
+func RetrieveSourceImage(ctx context.Context, stage config.KanikoStage, opts *config.KanikoOptions) (v1.Image, error) {
 	t := timing.Start("Retrieving Source Image")
 	defer timing.DefaultRun.Stop(t)
 	var buildArgs []string
@@ -67,10 +71,34 @@ func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (
 		return retrieveTarImage(stage.BaseImageIndex)
 	}
 
+	// Other base images must be allowed by --base-image-policy
+	if err := policy.CheckBaseImage(opts.BaseImagePolicy, currentBaseName, stage.SourceCode); err != nil {
+		return nil, err
+	}
+
+	// They are retrieved by the digest --lockfile pins them to, if set
+	lockedBaseName, err := LockedReference(currentBaseName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Then, check if the base image was written to the OCI layout of --base-image-layout
+	if opts.BaseImageLayout != "" {
+		layoutImage, err := LayoutImage(opts.BaseImageLayout, currentBaseName, opts.CustomPlatform)
+		if err != nil {
+			return nil, err
+		}
+		if layoutImage != nil {
+			logrus.Infof("Using base image %s from the OCI layout %s", currentBaseName, opts.BaseImageLayout)
+			return useBaseImage(ctx, currentBaseName, layoutImage, opts)
+		}
+		logrus.Debugf("Base image %s not found in the OCI layout %s", currentBaseName, opts.BaseImageLayout)
+	}
+
 	// Finally, check if local caching is enabled
 	// If so, look in the local cache before trying the remote registry
 	if opts.Cache && opts.CacheDir != "" {
-		cachedImage, err := cachedImage(opts, currentBaseName)
+		cachedImage, err := cachedImage(ctx, opts, lockedBaseName)
 		if err != nil {
 			switch {
 			case cache.IsNotFound(err):
@@ -81,21 +109,58 @@ func RetrieveSourceImage(stage config.KanikoStage, opts *config.KanikoOptions) (
 				logrus.Errorf("Error while retrieving image from cache: %v %v", currentBaseName, err)
 			}
 		} else if cachedImage != nil {
-			return cachedImage, nil
+			return useBaseImage(ctx, currentBaseName, cachedImage, opts)
 		}
 	}
 
 	// Otherwise, initialize image as usual
-	return RetrieveRemoteImage(currentBaseName, opts.RegistryOptions, opts.CustomPlatform)
+	remoteImage, err := RetrieveRemoteImage(ctx, lockedBaseName, opts.RegistryOptions, opts.CustomPlatform)
+	if err != nil {
+		return nil, err
+	}
+	return useBaseImage(ctx, currentBaseName, remoteImage, opts)
+}
+
+// useBaseImage returns the base image img retrieved for image once it is
+// recorded for --lockfile-out and verified
+func useBaseImage(ctx context.Context, image string, img v1.Image, opts *config.KanikoOptions) (v1.Image, error) {
+	if err := RecordImage(image, img, opts); err != nil {
+		return nil, err
+	}
+	if err := VerifyImage(ctx, image, img, opts); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// VerifyImage checks that img, retrieved for image, was signed with opts.VerifyBaseImageKey
+// if it is set. The signature must be of img or of an index image is resolved to, by
+// the digest --lockfile pins it to if set, which includes img.
+func VerifyImage(ctx context.Context, image string, img v1.Image, opts *config.KanikoOptions) error {
+	if opts.VerifyBaseImageKey == "" {
+		return nil
+	}
+	key, err := signing.LoadPublicKey(opts.VerifyBaseImageKey)
+	if err != nil {
+		return errors.Wrap(err, "loading base image verification key")
+	}
+	lockedName, err := LockedReference(image, opts)
+	if err != nil {
+		return err
+	}
+	if err := verifyImageSignature(ctx, lockedName, img, key, opts.RegistryOptions, opts.CustomPlatform); err != nil {
+		return errors.Wrapf(err, "verifying signature of image %s", image)
+	}
+	return nil
 }
 
 func tarballImage(index int) (v1.Image, error) {
-	tarPath := filepath.Join(constants.KanikoIntermediateStagesDir, strconv.Itoa(index))
+	tarPath := filepath.Join(config.IntermediateStagesDir, strconv.Itoa(index))
 	logrus.Infof("Base image from previous stage %d found, using saved tar at path %s", index, tarPath)
-	return tarball.ImageFromPath(tarPath, nil)
+	return util.ImageFromTarball(tarPath)
 }
 
-func cachedImage(opts *config.KanikoOptions, image string) (v1.Image, error) {
+func cachedImage(ctx context.Context, opts *config.KanikoOptions, image string) (v1.Image, error) {
 	ref, err := name.ParseReference(image, name.WeakValidation)
 	if err != nil {
 		return nil, err
@@ -105,7 +170,7 @@ func cachedImage(opts *config.KanikoOptions, image string) (v1.Image, error) {
 	if d, ok := ref.(name.Digest); ok {
 		cacheKey = d.DigestStr()
 	} else {
-		image, err := remote.RetrieveRemoteImage(image, opts.RegistryOptions, opts.CustomPlatform)
+		image, err := remote.RetrieveRemoteImage(ctx, image, opts.RegistryOptions, opts.CustomPlatform)
 		if err != nil {
 			return nil, err
 		}
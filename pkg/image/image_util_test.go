@@ -18,6 +18,10 @@ package image
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"errors"
+	"path/filepath"
 	"testing"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -52,11 +56,11 @@ func Test_StandardImage(t *testing.T) {
 	defer func() {
 		RetrieveRemoteImage = original
 	}()
-	mock := func(image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+	mock := func(_ context.Context, image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
 		return nil, nil
 	}
 	RetrieveRemoteImage = mock
-	actual, err := RetrieveSourceImage(config.KanikoStage{
+	actual, err := RetrieveSourceImage(context.TODO(), config.KanikoStage{
 		Stage: stages[0],
 	}, &config.KanikoOptions{})
 	testutil.CheckErrorAndDeepEqual(t, false, err, nil, actual)
@@ -67,7 +71,7 @@ func Test_ScratchImage(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	actual, err := RetrieveSourceImage(config.KanikoStage{
+	actual, err := RetrieveSourceImage(context.TODO(), config.KanikoStage{
 		Stage: stages[1],
 	}, &config.KanikoOptions{})
 	expected := empty.Image
@@ -87,7 +91,7 @@ func Test_TarImage(t *testing.T) {
 		return nil, nil
 	}
 	retrieveTarImage = mock
-	actual, err := RetrieveSourceImage(config.KanikoStage{
+	actual, err := RetrieveSourceImage(context.TODO(), config.KanikoStage{
 		BaseImageStoredLocally: true,
 		BaseImageIndex:         0,
 		Stage:                  stages[2],
@@ -100,7 +104,7 @@ func Test_ScratchImageFromMirror(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	actual, err := RetrieveSourceImage(config.KanikoStage{
+	actual, err := RetrieveSourceImage(context.TODO(), config.KanikoStage{
 		Stage: stages[1],
 	}, &config.KanikoOptions{
 		RegistryOptions: config.RegistryOptions{
@@ -123,3 +127,35 @@ func parse(s string) ([]instructions.Stage, error) {
 	}
 	return stages, err
 }
+
+func Test_VerifyBaseImage(t *testing.T) {
+	stages, err := parse(dockerfile)
+	if err != nil {
+		t.Error(err)
+	}
+	originalRetrieve := RetrieveRemoteImage
+	originalVerify := verifyImageSignature
+	defer func() {
+		RetrieveRemoteImage = originalRetrieve
+		verifyImageSignature = originalVerify
+	}()
+	RetrieveRemoteImage = func(_ context.Context, image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		return empty.Image, nil
+	}
+	opts := &config.KanikoOptions{VerifyBaseImageKey: filepath.Join("..", "..", "cosign.pub")}
+
+	var verified string
+	verifyImageSignature = func(_ context.Context, image string, _ v1.Image, _ crypto.PublicKey, _ config.RegistryOptions, _ string) error {
+		verified = image
+		return nil
+	}
+	actual, err := RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, empty.Image, actual)
+	testutil.CheckDeepEqual(t, "gcr.io/distroless/base:latest", verified)
+
+	verifyImageSignature = func(_ context.Context, image string, _ v1.Image, _ crypto.PublicKey, _ config.RegistryOptions, _ string) error {
+		return errors.New("image is not signed with the given key")
+	}
+	_, err = RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckError(t, true, err)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/pkg/errors"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/image/remote"
+)
+
+// RefNameAnnotation is the annotation naming the images of an OCI layout
+const RefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// LayoutRefName returns the name ref is written with to an OCI layout and looked up by
+func LayoutRefName(ref name.Reference) string {
+	return ref.Name()
+}
+
+// LayoutImage returns image from the OCI layout at path, or nil if the layout doesn't have it.
+// Images are found by the name they were annotated with, or by digest. The image for
+// customPlatform is picked out of image indexes.
+func LayoutImage(path string, image string, customPlatform string) (v1.Image, error) {
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	p, err := layout.FromPath(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading OCI layout %s", path)
+	}
+	index, err := p.ImageIndex()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading OCI layout %s", path)
+	}
+	m, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range m.Manifests {
+		if !layoutDescriptorMatches(desc, ref) {
+			continue
+		}
+		switch {
+		case desc.MediaType.IsImage():
+			return index.Image(desc.Digest)
+		case desc.MediaType.IsIndex():
+			child, err := index.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			return platformImage(child, remote.CurrentPlatform(customPlatform))
+		}
+	}
+	return nil, nil
+}
+
+// layoutDescriptorMatches returns true if desc is the descriptor of ref in an OCI layout
+func layoutDescriptorMatches(desc v1.Descriptor, ref name.Reference) bool {
+	if d, ok := ref.(name.Digest); ok {
+		return desc.Digest.String() == d.DigestStr()
+	}
+	refName, ok := desc.Annotations[RefNameAnnotation]
+	if !ok {
+		return false
+	}
+	descRef, err := name.ParseReference(refName, name.WeakValidation)
+	if err != nil {
+		return false
+	}
+	return LayoutRefName(descRef) == LayoutRefName(ref)
+}
+
+// platformImage returns the image of index for platform
+func platformImage(index v1.ImageIndex, platform v1.Platform) (v1.Image, error) {
+	m, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range m.Manifests {
+		if desc.Platform == nil || desc.Platform.OS != platform.OS || desc.Platform.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && desc.Platform.Variant != platform.Variant {
+			continue
+		}
+		return index.Image(desc.Digest)
+	}
+	return nil, fmt.Errorf("no image for platform %s/%s in the image index", platform.OS, platform.Architecture)
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func writeTestLayout(t *testing.T) (string, v1.Image, v1.Image, v1.Image) {
+	dir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := layout.Write(dir, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendImage(app, layout.WithAnnotations(map[string]string{RefNameAnnotation: "gcr.io/foo/app:v1"})); err != nil {
+		t.Fatal(err)
+	}
+
+	amd64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+	if err := p.AppendIndex(index, layout.WithAnnotations(map[string]string{RefNameAnnotation: "base"})); err != nil {
+		t.Fatal(err)
+	}
+	return dir, app, amd64, arm64
+}
+
+func Test_LayoutImage(t *testing.T) {
+	dir, app, amd64, arm64 := writeTestLayout(t)
+	defer os.RemoveAll(dir)
+	appDigest, _ := app.Digest()
+
+	tests := []struct {
+		description    string
+		image          string
+		customPlatform string
+		expected       v1.Image
+		shouldErr      bool
+	}{
+		{description: "by name", image: "gcr.io/foo/app:v1", expected: app},
+		{description: "by digest", image: "gcr.io/foo/app@" + appDigest.String(), expected: app},
+		{description: "other tag", image: "gcr.io/foo/app:v2"},
+		{description: "image index", image: "docker.io/library/base:latest", customPlatform: "linux/arm64", expected: arm64},
+		{description: "image index with the default tag", image: "base", customPlatform: "linux/amd64", expected: amd64},
+		{description: "platform missing from the index", image: "base", customPlatform: "linux/s390x", shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := LayoutImage(dir, test.image, test.customPlatform)
+			testutil.CheckError(t, test.shouldErr, err)
+			if test.expected == nil {
+				if got != nil {
+					t.Errorf("expected no image, got one")
+				}
+				return
+			}
+			wantDigest, _ := test.expected.Digest()
+			gotDigest, err := got.Digest()
+			testutil.CheckErrorAndDeepEqual(t, false, err, wantDigest, gotDigest)
+		})
+	}
+}
+
+func Test_RetrieveSourceImage_FromLayout(t *testing.T) {
+	dir, app, _, _ := writeTestLayout(t)
+	defer os.RemoveAll(dir)
+	stages, err := parse("FROM gcr.io/foo/app:v1\nFROM gcr.io/foo/app:v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := RetrieveRemoteImage
+	defer func() {
+		RetrieveRemoteImage = original
+	}()
+	RetrieveRemoteImage = func(_ context.Context, image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		return nil, errors.New("not in the layout")
+	}
+	opts := &config.KanikoOptions{BaseImageLayout: dir}
+
+	got, err := RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckError(t, false, err)
+	wantDigest, _ := app.Digest()
+	gotDigest, _ := got.Digest()
+	testutil.CheckDeepEqual(t, wantDigest, gotDigest)
+
+	_, err = RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[1]}, opts)
+	testutil.CheckError(t, true, err)
+}
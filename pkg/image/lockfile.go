@@ -0,0 +1,168 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/image/remote"
+)
+
+// Lockfile pins the images a build uses, by reference as written in the Dockerfile
+// once the build args are substituted, to their digest for every platform
+type Lockfile struct {
+	Images map[string]map[string]string `json:"images"`
+}
+
+// Digest returns the digest image is locked to for platform
+func (l *Lockfile) Digest(image string, platform string) (string, error) {
+	digest, ok := l.Images[image][platform]
+	if !ok {
+		return "", fmt.Errorf("image %s for platform %s is missing from the lockfile", image, platform)
+	}
+	return digest, nil
+}
+
+// ReadLockfile reads the lockfile at path
+func ReadLockfile(path string) (*Lockfile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading lockfile")
+	}
+	l := &Lockfile{}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, errors.Wrapf(err, "parsing lockfile %s", path)
+	}
+	return l, nil
+}
+
+// WriteLockfile writes l to path
+func WriteLockfile(path string, l *Lockfile) error {
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "creating lockfile directory")
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// DefaultResolvedImages collects the images of the build as they are resolved.
+var DefaultResolvedImages = &ResolvedImages{}
+
+// ResolvedImages is a set of resolved images which is safe for concurrent use
+type ResolvedImages struct {
+	mu     sync.Mutex
+	images map[string]map[string]string // protected by mu
+}
+
+// Add records that image resolved to digest for platform
+func (r *ResolvedImages) Add(image string, platform string, digest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.images == nil {
+		r.images = map[string]map[string]string{}
+	}
+	if r.images[image] == nil {
+		r.images[image] = map[string]string{}
+	}
+	r.images[image][platform] = digest
+}
+
+// Lockfile returns the lockfile pinning the recorded images
+func (r *ResolvedImages) Lockfile() *Lockfile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := &Lockfile{Images: map[string]map[string]string{}}
+	for image, digests := range r.images {
+		l.Images[image] = map[string]string{}
+		for platform, digest := range digests {
+			l.Images[image][platform] = digest
+		}
+	}
+	return l
+}
+
+// LockedReference returns the reference image is retrieved by: image pinned to the digest
+// of opts.Lockfile, or image itself without a lockfile
+func LockedReference(image string, opts *config.KanikoOptions) (string, error) {
+	if opts.Lockfile == "" {
+		return image, nil
+	}
+	l, err := ReadLockfile(opts.Lockfile)
+	if err != nil {
+		return "", err
+	}
+	digest, err := l.Digest(image, lockPlatform(opts.CustomPlatform))
+	if err != nil {
+		return "", err
+	}
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Digest(digest).String(), nil
+}
+
+// RecordImage records that image resolved to img for --lockfile-out, after checking that
+// img is the image opts.Lockfile pins image to
+func RecordImage(image string, img v1.Image, opts *config.KanikoOptions) error {
+	if opts.Lockfile == "" && opts.LockfileOut == "" {
+		return nil
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	platform := lockPlatform(opts.CustomPlatform)
+	if opts.Lockfile != "" {
+		l, err := ReadLockfile(opts.Lockfile)
+		if err != nil {
+			return err
+		}
+		locked, err := l.Digest(image, platform)
+		if err != nil {
+			return err
+		}
+		if locked != digest.String() {
+			return fmt.Errorf("image %s for platform %s resolved to %s, but the lockfile pins it to %s", image, platform, digest, locked)
+		}
+	}
+	DefaultResolvedImages.Add(image, platform, digest.String())
+	return nil
+}
+
+// lockPlatform returns the platform images are locked for, e.g. linux/arm64/v8
+func lockPlatform(customPlatform string) string {
+	p := remote.CurrentPlatform(customPlatform)
+	platform := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		platform += "/" + p.Variant
+	}
+	return platform
+}
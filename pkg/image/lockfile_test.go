@@ -0,0 +1,126 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func Test_Lockfile_WriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resolved := &ResolvedImages{}
+	resolved.Add("ubuntu:20.04", "linux/amd64", "sha256:aaa")
+	resolved.Add("ubuntu:20.04", "linux/arm64/v8", "sha256:bbb")
+	resolved.Add("gcr.io/foo/bar", "linux/amd64", "sha256:ccc")
+
+	path := filepath.Join(dir, "locks", "kaniko.lock")
+	testutil.CheckNoError(t, WriteLockfile(path, resolved.Lockfile()))
+	l, err := ReadLockfile(path)
+	testutil.CheckErrorAndDeepEqual(t, false, err, resolved.Lockfile(), l)
+
+	digest, err := l.Digest("ubuntu:20.04", "linux/arm64/v8")
+	testutil.CheckErrorAndDeepEqual(t, false, err, "sha256:bbb", digest)
+	_, err = l.Digest("ubuntu:20.04", "linux/s390x")
+	testutil.CheckError(t, true, err)
+	_, err = l.Digest("ubuntu:18.04", "linux/amd64")
+	testutil.CheckError(t, true, err)
+}
+
+func Test_RetrieveSourceImage_Lockfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stages, err := parse("FROM gcr.io/foo/app:v1\nFROM gcr.io/foo/app:v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := img.Digest()
+
+	original := RetrieveRemoteImage
+	originalResolved := DefaultResolvedImages
+	defer func() {
+		RetrieveRemoteImage = original
+		DefaultResolvedImages = originalResolved
+	}()
+	var retrieved []string
+	RetrieveRemoteImage = func(_ context.Context, image string, opts config.RegistryOptions, _ string) (v1.Image, error) {
+		retrieved = append(retrieved, image)
+		return img, nil
+	}
+
+	// --lockfile-out records the digest of the image
+	DefaultResolvedImages = &ResolvedImages{}
+	lockfile := filepath.Join(dir, "kaniko.lock")
+	opts := &config.KanikoOptions{LockfileOut: lockfile, CustomPlatform: "linux/arm64"}
+	_, err = RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, map[string]map[string]string{
+		"gcr.io/foo/app:v1": {"linux/arm64": digest.String()},
+	}, DefaultResolvedImages.Lockfile().Images)
+	testutil.CheckNoError(t, WriteLockfile(lockfile, DefaultResolvedImages.Lockfile()))
+
+	// --lockfile retrieves it by that digest
+	retrieved = nil
+	opts = &config.KanikoOptions{Lockfile: lockfile, CustomPlatform: "linux/arm64"}
+	_, err = RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckNoError(t, err)
+	testutil.CheckDeepEqual(t, []string{"gcr.io/foo/app@" + digest.String()}, retrieved)
+
+	// and fails for images and platforms it doesn't have
+	_, err = RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[1]}, opts)
+	testutil.CheckError(t, true, err)
+	opts.CustomPlatform = "linux/amd64"
+	_, err = RetrieveSourceImage(context.TODO(), config.KanikoStage{Stage: stages[0]}, opts)
+	testutil.CheckError(t, true, err)
+}
+
+func Test_RecordImage_Mismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lockfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockfile := filepath.Join(dir, "kaniko.lock")
+	l := &Lockfile{Images: map[string]map[string]string{"gcr.io/foo/app:v1": {"linux/amd64": "sha256:aaa"}}}
+	testutil.CheckNoError(t, WriteLockfile(lockfile, l))
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &config.KanikoOptions{Lockfile: lockfile, CustomPlatform: "linux/amd64"}
+	testutil.CheckError(t, true, RecordImage("gcr.io/foo/app:v1", img, opts))
+}
@@ -17,16 +17,21 @@ limitations under the License.
 package remote
 
 import (
+	"context"
+	"crypto"
+	"fmt"
 	"runtime"
 	"strings"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/creds"
+	"github.com/GoogleContainerTools/kaniko/pkg/signing"
 	"github.com/GoogleContainerTools/kaniko/pkg/util"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pkg/errors"
 
 	"github.com/sirupsen/logrus"
 )
@@ -36,10 +41,10 @@ var (
 )
 
 // RetrieveRemoteImage retrieves the manifest for the specified image from the specified registry
-func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatform string) (v1.Image, error) {
+func RetrieveRemoteImage(ctx context.Context, image string, opts config.RegistryOptions, customPlatform string) (v1.Image, error) {
 	logrus.Infof("Retrieving image manifest %s", image)
 
-	cachedRemoteImage := manifestCache[image]
+	cachedRemoteImage := manifestCache[manifestCacheKey(image, customPlatform)]
 	if cachedRemoteImage != nil {
 		logrus.Infof("Returning cached image manifest")
 		return cachedRemoteImage, nil
@@ -69,13 +74,13 @@ func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatfo
 			ref := setNewRegistry(ref, newReg)
 
 			logrus.Infof("Retrieving image %s from registry mirror %s", ref, registryMirror)
-			remoteImage, err := remote.Image(ref, remoteOptions(registryMirror, opts, customPlatform)...)
+			remoteImage, err := remote.Image(ref, remoteOptions(ctx, registryMirror, opts, customPlatform)...)
 			if err != nil {
 				logrus.Warnf("Failed to retrieve image %s from registry mirror %s: %s. Will try with the next mirror, or fallback to the default registry.", ref, registryMirror, err)
 				continue
 			}
 
-			manifestCache[image] = remoteImage
+			manifestCache[manifestCacheKey(image, customPlatform)] = remoteImage
 
 			return remoteImage, nil
 		}
@@ -92,15 +97,121 @@ func RetrieveRemoteImage(image string, opts config.RegistryOptions, customPlatfo
 
 	logrus.Infof("Retrieving image %s from registry %s", ref, registryName)
 
-	remoteImage, err := remote.Image(ref, remoteOptions(registryName, opts, customPlatform)...)
+	remoteImage, err := remote.Image(ref, remoteOptions(ctx, registryName, opts, customPlatform)...)
 
 	if remoteImage != nil {
-		manifestCache[image] = remoteImage
+		manifestCache[manifestCacheKey(image, customPlatform)] = remoteImage
 	}
 
 	return remoteImage, err
 }
 
+// VerifySignature checks that the signatures pushed next to image in its repository
+// include one by key, either of the manifest img or of an index image refers to
+// which includes img. image must be the reference img was resolved from.
+func VerifySignature(ctx context.Context, image string, img v1.Image, key crypto.PublicKey, opts config.RegistryOptions, customPlatform string) error {
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return err
+	}
+	registryName := ref.Context().RegistryStr()
+	if opts.InsecurePull || opts.InsecureRegistries.Contains(registryName) {
+		newReg, err := name.NewRegistry(registryName, name.WeakValidation, name.Insecure)
+		if err != nil {
+			return err
+		}
+		ref = setNewRegistry(ref, newReg)
+	}
+	options := remoteOptions(ctx, registryName, opts, customPlatform)
+
+	imgDigest, err := img.Digest()
+	if err != nil {
+		return err
+	}
+	digests := []v1.Hash{imgDigest}
+	indexDigest, err := signedIndex(ref, imgDigest, options)
+	if err != nil {
+		return errors.Wrapf(err, "resolving index of %s", image)
+	}
+	if indexDigest != nil {
+		digests = append(digests, *indexDigest)
+	}
+
+	for _, d := range digests {
+		sigRef := ref.Context().Tag(signing.Tag(d))
+		sigs, err := remote.Image(sigRef, options...)
+		if signing.IsNotFound(err) {
+			logrus.Debugf("No signatures found for %s at %s", image, sigRef)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "retrieving signatures of %s", image)
+		}
+		if err := signing.VerifyImage(sigs, key, d); err != nil {
+			logrus.Debugf("Signatures at %s don't match: %s", sigRef, err)
+			continue
+		}
+		logrus.Infof("Verified signature of %s at %s", image, sigRef)
+		return nil
+	}
+	return fmt.Errorf("image %s is not signed with the given key", image)
+}
+
+// signedIndex returns the digest of the image index ref refers to if it includes the
+// manifest with digest imgDigest, so that a signature of the index covers the manifest.
+// It returns nil if ref refers to that manifest itself or to an index which doesn't include it.
+func signedIndex(ref name.Reference, imgDigest v1.Hash, options []remote.Option) (*v1.Hash, error) {
+	var d v1.Hash
+	if digestRef, ok := ref.(name.Digest); ok {
+		h, err := v1.NewHash(digestRef.DigestStr())
+		if err != nil {
+			return nil, err
+		}
+		d = h
+	} else {
+		desc, err := remote.Head(ref, options...)
+		if err != nil {
+			return nil, err
+		}
+		d = desc.Digest
+	}
+	if d == imgDigest {
+		return nil, nil
+	}
+	// Fetched by digest, so the manifests listed are those of the index which is signed
+	desc, err := remote.Get(ref.Context().Digest(d.String()), options...)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return nil, nil
+	}
+	index, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	im, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range im.Manifests {
+		if m.Digest == imgDigest {
+			return &d, nil
+		}
+	}
+	logrus.Debugf("Index %s of %s doesn't include %s", d, ref, imgDigest)
+	return nil, nil
+}
+
+// manifestCacheKey returns the key under which the manifest of image is cached.
+// The same reference resolves to a different manifest for every platform.
+func manifestCacheKey(image string, customPlatform string) string {
+	if customPlatform == "" {
+		return image
+	}
+	return image + "#" + customPlatform
+}
+
 // normalizeReference adds the library/ prefix to images without it.
 //
 // It is mostly useful when using a registry mirror that is not able to perform
@@ -126,17 +237,18 @@ func setNewRegistry(ref name.Reference, newReg name.Registry) name.Reference {
 	}
 }
 
-func remoteOptions(registryName string, opts config.RegistryOptions, customPlatform string) []remote.Option {
+func remoteOptions(ctx context.Context, registryName string, opts config.RegistryOptions, customPlatform string) []remote.Option {
 	tr := util.MakeTransport(opts, registryName)
 
 	// on which v1.Platform is this currently running?
-	platform := currentPlatform(customPlatform)
+	platform := CurrentPlatform(customPlatform)
 
-	return []remote.Option{remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain()), remote.WithPlatform(platform)}
+	return []remote.Option{remote.WithTransport(tr), remote.WithAuthFromKeychain(creds.GetKeychain()), remote.WithPlatform(platform), remote.WithContext(ctx)}
 }
 
-// CurrentPlatform returns the v1.Platform on which the code runs
-func currentPlatform(customPlatform string) v1.Platform {
+// CurrentPlatform returns the platform described by customPlatform in the
+// os/arch[/variant] format, or the platform kaniko is running on if it is empty.
+func CurrentPlatform(customPlatform string) v1.Platform {
 	if customPlatform != "" {
 		customPlatformArray := strings.Split(customPlatform, "/")
 		imagePlatform := v1.Platform{}
@@ -17,11 +17,24 @@ limitations under the License.
 package remote
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
+	"github.com/GoogleContainerTools/kaniko/pkg/signing"
+	"github.com/GoogleContainerTools/kaniko/testutil"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
@@ -98,13 +111,82 @@ func Test_normalizeReference(t *testing.T) {
 func Test_RetrieveRemoteImage_manifestCache(t *testing.T) {
 	nonExistingImageName := "this_is_a_non_existing_image_reference"
 
-	if _, err := RetrieveRemoteImage(nonExistingImageName, config.RegistryOptions{}, ""); err == nil {
+	if _, err := RetrieveRemoteImage(context.TODO(), nonExistingImageName, config.RegistryOptions{}, ""); err == nil {
 		t.Fatal("Expected call to fail because there is no manifest for this image.")
 	}
 
 	manifestCache[nonExistingImageName] = &mockImage{}
 
-	if image, err := RetrieveRemoteImage(nonExistingImageName, config.RegistryOptions{}, ""); image == nil || err != nil {
+	if image, err := RetrieveRemoteImage(context.TODO(), nonExistingImageName, config.RegistryOptions{}, ""); image == nil || err != nil {
 		t.Fatal("Expected call to succeed because there is a manifest for this image in the cache.")
 	}
 }
+
+func Test_RetrieveRemoteImage_manifestCachePerPlatform(t *testing.T) {
+	image := "this_is_a_non_existing_image_reference_per_platform"
+
+	manifestCache[manifestCacheKey(image, "linux/arm64")] = &mockImage{}
+
+	if image, err := RetrieveRemoteImage(context.TODO(), image, config.RegistryOptions{}, "linux/arm64"); image == nil || err != nil {
+		t.Fatal("Expected call to succeed because there is a manifest for this image and platform in the cache.")
+	}
+	if _, err := RetrieveRemoteImage(context.TODO(), image, config.RegistryOptions{}, "linux/amd64"); err == nil {
+		t.Fatal("Expected call to fail because the cached manifest belongs to another platform.")
+	}
+}
+
+func Test_VerifySignature(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://") + "/foo")
+	testutil.CheckError(t, false, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.CheckError(t, false, err)
+	img, err := random.Image(1024, 1)
+	testutil.CheckError(t, false, err)
+	other, err := random.Image(1024, 1)
+	testutil.CheckError(t, false, err)
+	index := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: img})
+	otherIndex := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: other})
+	testutil.CheckError(t, false, remote.WriteIndex(repo.Tag("index"), index))
+	testutil.CheckError(t, false, remote.WriteIndex(repo.Tag("other"), otherIndex))
+	testutil.CheckError(t, false, remote.Write(repo.Tag("image"), img))
+
+	sign := func(d v1.Hash) {
+		payload, err := signing.NewPayload(repo.Name(), d)
+		testutil.CheckError(t, false, err)
+		sig, err := signing.Sign(key, payload)
+		testutil.CheckError(t, false, err)
+		sigs, err := signing.Append(empty.Image, payload, sig)
+		testutil.CheckError(t, false, err)
+		testutil.CheckError(t, false, remote.Write(repo.Tag(signing.Tag(d)), sigs))
+	}
+	verify := func(ref string, img v1.Image) error {
+		return VerifySignature(context.TODO(), repo.Name()+ref, img, key.Public(), config.RegistryOptions{}, "")
+	}
+
+	// Nothing is signed yet
+	testutil.CheckError(t, true, verify(":image", img))
+	testutil.CheckError(t, true, verify(":index", img))
+
+	// A signature of the index covers the images it includes
+	indexDigest, err := index.Digest()
+	testutil.CheckError(t, false, err)
+	sign(indexDigest)
+	testutil.CheckError(t, false, verify(":index", img))
+	testutil.CheckError(t, false, verify("@"+indexDigest.String(), img))
+	// but not other images, even if they are retrieved by the same reference
+	testutil.CheckError(t, true, verify(":index", other))
+	// nor the images referred to by other references
+	testutil.CheckError(t, true, verify(":image", img))
+	testutil.CheckError(t, true, verify(":other", other))
+
+	// A signature of the image covers it under every reference
+	imgDigest, err := img.Digest()
+	testutil.CheckError(t, false, err)
+	sign(imgDigest)
+	testutil.CheckError(t, false, verify(":image", img))
+	testutil.CheckError(t, false, verify(":other", img))
+	testutil.CheckError(t, true, verify(":other", other))
+}
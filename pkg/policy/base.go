@@ -0,0 +1,155 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+// BaseImagePolicy restricts the images a build may use, in FROM, COPY --from and
+// RUN --mount=type=bind,from=, by registry and repository
+type BaseImagePolicy struct {
+	// Allow lists the patterns of the repositories images may come from, any if it is empty
+	Allow []string `json:"allow"`
+	// Deny lists the patterns of the repositories images may not come from, even if allowed
+	Deny []string `json:"deny"`
+	// RequireDigest requires images to be referenced by digest
+	RequireDigest bool `json:"requireDigest"`
+}
+
+// ReadBaseImagePolicy reads the base image policy at path
+func ReadBaseImagePolicy(path string) (*BaseImagePolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading base image policy")
+	}
+	p := &BaseImagePolicy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, errors.Wrapf(err, "parsing base image policy %s", path)
+	}
+	for _, pattern := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if _, err := matchRepository(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %s in base image policy %s", pattern, path)
+		}
+	}
+	return p, nil
+}
+
+// Violation is an image the instruction of a Dockerfile uses against a policy
+type Violation struct {
+	Instruction string
+	Image       string
+	Reason      string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s %s", v.Instruction, v.Image, v.Reason)
+}
+
+// Violations is the list of violations of a policy, which is an error unless empty
+type Violations []Violation
+
+// Err returns v as an error, or nil if there are no violations
+func (v Violations) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (v Violations) Error() string {
+	lines := []string{"base image policy violated by:"}
+	for _, violation := range v {
+		lines = append(lines, "  "+violation.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Check returns the violations of p by image, used by instruction
+func (p *BaseImagePolicy) Check(image string, instruction string) Violations {
+	violation := func(reason string) Violations {
+		return Violations{{Instruction: instruction, Image: image, Reason: reason}}
+	}
+	ref, err := name.ParseReference(image, name.WeakValidation)
+	if err != nil {
+		return violation(fmt.Sprintf("isn't a valid image reference: %s", err))
+	}
+	repositories := []string{ref.Context().Name()}
+	if ref.Context().RegistryStr() == name.DefaultRegistry {
+		repositories = append(repositories, "docker.io/"+ref.Context().RepositoryStr())
+	}
+
+	var violations Violations
+	if pattern, ok := matchAny(p.Deny, repositories); ok {
+		violations = append(violations, violation(fmt.Sprintf("is denied by the pattern %s", pattern))...)
+	} else if _, ok := matchAny(p.Allow, repositories); len(p.Allow) > 0 && !ok {
+		violations = append(violations, violation("doesn't match any allowed pattern")...)
+	}
+	if _, ok := ref.(name.Digest); p.RequireDigest && !ok {
+		violations = append(violations, violation("isn't referenced by digest")...)
+	}
+	return violations
+}
+
+// CheckBaseImage returns an error listing the violations of the base image policy
+// at policyPath by image, used by instruction. There are none without a policy.
+func CheckBaseImage(policyPath string, image string, instruction string) error {
+	if policyPath == "" {
+		return nil
+	}
+	p, err := ReadBaseImagePolicy(policyPath)
+	if err != nil {
+		return err
+	}
+	return p.Check(image, instruction).Err()
+}
+
+// matchAny returns the first of patterns matching one of repositories
+func matchAny(patterns []string, repositories []string) (string, bool) {
+	for _, pattern := range patterns {
+		for _, repository := range repositories {
+			if ok, _ := matchRepository(pattern, repository); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchRepository returns true if pattern matches repository or one of its parents,
+// so that gcr.io/my-project matches gcr.io/my-project/app, and *.example.com matches
+// every repository of the registries of example.com
+func matchRepository(pattern string, repository string) (bool, error) {
+	for {
+		ok, err := path.Match(pattern, repository)
+		if ok || err != nil {
+			return ok, err
+		}
+		i := strings.LastIndex(repository, "/")
+		if i < 0 {
+			return false, nil
+		}
+		repository = repository[:i]
+	}
+}
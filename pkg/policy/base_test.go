@@ -0,0 +1,129 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func TestBaseImagePolicy_Check(t *testing.T) {
+	digest := "@sha256:" + "0123456789012345678901234567890123456789012345678901234567890123"
+	tests := []struct {
+		description string
+		policy      BaseImagePolicy
+		image       string
+		expected    []string
+	}{
+		{
+			description: "allowed registry",
+			policy:      BaseImagePolicy{Allow: []string{"registry.internal"}},
+			image:       "registry.internal/base/ubuntu:20.04",
+		},
+		{
+			description: "allowed repository",
+			policy:      BaseImagePolicy{Allow: []string{"gcr.io/my-project/*"}},
+			image:       "gcr.io/my-project/app",
+		},
+		{
+			description: "registry not allowed",
+			policy:      BaseImagePolicy{Allow: []string{"registry.internal"}},
+			image:       "ubuntu:20.04",
+			expected:    []string{"doesn't match any allowed pattern"},
+		},
+		{
+			description: "wildcard registry",
+			policy:      BaseImagePolicy{Allow: []string{"*.example.com"}},
+			image:       "eu.example.com/team/app:v1",
+		},
+		{
+			description: "docker hub by its short name",
+			policy:      BaseImagePolicy{Deny: []string{"docker.io"}},
+			image:       "ubuntu",
+			expected:    []string{"is denied by the pattern docker.io"},
+		},
+		{
+			description: "denied repository of an allowed registry",
+			policy:      BaseImagePolicy{Allow: []string{"gcr.io"}, Deny: []string{"gcr.io/untrusted"}},
+			image:       "gcr.io/untrusted/app:v1",
+			expected:    []string{"is denied by the pattern gcr.io/untrusted"},
+		},
+		{
+			description: "digest required",
+			policy:      BaseImagePolicy{RequireDigest: true},
+			image:       "gcr.io/my-project/app:v1",
+			expected:    []string{"isn't referenced by digest"},
+		},
+		{
+			description: "digest given",
+			policy:      BaseImagePolicy{RequireDigest: true},
+			image:       "gcr.io/my-project/app" + digest,
+		},
+		{
+			description: "several violations",
+			policy:      BaseImagePolicy{Allow: []string{"registry.internal"}, RequireDigest: true},
+			image:       "gcr.io/my-project/app:v1",
+			expected:    []string{"doesn't match any allowed pattern", "isn't referenced by digest"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var reasons []string
+			for _, v := range test.policy.Check(test.image, "FROM "+test.image) {
+				reasons = append(reasons, v.Reason)
+			}
+			testutil.CheckDeepEqual(t, test.expected, reasons)
+		})
+	}
+}
+
+func TestReadBaseImagePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.json")
+	ioutil.WriteFile(valid, []byte(`{"allow": ["registry.internal"], "deny": ["registry.internal/legacy"], "requireDigest": true}`), 0644)
+	p, err := ReadBaseImagePolicy(valid)
+	testutil.CheckErrorAndDeepEqual(t, false, err, &BaseImagePolicy{
+		Allow:         []string{"registry.internal"},
+		Deny:          []string{"registry.internal/legacy"},
+		RequireDigest: true,
+	}, p)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(invalid, []byte(`{"allow": ["registry.internal/[a"]}`), 0644)
+	_, err = ReadBaseImagePolicy(invalid)
+	testutil.CheckError(t, true, err)
+}
+
+func TestViolations_Error(t *testing.T) {
+	testutil.CheckDeepEqual(t, nil, Violations{}.Err())
+	v := Violations{
+		{Instruction: "FROM ubuntu AS build", Image: "ubuntu", Reason: "doesn't match any allowed pattern"},
+		{Instruction: "COPY --from=alpine /a /a", Image: "alpine", Reason: "isn't referenced by digest"},
+	}
+	testutil.CheckDeepEqual(t, `base image policy violated by:
+  FROM ubuntu AS build: ubuntu doesn't match any allowed pattern
+  COPY --from=alpine /a /a: alpine isn't referenced by digest`, v.Err().Error())
+}
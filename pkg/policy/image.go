@@ -0,0 +1,318 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+)
+
+// RuleType is the check a Rule of an ImagePolicy makes
+type RuleType string
+
+// The rules an ImagePolicy can have
+const (
+	// NonRootUser requires USER to be set to another user than root
+	NonRootUser RuleType = "non-root-user"
+	// Healthcheck requires a HEALTHCHECK
+	Healthcheck RuleType = "healthcheck"
+	// RequiredLabels requires the Labels of the rule to be set
+	RequiredLabels RuleType = "required-labels"
+	// NoSetuid forbids files with the setuid bit
+	NoSetuid RuleType = "no-setuid"
+	// ForbiddenPaths forbids files at or under the Paths of the rule
+	ForbiddenPaths RuleType = "forbidden-paths"
+	// MaxSize limits the size of the compressed layers of the image to MaxSize bytes
+	MaxSize RuleType = "max-size"
+)
+
+// The actions taken when a rule fails
+const (
+	ActionFail = "fail"
+	ActionWarn = "warn"
+)
+
+// ImagePolicy is a set of rules the built image must follow before it is pushed
+type ImagePolicy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule is a check of the config or the filesystem of the image
+type Rule struct {
+	Type RuleType `json:"type"`
+	// Action is ActionFail, the default, to fail the build or ActionWarn to log a warning
+	Action  string   `json:"action,omitempty"`
+	Labels  []string `json:"labels,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+	MaxSize int64    `json:"maxSize,omitempty"`
+}
+
+// ReadImagePolicy reads the image policy at path
+func ReadImagePolicy(path string) (*ImagePolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading image policy")
+	}
+	p := &ImagePolicy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, errors.Wrapf(err, "parsing image policy %s", path)
+	}
+	for i, r := range p.Rules {
+		switch r.Action {
+		case "":
+			p.Rules[i].Action = ActionFail
+		case ActionFail, ActionWarn:
+		default:
+			return nil, fmt.Errorf("rule %s of image policy %s has action %s, expected %s or %s", r.Type, path, r.Action, ActionFail, ActionWarn)
+		}
+		switch r.Type {
+		case NonRootUser, Healthcheck, NoSetuid:
+		case RequiredLabels:
+			if len(r.Labels) == 0 {
+				return nil, fmt.Errorf("rule %s of image policy %s needs labels", r.Type, path)
+			}
+		case ForbiddenPaths:
+			if len(r.Paths) == 0 {
+				return nil, fmt.Errorf("rule %s of image policy %s needs paths", r.Type, path)
+			}
+		case MaxSize:
+			if r.MaxSize <= 0 {
+				return nil, fmt.Errorf("rule %s of image policy %s needs maxSize", r.Type, path)
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %s in image policy %s", r.Type, path)
+		}
+	}
+	return p, nil
+}
+
+// Result is the outcome of a rule for an image
+type Result struct {
+	Rule    RuleType `json:"rule"`
+	Action  string   `json:"action"`
+	Passed  bool     `json:"passed"`
+	Message string   `json:"message,omitempty"`
+	// Files are the paths which failed the rule
+	Files []string `json:"files,omitempty"`
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("%s: %s", r.Rule, r.Message)
+	if len(r.Files) > 0 {
+		s += ": " + strings.Join(r.Files, ", ")
+	}
+	return s
+}
+
+// Report is the outcome of an image policy for the image of a stage
+type Report struct {
+	Stage    string   `json:"stage,omitempty"`
+	Platform string   `json:"platform,omitempty"`
+	Results  []Result `json:"results"`
+}
+
+// Failed returns the results of the rules which failed with the given action
+func (r *Report) Failed(action string) []Result {
+	var failed []Result
+	for _, result := range r.Results {
+		if !result.Passed && result.Action == action {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Err returns an error listing the rules which failed the build, or nil if there are none
+func (r *Report) Err() error {
+	failed := r.Failed(ActionFail)
+	if len(failed) == 0 {
+		return nil
+	}
+	lines := []string{"image policy violated by:"}
+	for _, result := range failed {
+		lines = append(lines, "  "+result.String())
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// Evaluate checks the image with the config cf, the compressed size size and the
+// filesystem fs against p. fs is the tar stream of the flattened filesystem of the
+// image, it is only read if a rule checks files.
+func (p *ImagePolicy) Evaluate(cf *v1.ConfigFile, size int64, fs io.Reader) (*Report, error) {
+	t := timing.Start("Image Policy")
+	defer timing.DefaultRun.Stop(t)
+
+	var files fileFindings
+	for _, r := range p.Rules {
+		if r.Type == NoSetuid || r.Type == ForbiddenPaths {
+			var err error
+			if files, err = findFiles(fs, p.Rules); err != nil {
+				return nil, errors.Wrap(err, "reading the filesystem of the image")
+			}
+			break
+		}
+	}
+
+	report := &Report{}
+	for i, r := range p.Rules {
+		result := Result{Rule: r.Type, Action: r.Action, Passed: true}
+		switch r.Type {
+		case NonRootUser:
+			if isRootUser(cf.Config.User) {
+				result.Passed = false
+				result.Message = fmt.Sprintf("USER is %q, the image runs as root", cf.Config.User)
+			}
+		case Healthcheck:
+			hc := cf.Config.Healthcheck
+			if hc == nil || len(hc.Test) == 0 || hc.Test[0] == "NONE" {
+				result.Passed = false
+				result.Message = "the image has no HEALTHCHECK"
+			}
+		case RequiredLabels:
+			var missing []string
+			for _, l := range r.Labels {
+				if _, ok := cf.Config.Labels[l]; !ok {
+					missing = append(missing, l)
+				}
+			}
+			if len(missing) > 0 {
+				result.Passed = false
+				result.Message = fmt.Sprintf("labels %s are missing", strings.Join(missing, ", "))
+			}
+		case NoSetuid:
+			if len(files.setuid) > 0 {
+				result.Passed = false
+				result.Message = "files have the setuid bit"
+				result.Files = files.setuid
+			}
+		case ForbiddenPaths:
+			if len(files.forbidden[i]) > 0 {
+				result.Passed = false
+				result.Message = fmt.Sprintf("files are under %s", strings.Join(r.Paths, ", "))
+				result.Files = files.forbidden[i]
+			}
+		case MaxSize:
+			if size > r.MaxSize {
+				result.Passed = false
+				result.Message = fmt.Sprintf("the image is %d bytes, over the maximum of %d", size, r.MaxSize)
+			}
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// isRootUser returns true if the USER user runs as root, which it does if it isn't set
+func isRootUser(user string) bool {
+	name := strings.SplitN(user, ":", 2)[0]
+	return name == "" || name == "root" || name == "0"
+}
+
+// fileFindings are the files of the image which the rules of a policy are concerned with
+type fileFindings struct {
+	setuid []string
+	// forbidden are the files under the paths of the ForbiddenPaths rules, by rule index
+	forbidden map[int][]string
+}
+
+// findFiles reads the tar stream fs of a filesystem for the files which fail the
+// NoSetuid and ForbiddenPaths rules
+func findFiles(fs io.Reader, rules []Rule) (fileFindings, error) {
+	findings := fileFindings{forbidden: map[int][]string{}}
+	tr := tar.NewReader(fs)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return findings, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		location := filepath.Clean("/" + hdr.Name)
+		if hdr.FileInfo().Mode()&os.ModeSetuid != 0 {
+			findings.setuid = append(findings.setuid, location)
+		}
+		for i, r := range rules {
+			if r.Type != ForbiddenPaths {
+				continue
+			}
+			for _, p := range r.Paths {
+				if util.HasFilepathPrefix(location, filepath.Clean("/"+p), false) {
+					findings.forbidden[i] = append(findings.forbidden[i], location)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(findings.setuid)
+	for _, files := range findings.forbidden {
+		sort.Strings(files)
+	}
+	return findings, nil
+}
+
+// DefaultReports collects the reports of the images of the build as they are evaluated.
+var DefaultReports = &Reports{}
+
+// Reports is a list of reports which is safe for concurrent use
+type Reports struct {
+	mu      sync.Mutex
+	reports []*Report // protected by mu
+}
+
+// Add records r
+func (r *Reports) Add(report *Report) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, report)
+}
+
+// List returns the recorded reports in the order they were added
+func (r *Reports) List() []*Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Report{}, r.reports...)
+}
+
+// WriteReports writes reports as JSON to path
+func WriteReports(path string, reports []*Report) error {
+	b, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "creating image policy report directory")
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
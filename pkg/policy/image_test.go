@@ -0,0 +1,159 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func TestReadImagePolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		description string
+		policy      string
+		expected    *ImagePolicy
+		shouldErr   bool
+	}{
+		{
+			description: "fail by default",
+			policy:      `{"rules": [{"type": "non-root-user"}, {"type": "healthcheck", "action": "warn"}]}`,
+			expected: &ImagePolicy{Rules: []Rule{
+				{Type: NonRootUser, Action: ActionFail},
+				{Type: Healthcheck, Action: ActionWarn},
+			}},
+		},
+		{description: "unknown rule", policy: `{"rules": [{"type": "no-curl"}]}`, shouldErr: true},
+		{description: "unknown action", policy: `{"rules": [{"type": "healthcheck", "action": "ignore"}]}`, shouldErr: true},
+		{description: "labels missing", policy: `{"rules": [{"type": "required-labels"}]}`, shouldErr: true},
+		{description: "paths missing", policy: `{"rules": [{"type": "forbidden-paths"}]}`, shouldErr: true},
+		{description: "max size missing", policy: `{"rules": [{"type": "max-size"}]}`, shouldErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			path := filepath.Join(dir, "policy.json")
+			ioutil.WriteFile(path, []byte(test.policy), 0644)
+			p, err := ReadImagePolicy(path)
+			testutil.CheckError(t, test.shouldErr, err)
+			if !test.shouldErr {
+				testutil.CheckDeepEqual(t, test.expected, p)
+			}
+		})
+	}
+}
+
+// filesystem returns the tar stream of a filesystem with the files, by their modes
+func filesystem(t *testing.T, files map[string]int64) io.Reader {
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, path := range paths {
+		content := []byte("abc")
+		if err := tw.WriteHeader(&tar.Header{Name: path, Mode: files[path], Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestImagePolicy_Evaluate(t *testing.T) {
+	files := map[string]int64{
+		"usr/bin/su":            0755 | 04000,
+		"usr/bin/ls":            0755,
+		"root/.cache/pip/wheel": 0644,
+		"app/main":              0755,
+	}
+
+	p := &ImagePolicy{Rules: []Rule{
+		{Type: NonRootUser, Action: ActionFail},
+		{Type: Healthcheck, Action: ActionWarn},
+		{Type: RequiredLabels, Action: ActionFail, Labels: []string{"maintainer", "version"}},
+		{Type: NoSetuid, Action: ActionFail},
+		{Type: ForbiddenPaths, Action: ActionFail, Paths: []string{"/root/.cache", "/tmp"}},
+		{Type: MaxSize, Action: ActionFail, MaxSize: 1000},
+	}}
+
+	t.Run("failing", func(t *testing.T) {
+		cf := &v1.ConfigFile{Config: v1.Config{User: "root:root", Labels: map[string]string{"version": "1"}}}
+		report, err := p.Evaluate(cf, 2000, filesystem(t, files))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, []Result{
+			{Rule: NonRootUser, Action: ActionFail, Message: `USER is "root:root", the image runs as root`},
+			{Rule: RequiredLabels, Action: ActionFail, Message: "labels maintainer are missing"},
+			{Rule: NoSetuid, Action: ActionFail, Message: "files have the setuid bit", Files: []string{"/usr/bin/su"}},
+			{Rule: ForbiddenPaths, Action: ActionFail, Message: "files are under /root/.cache, /tmp", Files: []string{"/root/.cache/pip/wheel"}},
+			{Rule: MaxSize, Action: ActionFail, Message: "the image is 2000 bytes, over the maximum of 1000"},
+		}, report.Failed(ActionFail))
+		testutil.CheckDeepEqual(t, []Result{
+			{Rule: Healthcheck, Action: ActionWarn, Message: "the image has no HEALTHCHECK"},
+		}, report.Failed(ActionWarn))
+		testutil.CheckError(t, true, report.Err())
+	})
+
+	t.Run("passing", func(t *testing.T) {
+		files["usr/bin/su"] = 0755
+		delete(files, "root/.cache/pip/wheel")
+		cf := &v1.ConfigFile{Config: v1.Config{
+			User:        "app",
+			Healthcheck: &v1.HealthConfig{Test: []string{"CMD", "/app/main", "health"}},
+			Labels:      map[string]string{"maintainer": "team", "version": "1"},
+		}}
+		report, err := p.Evaluate(cf, 500, filesystem(t, files))
+		testutil.CheckNoError(t, err)
+		testutil.CheckDeepEqual(t, 6, len(report.Results))
+		testutil.CheckDeepEqual(t, 0, len(report.Failed(ActionFail))+len(report.Failed(ActionWarn)))
+		testutil.CheckNoError(t, report.Err())
+	})
+}
+
+func TestImagePolicy_Evaluate_unreadableFilesystem(t *testing.T) {
+	p := &ImagePolicy{Rules: []Rule{{Type: NoSetuid, Action: ActionFail}}}
+	_, err := p.Evaluate(&v1.ConfigFile{}, 0, strings.NewReader("not a tarball"))
+	testutil.CheckError(t, true, err)
+}
+
+func Test_isRootUser(t *testing.T) {
+	for user, expected := range map[string]bool{"": true, "root": true, "0": true, "0:0": true, "app": false, "1000:1000": false} {
+		testutil.CheckDeepEqual(t, expected, isRootUser(user))
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/artifact"
+	"github.com/GoogleContainerTools/kaniko/pkg/signing"
+)
+
+// EnvelopeMediaType is the media type of the DSSE envelope wrapping the statement
+// when it is pushed to a registry
+const EnvelopeMediaType = "application/vnd.dsse.envelope.v1+json"
+
+// Envelope is a DSSE envelope, see https://github.com/secure-systems-lab/dsse
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// EnvelopeSignature is a signature of the payload of an Envelope
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// NewEnvelope returns the DSSE envelope of the statement, signed by signer unless it is nil
+func NewEnvelope(s *Statement, signer crypto.Signer) (*Envelope, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	e := &Envelope{
+		PayloadType: MediaType,
+		Payload:     base64.StdEncoding.EncodeToString(b),
+		Signatures:  []EnvelopeSignature{},
+	}
+	if signer != nil {
+		sig, err := signing.Sign(signer, PAE(MediaType, b))
+		if err != nil {
+			return nil, err
+		}
+		e.Signatures = append(e.Signatures, EnvelopeSignature{Sig: sig})
+	}
+	return e, nil
+}
+
+// PAE returns the pre-authentication encoding of payload, which is what DSSE signs
+func PAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// Image returns an OCI artifact with the DSSE envelope of the statement, signed by
+// signer unless it is nil, as its only layer, like cosign attestations
+func Image(s *Statement, signer crypto.Signer) (v1.Image, error) {
+	e, err := NewEnvelope(s, signer)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return artifact.Append(empty.Image, b, EnvelopeMediaType, map[string]string{"predicateType": s.PredicateType})
+}
+
+// Tag returns the tag the provenance of the image with the given digest is
+// pushed with, next to the image in the same repository
+func Tag(digest v1.Hash) string {
+	return artifact.Tag(digest, "att")
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/version"
+)
+
+const (
+	// StatementType is the type of an in-toto statement
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	// PredicateType is the type of the SLSA provenance predicate
+	PredicateType = "https://slsa.dev/provenance/v0.2"
+	// BuildType describes how kaniko turns the invocation parameters into the subject
+	BuildType = "https://github.com/GoogleContainerTools/kaniko/build@v1"
+	// BuilderID identifies kaniko as the builder
+	BuilderID = "https://github.com/GoogleContainerTools/kaniko"
+	// MediaType is the media type of the statement when it is pushed to a registry
+	MediaType = "application/vnd.in-toto+json"
+
+	redacted = "<redacted>"
+)
+
+// secretArg matches the names of build args whose values must not end up in the provenance
+var secretArg = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private|api_?key|auth)`)
+
+// Statement is an in-toto statement with a SLSA provenance predicate
+type Statement struct {
+	Type          string    `json:"_type"`
+	PredicateType string    `json:"predicateType"`
+	Subject       []Subject `json:"subject"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+// DigestSet maps a hash algorithm to the hex encoded digest
+type DigestSet map[string]string
+
+// Subject is an artifact the statement is about
+type Subject struct {
+	Name   string    `json:"name"`
+	Digest DigestSet `json:"digest"`
+}
+
+// Predicate is the SLSA provenance of the subjects
+type Predicate struct {
+	Builder    Builder    `json:"builder"`
+	BuildType  string     `json:"buildType"`
+	Invocation Invocation `json:"invocation"`
+	Metadata   Metadata   `json:"metadata"`
+	Materials  []Material `json:"materials"`
+}
+
+// Builder identifies the builder
+type Builder struct {
+	ID string `json:"id"`
+}
+
+// Invocation describes how the build was started
+type Invocation struct {
+	ConfigSource ConfigSource      `json:"configSource"`
+	Parameters   Parameters        `json:"parameters"`
+	Environment  map[string]string `json:"environment"`
+}
+
+// ConfigSource is the build context and the Dockerfile in it
+type ConfigSource struct {
+	URI        string    `json:"uri,omitempty"`
+	Digest     DigestSet `json:"digest,omitempty"`
+	EntryPoint string    `json:"entryPoint,omitempty"`
+}
+
+// Parameters are the user supplied options which influence the build result
+type Parameters struct {
+	BuildArgs map[string]string `json:"buildArgs,omitempty"`
+	Target    string            `json:"target,omitempty"`
+	Platforms []string          `json:"platforms,omitempty"`
+}
+
+// Metadata holds the timestamps and properties of the build
+type Metadata struct {
+	BuildStartedOn  *time.Time   `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn *time.Time   `json:"buildFinishedOn,omitempty"`
+	Completeness    Completeness `json:"completeness"`
+	Reproducible    bool         `json:"reproducible"`
+}
+
+// Completeness states which parts of the provenance are known to be complete
+type Completeness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+// Material is an input of the build
+type Material struct {
+	URI    string    `json:"uri"`
+	Digest DigestSet `json:"digest"`
+}
+
+// Build is everything about a build the provenance is generated from
+type Build struct {
+	Source     ConfigSource
+	BuildArgs  []string
+	Target     string
+	Platforms  []string
+	StartedOn  time.Time
+	FinishedOn time.Time
+	// Reproducible is true if the build was run with --reproducible
+	Reproducible bool
+}
+
+// DefaultMaterials collects the materials of the build as they are resolved.
+var DefaultMaterials = &Materials{}
+
+// Materials is a set of build inputs which is safe for concurrent use
+type Materials struct {
+	mu        sync.Mutex
+	materials map[string]Material // protected by mu
+}
+
+// Add records the input uri with the given digest, e.g. "sha256:abc"
+func (m *Materials) Add(uri string, digest string) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.materials == nil {
+		m.materials = map[string]Material{}
+	}
+	// The same image resolves to a different digest for every platform
+	m.materials[uri+"@"+digest] = Material{URI: uri, Digest: DigestSet{parts[0]: parts[1]}}
+}
+
+// List returns the recorded materials sorted by uri and digest
+func (m *Materials) List() []Material {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.materials))
+	for k := range m.materials {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	materials := make([]Material, 0, len(keys))
+	for _, k := range keys {
+		materials = append(materials, m.materials[k])
+	}
+	return materials
+}
+
+// AddMaterial records an input of the build in DefaultMaterials
+func AddMaterial(uri string, digest string) {
+	DefaultMaterials.Add(uri, digest)
+}
+
+// NewStatement returns the provenance of the image with the given digest,
+// published under the subject names, built by b from materials
+func NewStatement(digest v1.Hash, names []string, b Build, materials []Material) *Statement {
+	s := &Statement{
+		Type:          StatementType,
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			Builder:   Builder{ID: BuilderID},
+			BuildType: BuildType,
+			Invocation: Invocation{
+				ConfigSource: b.Source,
+				Parameters: Parameters{
+					BuildArgs: RedactBuildArgs(b.BuildArgs),
+					Target:    b.Target,
+					Platforms: b.Platforms,
+				},
+				Environment: map[string]string{
+					"executorVersion": version.Version(),
+				},
+			},
+			Metadata: Metadata{
+				Completeness: Completeness{
+					Parameters: true,
+					// Files of the build context and downloads made by RUN are not recorded
+					Materials: false,
+				},
+				Reproducible: b.Reproducible,
+			},
+			Materials: materials,
+		},
+	}
+	if !b.StartedOn.IsZero() {
+		s.Predicate.Metadata.BuildStartedOn = &b.StartedOn
+	}
+	if !b.FinishedOn.IsZero() {
+		s.Predicate.Metadata.BuildFinishedOn = &b.FinishedOn
+	}
+	for _, n := range names {
+		s.Subject = append(s.Subject, Subject{Name: n, Digest: DigestSet{digest.Algorithm: digest.Hex}})
+	}
+	return s
+}
+
+// RedactBuildArgs turns key=value build args into a map, replacing the values
+// of args whose names look like they hold a secret
+func RedactBuildArgs(args []string) map[string]string {
+	if len(args) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(args))
+	for _, a := range args {
+		parts := strings.SplitN(a, "=", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		if secretArg.MatchString(parts[0]) {
+			value = redacted
+		}
+		m[parts[0]] = value
+	}
+	return m
+}
+
+// SanitizeURI removes credentials from a build context uri
+func SanitizeURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.User == nil {
+		return uri
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/version"
+)
+
+// The supported SBOM formats
+const (
+	FormatSPDX      = "spdx"
+	FormatCycloneDX = "cyclonedx"
+)
+
+// For testing
+var currentTimeFunc = time.Now
+
+// ValidFormat returns an error if format is not a supported SBOM format
+func ValidFormat(format string) error {
+	switch format {
+	case FormatSPDX, FormatCycloneDX:
+		return nil
+	}
+	return fmt.Errorf("unsupported SBOM format %q, must be %s or %s", format, FormatSPDX, FormatCycloneDX)
+}
+
+// MediaType returns the media type of documents in the given format
+func MediaType(format string) types.MediaType {
+	if format == FormatCycloneDX {
+		return "application/vnd.cyclonedx+json"
+	}
+	return "application/spdx+json"
+}
+
+// Document renders the packages of the image with the given name and digest
+// as a JSON document in the given format
+func Document(format string, name string, digest v1.Hash, pkgs []Package) ([]byte, error) {
+	switch format {
+	case FormatSPDX:
+		return json.MarshalIndent(spdxDocument(name, digest, pkgs), "", "  ")
+	case FormatCycloneDX:
+		return json.MarshalIndent(cycloneDXDocument(name, digest, pkgs), "", "  ")
+	}
+	return nil, ValidFormat(format)
+}
+
+type spdx struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	DocumentDescribes []string           `json:"documentDescribes"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	SourceInfo       string            `json:"sourceInfo,omitempty"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+func spdxDocument(name string, digest v1.Hash, pkgs []Package) spdx {
+	const imageID = "SPDXRef-Image"
+	doc := spdx{
+		SPDXVersion:       "SPDX-2.2",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: fmt.Sprintf("https://github.com/GoogleContainerTools/kaniko/spdx/%s@%s", name, digest),
+		CreationInfo: spdxCreationInfo{
+			Created:  currentTimeFunc().UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: kaniko-" + version.Version()},
+		},
+		DocumentDescribes: []string{imageID},
+		Packages: []spdxPackage{{
+			Name:             name,
+			SPDXID:           imageID,
+			VersionInfo:      digest.String(),
+			DownloadLocation: "NOASSERTION",
+			Checksums:        []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: digest.Hex}},
+		}},
+	}
+	for i, p := range pkgs {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i)
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             p.Name,
+			SPDXID:           id,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			SourceInfo:       "found in " + p.Location,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE_MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  p.PURL(),
+			}},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      imageID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return doc
+}
+
+type cycloneDX struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     []cycloneDXTool    `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func cycloneDXDocument(name string, digest v1.Hash, pkgs []Package) cycloneDX {
+	doc := cycloneDX{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: currentTimeFunc().UTC().Format(time.RFC3339),
+			Tools:     []cycloneDXTool{{Name: "kaniko", Version: version.Version()}},
+			Component: cycloneDXComponent{Type: "container", Name: name, Version: digest.String()},
+		},
+		Components: []cycloneDXComponent{},
+	}
+	for _, p := range pkgs {
+		doc.Components = append(doc.Components, cycloneDXComponent{
+			Type:       "library",
+			Name:       p.Name,
+			Version:    p.Version,
+			PURL:       p.PURL(),
+			Properties: []cycloneDXProperty{{Name: "kaniko:location", Value: p.Location}},
+		})
+	}
+	return doc
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// requirement matches a pinned requirement like "requests==2.25.1"
+var requirement = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*===?\s*([^\s;#]+)`)
+
+// gemSpec matches a top level gem of the GEM specs section like "    rake (13.0.3)"
+var gemSpec = regexp.MustCompile(`^    ([^\s(]+) \(([^)]+)\)$`)
+
+func readPackageLock(path string, location string, _ string) ([]Package, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	type dependency struct {
+		Version      string                `json:"version"`
+		Link         bool                  `json:"link"`
+		Dependencies map[string]dependency `json:"dependencies"`
+	}
+	var lock struct {
+		Packages     map[string]dependency `json:"packages"`
+		Dependencies map[string]dependency `json:"dependencies"`
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, err
+	}
+
+	var pkgs []Package
+	if len(lock.Packages) > 0 {
+		// lockfileVersion 2 and later, keyed by the install path
+		for key, dep := range lock.Packages {
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || dep.Link || dep.Version == "" {
+				continue
+			}
+			pkgs = append(pkgs, Package{Name: key[i+len("node_modules/"):], Version: dep.Version, Type: "npm", Location: location})
+		}
+		return pkgs, nil
+	}
+	var walk func(deps map[string]dependency)
+	walk = func(deps map[string]dependency) {
+		for name, dep := range deps {
+			if dep.Version != "" {
+				pkgs = append(pkgs, Package{Name: name, Version: dep.Version, Type: "npm", Location: location})
+			}
+			walk(dep.Dependencies)
+		}
+	}
+	walk(lock.Dependencies)
+	return pkgs, nil
+}
+
+func readGoSum(path string, location string, _ string) ([]Package, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var pkgs []Package
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		if seen[fields[0]+"@"+fields[1]] {
+			continue
+		}
+		seen[fields[0]+"@"+fields[1]] = true
+		pkgs = append(pkgs, Package{Name: fields[0], Version: fields[1], Type: "golang", Location: location})
+	}
+	return pkgs, nil
+}
+
+func readRequirements(path string, location string, _ string) ([]Package, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	for _, line := range strings.Split(string(content), "\n") {
+		m := requirement.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		pkgs = append(pkgs, Package{Name: strings.ToLower(m[1]), Version: m[3], Type: "pypi", Location: location})
+	}
+	return pkgs, nil
+}
+
+func readPipfileLock(path string, location string, _ string) ([]Package, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock struct {
+		Default map[string]struct {
+			Version string `json:"version"`
+		} `json:"default"`
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	for name, dep := range lock.Default {
+		pkgs = append(pkgs, Package{Name: strings.ToLower(name), Version: strings.TrimLeft(dep.Version, "="), Type: "pypi", Location: location})
+	}
+	return pkgs, nil
+}
+
+// readTomlPackages reads the [[package]] tables of poetry.lock and Cargo.lock
+func readTomlPackages(purlType string) reader {
+	return func(path string, location string, _ string) ([]Package, error) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var pkgs []Package
+		var current *Package
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") {
+				if current != nil && current.Name != "" {
+					pkgs = append(pkgs, *current)
+				}
+				current = nil
+				if line == "[[package]]" {
+					current = &Package{Type: purlType, Location: location}
+				}
+				continue
+			}
+			if current == nil {
+				continue
+			}
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			value := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+			switch strings.TrimSpace(parts[0]) {
+			case "name":
+				current.Name = value
+			case "version":
+				current.Version = value
+			}
+		}
+		if current != nil && current.Name != "" {
+			pkgs = append(pkgs, *current)
+		}
+		return pkgs, nil
+	}
+}
+
+func readGemfileLock(path string, location string, _ string) ([]Package, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	section := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" && !strings.HasPrefix(line, " ") {
+			section = line
+			continue
+		}
+		if section != "GEM" {
+			continue
+		}
+		if m := gemSpec.FindStringSubmatch(line); m != nil {
+			pkgs = append(pkgs, Package{Name: m[1], Version: m[2], Type: "gem", Location: location})
+		}
+	}
+	return pkgs, nil
+}
+
+func readComposerLock(path string, location string, _ string) ([]Package, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lock struct {
+		Packages []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	for _, p := range lock.Packages {
+		pkgs = append(pkgs, Package{Name: p.Name, Version: p.Version, Type: "composer", Location: location})
+	}
+	return pkgs, nil
+}
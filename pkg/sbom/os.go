@@ -0,0 +1,217 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rpmManifest is written by distroless style images which ship without the rpm binary
+const rpmManifest = "/var/lib/rpmmanifest/container-manifest-2"
+
+// osRelease returns the ID of the distribution installed at root
+func osRelease(root string) string {
+	for _, p := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		content, err := ioutil.ReadFile(filepath.Join(root, p))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.HasPrefix(line, "ID=") {
+				return strings.Trim(strings.TrimPrefix(line, "ID="), `"'`)
+			}
+		}
+	}
+	return ""
+}
+
+// paragraphs splits a file into its blank line separated "Key: value" paragraphs
+func paragraphs(path string, separator string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var result []map[string]string
+	current := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				result = append(result, current)
+				current = map[string]string{}
+			}
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			// continuation of a multi line value
+			continue
+		}
+		parts := strings.SplitN(line, separator, 2)
+		if len(parts) == 2 {
+			current[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+	return result, scanner.Err()
+}
+
+func readDpkgStatus(path string, location string, distro string) ([]Package, error) {
+	ps, err := paragraphs(path, ":")
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	for _, p := range ps {
+		if status, ok := p["Status"]; ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		if p["Package"] == "" {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Name:      p["Package"],
+			Version:   p["Version"],
+			Type:      "deb",
+			Namespace: distro,
+			Arch:      p["Architecture"],
+			Location:  location,
+		})
+	}
+	return pkgs, nil
+}
+
+func readDpkgStatusDir(path string, location string, distro string) ([]Package, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		found, err := readDpkgStatus(filepath.Join(path, f.Name()), filepath.Join(location, f.Name()), distro)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, found...)
+	}
+	return pkgs, nil
+}
+
+func readApkInstalled(path string, location string, distro string) ([]Package, error) {
+	ps, err := paragraphs(path, ":")
+	if err != nil {
+		return nil, err
+	}
+	if distro == "" {
+		distro = "alpine"
+	}
+	var pkgs []Package
+	for _, p := range ps {
+		if p["P"] == "" {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Name:      p["P"],
+			Version:   p["V"],
+			Type:      "apk",
+			Namespace: distro,
+			Arch:      p["A"],
+			Location:  location,
+		})
+	}
+	return pkgs, nil
+}
+
+// readRpmDatabase queries the rpm database with the rpm binary of the image,
+// the database formats can't be read without it.
+func readRpmDatabase(path string, location string, distro string) ([]Package, error) {
+	root := strings.TrimSuffix(path, location)
+	if root == "" {
+		root = "/"
+	}
+	rpm := filepath.Join(root, "usr/bin/rpm")
+	if _, err := os.Stat(rpm); err != nil {
+		if rpm, err = exec.LookPath("rpm"); err != nil {
+			return readRpmManifest(root, distro)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(rpm, "--root", root, "-qa", "--qf", `%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\n`)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		logrus.Warnf("Unable to query the rpm database: %s: %s", err, stderr.String())
+		return readRpmManifest(root, distro)
+	}
+	var pkgs []Package
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		pkgs = append(pkgs, rpmPackage(fields[0], fields[1], fields[2], distro, location))
+	}
+	return pkgs, nil
+}
+
+func readRpmManifest(root string, distro string) ([]Package, error) {
+	content, err := ioutil.ReadFile(filepath.Join(root, rpmManifest))
+	if os.IsNotExist(err) {
+		logrus.Warnf("Found an rpm database but neither the rpm binary nor %s, rpm packages are not inventoried", rpmManifest)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []Package
+	for _, line := range strings.Split(string(content), "\n") {
+		// name, version-release, install time, vendor, epoch, size, arch, ...
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			continue
+		}
+		pkgs = append(pkgs, rpmPackage(fields[0], fields[1], fields[6], distro, rpmManifest))
+	}
+	return pkgs, nil
+}
+
+func rpmPackage(name, version, arch, distro, location string) Package {
+	return Package{
+		Name:      name,
+		Version:   version,
+		Type:      "rpm",
+		Namespace: distro,
+		Arch:      arch,
+		Location:  location,
+	}
+}
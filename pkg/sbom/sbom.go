@@ -0,0 +1,186 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sbom inventories the packages installed in an image filesystem
+// and renders them as an SPDX or CycloneDX document.
+package sbom
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/timing"
+	"github.com/GoogleContainerTools/kaniko/pkg/util"
+)
+
+// Package is a software package found in an image filesystem
+type Package struct {
+	Name    string
+	Version string
+	// Type is the package-url type, e.g. deb or npm
+	Type string
+	// Namespace is the package-url namespace, e.g. the distribution of OS packages
+	Namespace string
+	Arch      string
+	// Location is the database or lockfile the package was found in
+	Location string
+}
+
+// PURL returns the package-url of p
+func (p Package) PURL() string {
+	name := escapePURL(p.Name)
+	if p.Type == "npm" || p.Type == "golang" || p.Type == "composer" {
+		// the scope, module path or vendor are part of the name
+		parts := strings.Split(p.Name, "/")
+		for i := range parts {
+			parts[i] = escapePURL(parts[i])
+		}
+		name = strings.Join(parts, "/")
+	}
+	purl := "pkg:" + p.Type + "/"
+	if p.Namespace != "" {
+		purl += escapePURL(p.Namespace) + "/"
+	}
+	purl += name
+	if p.Version != "" {
+		purl += "@" + escapePURL(p.Version)
+	}
+	if p.Arch != "" {
+		purl += "?arch=" + url.QueryEscape(p.Arch)
+	}
+	return purl
+}
+
+// escapePURL percent-encodes a package-url segment, including the "@"
+// which separates the version
+func escapePURL(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "@", "%40")
+}
+
+// reader returns the packages recorded in the file at path, which is
+// location within the image filesystem
+type reader func(path string, location string, distro string) ([]Package, error)
+
+// osDatabases are the package databases of the supported distributions
+var osDatabases = []struct {
+	location string
+	read     reader
+}{
+	{"/var/lib/dpkg/status", readDpkgStatus},
+	// distroless images have a status file per package
+	{"/var/lib/dpkg/status.d", readDpkgStatusDir},
+	{"/lib/apk/db/installed", readApkInstalled},
+	{"/var/lib/rpm", readRpmDatabase},
+}
+
+// lockfiles are the language package lockfiles by file name
+var lockfiles = map[string]reader{
+	"package-lock.json": readPackageLock,
+	"go.sum":            readGoSum,
+	"requirements.txt":  readRequirements,
+	"Pipfile.lock":      readPipfileLock,
+	"poetry.lock":       readTomlPackages("pypi"),
+	"Cargo.lock":        readTomlPackages("cargo"),
+	"Gemfile.lock":      readGemfileLock,
+	"composer.lock":     readComposerLock,
+}
+
+// Inventory returns the OS and language packages installed in the filesystem at root
+func Inventory(root string) ([]Package, error) {
+	t := timing.Start("SBOM Inventory")
+	defer timing.DefaultRun.Stop(t)
+
+	distro := osRelease(root)
+	var pkgs []Package
+	for _, db := range osDatabases {
+		path := filepath.Join(root, db.location)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		found, err := db.read(path, db.location, distro)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", db.location, err)
+		}
+		pkgs = append(pkgs, found...)
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// files may vanish or be unreadable, they are not part of any lockfile
+			return nil
+		}
+		location := "/" + strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+		if info.IsDir() {
+			if util.IsInIgnoreList(location) || info.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		read, ok := lockfiles[info.Name()]
+		if !ok || !info.Mode().IsRegular() {
+			return nil
+		}
+		found, err := read(path, location, distro)
+		if err != nil {
+			logrus.Warnf("Unable to read packages from %s: %s", location, err)
+			return nil
+		}
+		pkgs = append(pkgs, found...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Version < pkgs[j].Version
+	})
+	return pkgs, nil
+}
+
+var (
+	inventoriesMu sync.Mutex
+	inventories   = map[v1.Hash][]Package{} // protected by inventoriesMu
+)
+
+// Record stores the inventory of the image with the given digest until it is pushed
+func Record(digest v1.Hash, pkgs []Package) {
+	inventoriesMu.Lock()
+	defer inventoriesMu.Unlock()
+	inventories[digest] = pkgs
+}
+
+// Lookup returns the inventory recorded for the image with the given digest
+func Lookup(digest v1.Hash) ([]Package, bool) {
+	inventoriesMu.Lock()
+	defer inventoriesMu.Unlock()
+	pkgs, ok := inventories[digest]
+	return pkgs, ok
+}
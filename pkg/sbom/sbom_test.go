@@ -0,0 +1,188 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sbom
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	root, err := ioutil.TempDir("", "sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"etc/os-release": "NAME=\"Debian GNU/Linux\"\nID=debian\n",
+		"var/lib/dpkg/status": `Package: base-files
+Status: install ok installed
+Architecture: amd64
+Version: 11.1+deb11u1
+Description: Debian base system
+ miscellaneous files
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0
+`,
+		"var/lib/dpkg/status.d/tzdata": "Package: tzdata\nVersion: 2021a-1\nArchitecture: all\n",
+		"app/go.sum": `github.com/pkg/errors v0.9.1 h1:abc=
+github.com/pkg/errors v0.9.1/go.mod h1:def=
+`,
+		"app/requirements.txt":                        "# pinned\nFlask==2.0.1\nrequests>=2.0\n",
+		"app/Cargo.lock":                              "version = 3\n\n[[package]]\nname = \"serde\"\nversion = \"1.0.130\"\n\n[metadata]\n",
+		"app/node_modules/left-pad/package-lock.json": `{"dependencies": {"ignored": {"version": "1.0.0"}}}`,
+		"app/package-lock.json": `{"lockfileVersion": 2, "packages": {
+			"": {"version": "1.0.0"},
+			"node_modules/@scope/pkg": {"version": "2.1.0"},
+			"node_modules/local": {"link": true}
+		}}`,
+		"srv/Gemfile.lock": "GEM\n  remote: https://rubygems.org/\n  specs:\n    rack (2.2.3)\n      foo (>= 1)\n\nPLATFORMS\n  ruby\n",
+	})
+
+	pkgs, err := Inventory(root)
+	expected := []Package{
+		{Name: "serde", Version: "1.0.130", Type: "cargo", Location: "/app/Cargo.lock"},
+		{Name: "base-files", Version: "11.1+deb11u1", Type: "deb", Namespace: "debian", Arch: "amd64", Location: "/var/lib/dpkg/status"},
+		{Name: "tzdata", Version: "2021a-1", Type: "deb", Namespace: "debian", Arch: "all", Location: "/var/lib/dpkg/status.d/tzdata"},
+		{Name: "rack", Version: "2.2.3", Type: "gem", Location: "/srv/Gemfile.lock"},
+		{Name: "github.com/pkg/errors", Version: "v0.9.1", Type: "golang", Location: "/app/go.sum"},
+		{Name: "@scope/pkg", Version: "2.1.0", Type: "npm", Location: "/app/package-lock.json"},
+		{Name: "flask", Version: "2.0.1", Type: "pypi", Location: "/app/requirements.txt"},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, pkgs)
+}
+
+func TestInventoryApk(t *testing.T) {
+	root, err := ioutil.TempDir("", "sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"lib/apk/db/installed": "C:Q1abc=\nP:musl\nV:1.2.2-r3\nA:x86_64\n\nC:Q1def=\nP:busybox\nV:1.33.1-r3\nA:x86_64\n",
+	})
+	pkgs, err := Inventory(root)
+	expected := []Package{
+		{Name: "busybox", Version: "1.33.1-r3", Type: "apk", Namespace: "alpine", Arch: "x86_64", Location: "/lib/apk/db/installed"},
+		{Name: "musl", Version: "1.2.2-r3", Type: "apk", Namespace: "alpine", Arch: "x86_64", Location: "/lib/apk/db/installed"},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, pkgs)
+}
+
+func TestPURL(t *testing.T) {
+	tests := []struct {
+		pkg      Package
+		expected string
+	}{
+		{
+			pkg:      Package{Name: "base-files", Version: "11.1+deb11u1", Type: "deb", Namespace: "debian", Arch: "amd64"},
+			expected: "pkg:deb/debian/base-files@11.1+deb11u1?arch=amd64",
+		},
+		{
+			pkg:      Package{Name: "@scope/pkg", Version: "2.1.0", Type: "npm"},
+			expected: "pkg:npm/%40scope/pkg@2.1.0",
+		},
+		{
+			pkg:      Package{Name: "github.com/pkg/errors", Version: "v0.9.1", Type: "golang"},
+			expected: "pkg:golang/github.com/pkg/errors@v0.9.1",
+		},
+		{
+			pkg:      Package{Name: "flask", Type: "pypi"},
+			expected: "pkg:pypi/flask",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			testutil.CheckDeepEqual(t, test.expected, test.pkg.PURL())
+		})
+	}
+}
+
+func TestDocument(t *testing.T) {
+	currentTimeFunc = func() time.Time { return time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) }
+	defer func() { currentTimeFunc = time.Now }()
+
+	digest := v1.Hash{Algorithm: "sha256", Hex: "deadbeef"}
+	pkgs := []Package{{Name: "musl", Version: "1.2.2-r3", Type: "apk", Namespace: "alpine", Location: "/lib/apk/db/installed"}}
+
+	t.Run("spdx", func(t *testing.T) {
+		b, err := Document(FormatSPDX, "gcr.io/foo/bar", digest, pkgs)
+		testutil.CheckError(t, false, err)
+		var doc spdx
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckDeepEqual(t, "SPDX-2.2", doc.SPDXVersion)
+		testutil.CheckDeepEqual(t, "2021-01-01T00:00:00Z", doc.CreationInfo.Created)
+		testutil.CheckDeepEqual(t, 2, len(doc.Packages))
+		testutil.CheckDeepEqual(t, "pkg:apk/alpine/musl@1.2.2-r3", doc.Packages[1].ExternalRefs[0].ReferenceLocator)
+		testutil.CheckDeepEqual(t, []spdxRelationship{{"SPDXRef-Image", "CONTAINS", "SPDXRef-Package-0"}}, doc.Relationships)
+	})
+
+	t.Run("cyclonedx", func(t *testing.T) {
+		b, err := Document(FormatCycloneDX, "gcr.io/foo/bar", digest, pkgs)
+		testutil.CheckError(t, false, err)
+		var doc cycloneDX
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckDeepEqual(t, "CycloneDX", doc.BOMFormat)
+		testutil.CheckDeepEqual(t, "container", doc.Metadata.Component.Type)
+		testutil.CheckDeepEqual(t, "sha256:deadbeef", doc.Metadata.Component.Version)
+		testutil.CheckDeepEqual(t, "pkg:apk/alpine/musl@1.2.2-r3", doc.Components[0].PURL)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := Document("syft", "gcr.io/foo/bar", digest, pkgs)
+		testutil.CheckError(t, true, err)
+	})
+}
+
+func TestRecordLookup(t *testing.T) {
+	digest := v1.Hash{Algorithm: "sha256", Hex: "cafe"}
+	if _, ok := Lookup(digest); ok {
+		t.Fatal("expected no inventory before it is recorded")
+	}
+	pkgs := []Package{{Name: "musl", Type: "apk"}}
+	Record(digest, pkgs)
+	got, ok := Lookup(digest)
+	testutil.CheckDeepEqual(t, true, ok)
+	testutil.CheckDeepEqual(t, pkgs, got)
+}
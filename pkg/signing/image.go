@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signing
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sirupsen/logrus"
+
+	"github.com/GoogleContainerTools/kaniko/pkg/artifact"
+)
+
+// Append returns the signature image base with the signed payload appended as a layer
+func Append(base v1.Image, payload []byte, signature string) (v1.Image, error) {
+	return artifact.Append(base, payload, PayloadMediaType, map[string]string{SignatureAnnotation: signature})
+}
+
+// VerifyImage checks that the signature image sigs holds a signature by key
+// of a payload for the image with the given digest
+func VerifyImage(sigs v1.Image, key crypto.PublicKey, digest v1.Hash) error {
+	m, err := sigs.Manifest()
+	if err != nil {
+		return err
+	}
+	for _, desc := range m.Layers {
+		signature, ok := desc.Annotations[SignatureAnnotation]
+		if !ok || desc.MediaType != PayloadMediaType {
+			continue
+		}
+		payload, err := layerContent(sigs, desc.Digest)
+		if err != nil {
+			return err
+		}
+		if err := Verify(key, payload, signature); err != nil {
+			logrus.Debugf("Signature in layer %s doesn't match the key: %s", desc.Digest, err)
+			continue
+		}
+		var p Payload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		if p.Critical.Image.DockerManifestDigest != digest.String() {
+			logrus.Debugf("Signature in layer %s is for image %s", desc.Digest, p.Critical.Image.DockerManifestDigest)
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no signature of image %s matches the key", digest)
+}
+
+func layerContent(img v1.Image, digest v1.Hash) ([]byte, error) {
+	l, err := img.LayerByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// IsNotFound returns true if err is the error of the registry for a missing
+// signature image, i.e. an image that was never signed
+func IsNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
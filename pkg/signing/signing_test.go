@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+// writeKeyPair writes key and its public key as PEM files to dir
+func writeKeyPair(t *testing.T, dir string, key crypto.Signer) (string, string) {
+	t.Helper()
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privPath := filepath.Join(dir, "key.pem")
+	pubPath := filepath.Join(dir, "key.pub")
+	if err := ioutil.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return privPath, pubPath
+}
+
+func TestNewPayload(t *testing.T) {
+	digest := v1.Hash{Algorithm: "sha256", Hex: "deadbeef"}
+	payload, err := NewPayload("gcr.io/foo/bar", digest)
+	expected := `{"critical":{"identity":{"docker-reference":"gcr.io/foo/bar"},"image":{"docker-manifest-digest":"sha256:deadbeef"},"type":"cosign container image signature"},"optional":null}`
+	testutil.CheckErrorAndDeepEqual(t, false, err, expected, string(payload))
+	testutil.CheckDeepEqual(t, "sha256-deadbeef.sig", Tag(digest))
+}
+
+func TestSignVerify(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := v1.Hash{Algorithm: "sha256", Hex: "deadbeef"}
+	for name, key := range map[string]crypto.Signer{"ecdsa": ecdsaKey, "ed25519": ed25519Key} {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "signing")
+			testutil.CheckError(t, false, err)
+			defer os.RemoveAll(dir)
+			privPath, pubPath := writeKeyPair(t, dir, key)
+			signer, err := LoadSigner(privPath)
+			testutil.CheckError(t, false, err)
+			pub, err := LoadPublicKey(pubPath)
+			testutil.CheckError(t, false, err)
+
+			payload, err := NewPayload("gcr.io/foo/bar", digest)
+			testutil.CheckError(t, false, err)
+			signature, err := Sign(signer, payload)
+			testutil.CheckError(t, false, err)
+			testutil.CheckError(t, false, Verify(pub, payload, signature))
+			testutil.CheckError(t, true, Verify(pub, append(payload, ' '), signature))
+			testutil.CheckError(t, true, Verify(otherKey.Public(), payload, signature))
+
+			sigs, err := Append(empty.Image, payload, signature)
+			testutil.CheckError(t, false, err)
+			testutil.CheckError(t, false, VerifyImage(sigs, pub, digest))
+			testutil.CheckError(t, true, VerifyImage(sigs, pub, v1.Hash{Algorithm: "sha256", Hex: "cafe"}))
+			testutil.CheckError(t, true, VerifyImage(sigs, otherKey.Public(), digest))
+		})
+	}
+}
+
+func TestLoadSignerEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cosign.key")
+	content := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED COSIGN PRIVATE KEY", Bytes: []byte("secret")})
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadSigner(path)
+	testutil.CheckError(t, true, err)
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	// the key kaniko releases are signed with
+	_, err := LoadPublicKey(filepath.Join("..", "..", "cosign.pub"))
+	testutil.CheckError(t, false, err)
+}
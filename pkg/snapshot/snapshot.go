@@ -22,7 +22,9 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/GoogleContainerTools/kaniko/pkg/filesystem"
@@ -35,11 +37,28 @@ import (
 // For testing
 var snapshotPathPrefix = ""
 
+// The ways a snapshot changes a path
+const (
+	ChangeAdded    = "added"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// Change is a path a snapshot adds, modifies or whites out. Size is the size of
+// regular files, and 0 for anything else.
+type Change struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+}
+
 // Snapshotter holds the root directory from which to take snapshots, and a list of snapshots taken
 type Snapshotter struct {
 	l          *LayeredMap
 	directory  string
 	ignorelist []util.IgnoreListEntry
+	epoch      time.Time
+	changes    []Change
 }
 
 // NewSnapshotter creates a new snapshotter rooted at d
@@ -47,12 +66,23 @@ func NewSnapshotter(l *LayeredMap, d string) *Snapshotter {
 	return &Snapshotter{l: l, directory: d, ignorelist: util.IgnoreList()}
 }
 
+// SetSourceDateEpoch clamps the timestamps of the files in the snapshots taken
+// from now on to epoch. A zero epoch keeps the timestamps as they are.
+func (s *Snapshotter) SetSourceDateEpoch(epoch time.Time) {
+	s.epoch = epoch
+}
+
 // Init initializes a new snapshotter
 func (s *Snapshotter) Init() error {
 	_, _, err := s.scanFullFilesystem()
 	return err
 }
 
+// Changes returns the paths the last snapshot changed, sorted by path
+func (s *Snapshotter) Changes() []Change {
+	return s.changes
+}
+
 // Key returns a string based on the current state of the file system
 func (s *Snapshotter) Key() (string, error) {
 	return s.l.Key()
@@ -68,6 +98,7 @@ func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string,
 	defer f.Close()
 
 	s.l.Snapshot()
+	s.changes = nil
 	if len(files) == 0 {
 		logrus.Info("No files changed in this command, skipping snapshotting.")
 		return "", nil
@@ -82,6 +113,7 @@ func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string,
 	logrus.Debugf("Taking snapshot of files %v", filesToAdd)
 
 	sort.Strings(filesToAdd)
+	changes := s.fileChanges(filesToAdd)
 
 	// Add files to the layered map
 	for _, file := range filesToAdd {
@@ -110,8 +142,10 @@ func (s *Snapshotter) TakeSnapshot(files []string, shdCheckDelete bool) (string,
 	}
 
 	sort.Strings(filesToWhiteout)
+	s.setChanges(changes, filesToWhiteout)
 
 	t := util.NewTar(f)
+	t.ClampTimestamps(s.epoch)
 	defer t.Close()
 	if err := writeToTar(t, filesToAdd, filesToWhiteout); err != nil {
 		return "", err
@@ -128,6 +162,7 @@ func (s *Snapshotter) TakeSnapshotFS() (string, error) {
 	}
 	defer f.Close()
 	t := util.NewTar(f)
+	t.ClampTimestamps(s.epoch)
 	defer t.Close()
 
 	filesToAdd, filesToWhiteOut, err := s.scanFullFilesystem()
@@ -191,6 +226,7 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 
 	sort.Strings(filesToAdd)
 	sort.Strings(filesToWhiteOut)
+	changes := s.fileChanges(filesToAdd)
 
 	// Add files to the layered map
 	for _, file := range filesToAdd {
@@ -198,9 +234,46 @@ func (s *Snapshotter) scanFullFilesystem() ([]string, []string, error) {
 			return nil, nil, fmt.Errorf("unable to add file %s to layered map: %s", file, err)
 		}
 	}
+	s.setChanges(changes, filesToWhiteOut)
 	return filesToAdd, filesToWhiteOut, nil
 }
 
+// fileChanges returns the changes of files, which are about to be added to the layered map:
+// files it already has are modified, the others are added. The root isn't a change.
+func (s *Snapshotter) fileChanges(files []string) []Change {
+	changes := make([]Change, 0, len(files))
+	for _, file := range files {
+		if file == s.directory || !util.HasFilepathPrefix(file, s.directory, false) {
+			continue
+		}
+		c := Change{Path: s.location(file), Type: ChangeAdded}
+		if _, ok := s.l.Get(file); ok && !s.l.GetWhiteout(file) {
+			c.Type = ChangeModified
+		}
+		if fi, err := os.Lstat(file); err == nil && fi.Mode().IsRegular() {
+			c.Size = fi.Size()
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// setChanges records changes and the whiteouts as the changes of the last snapshot
+func (s *Snapshotter) setChanges(changes []Change, whiteouts []string) {
+	for _, path := range whiteouts {
+		changes = append(changes, Change{Path: s.location(path), Type: ChangeDeleted})
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	s.changes = changes
+}
+
+// location returns the path of file in the image
+func (s *Snapshotter) location(file string) string {
+	return filepath.Join("/", strings.TrimPrefix(file, s.directory))
+}
+
 func writeToTar(t util.Tar, files, whiteouts []string) error {
 	timer := timing.Start("Writing tar file")
 	defer timing.DefaultRun.Stop(timer)
@@ -572,3 +572,51 @@ func setUpTest() (string, *Snapshotter, func(), error) {
 
 	return testDir, snapshotter, cleanup, nil
 }
+
+func TestSnapshotChanges(t *testing.T) {
+	testDir, snapshotter, cleanup, err := setUpTest()
+	defer cleanup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFiles := map[string]string{
+		"foo":     "newbaz1",
+		"new/qux": "12345",
+	}
+	if err := testutil.SetupFiles(testDir, newFiles); err != nil {
+		t.Fatalf("Error setting up fs: %s", err)
+	}
+	if err := os.Remove(filepath.Join(testDir, "baz/file")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshotter.TakeSnapshotFS(); err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	changes := map[string]Change{}
+	for _, c := range snapshotter.Changes() {
+		changes[c.Path] = c
+	}
+	for _, expected := range []Change{
+		{Path: "/foo", Type: ChangeModified, Size: 7},
+		{Path: "/new", Type: ChangeAdded},
+		{Path: "/new/qux", Type: ChangeAdded, Size: 5},
+		{Path: "/baz/file", Type: ChangeDeleted},
+	} {
+		testutil.CheckDeepEqual(t, expected, changes[expected.Path])
+	}
+	if _, ok := changes["/bar/bat"]; ok {
+		t.Errorf("expected /bar/bat to be unchanged")
+	}
+
+	// A snapshot of the given files only reports them
+	if err := testutil.SetupFiles(testDir, map[string]string{"bar/bat": "baz22"}); err != nil {
+		t.Fatalf("Error setting up fs: %s", err)
+	}
+	if _, err := snapshotter.TakeSnapshot([]string{filepath.Join(testDir, "bar/bat")}, false); err != nil {
+		t.Fatalf("Error taking snapshot of files: %s", err)
+	}
+	testutil.CheckDeepEqual(t, []Change{
+		{Path: "/bar", Type: ChangeModified},
+		{Path: "/bar/bat", Type: ChangeModified, Size: 5},
+	}, snapshotter.Changes())
+}
@@ -20,6 +20,7 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -88,6 +89,7 @@ type ExtractFunction func(string, *tar.Header, io.Reader) error
 type FSConfig struct {
 	includeWhiteout bool
 	extractFunc     ExtractFunction
+	ctx             context.Context
 }
 
 type FSOpt func(*FSConfig)
@@ -100,10 +102,41 @@ func AddToIgnoreList(entry IgnoreListEntry) {
 	ignorelist = append(ignorelist, entry)
 }
 
+// RemoveFromIgnoreList removes the entry added last with AddToIgnoreList which equals entry
+func RemoveFromIgnoreList(entry IgnoreListEntry) {
+	for i := len(ignorelist) - 1; i >= 0; i-- {
+		if ignorelist[i] == entry {
+			ignorelist = append(ignorelist[:i:i], ignorelist[i+1:]...)
+			return
+		}
+	}
+}
+
 func AddToDefaultIgnoreList(entry IgnoreListEntry) {
 	defaultIgnoreList = append(defaultIgnoreList, entry)
 }
 
+// AddOptionsToDefaultIgnoreList adds the paths opts ignores to the default ignore list
+func AddOptionsToDefaultIgnoreList(opts *config.KanikoOptions) {
+	if opts.IgnoreVarRun {
+		// /var/run is a special case. It's common to mount in /var/run/docker.sock
+		// or something similar which leads to a special mount on the /var/run/docker.sock
+		// file itself, but the directory to exist in the image with no way to tell if it came
+		// from the base image or not.
+		logrus.Trace("Adding /var/run to default ignore list")
+		AddToDefaultIgnoreList(IgnoreListEntry{
+			Path:            "/var/run",
+			PrefixMatchOnly: false,
+		})
+	}
+	for _, p := range opts.IgnorePaths {
+		AddToDefaultIgnoreList(IgnoreListEntry{
+			Path:            p,
+			PrefixMatchOnly: false,
+		})
+	}
+}
+
 func IncludeWhiteout() FSOpt {
 	return func(opts *FSConfig) {
 		opts.includeWhiteout = true
@@ -116,9 +149,16 @@ func ExtractFunc(extractFunc ExtractFunction) FSOpt {
 	}
 }
 
+// WithContext stops the extraction once ctx is done
+func WithContext(ctx context.Context) FSOpt {
+	return func(opts *FSConfig) {
+		opts.ctx = ctx
+	}
+}
+
 // GetFSFromImage extracts the layers of img to root
 // It returns a list of all files extracted
-func GetFSFromImage(root string, img v1.Image, extract ExtractFunction) ([]string, error) {
+func GetFSFromImage(root string, img v1.Image, extract ExtractFunction, opts ...FSOpt) ([]string, error) {
 	if img == nil {
 		return nil, errors.New("image cannot be nil")
 	}
@@ -128,12 +168,12 @@ func GetFSFromImage(root string, img v1.Image, extract ExtractFunction) ([]strin
 		return nil, err
 	}
 
-	return GetFSFromLayers(root, layers, ExtractFunc(extract))
+	return GetFSFromLayers(root, layers, append(opts, ExtractFunc(extract))...)
 }
 
 func GetFSFromLayers(root string, layers []v1.Layer, opts ...FSOpt) ([]string, error) {
 	volumes = []string{}
-	cfg := new(FSConfig)
+	cfg := &FSConfig{ctx: context.Background()}
 	if err := InitIgnoreList(true); err != nil {
 		return nil, errors.Wrap(err, "initializing filesystem ignore list")
 	}
@@ -149,13 +189,14 @@ func GetFSFromLayers(root string, layers []v1.Layer, opts ...FSOpt) ([]string, e
 
 	extractedFiles := []string{}
 	for i, l := range layers {
-		if mediaType, err := l.MediaType(); err == nil {
+		mediaType, err := l.MediaType()
+		if err == nil {
 			logrus.Tracef("Extracting layer %d of media type %s", i, mediaType)
 		} else {
 			logrus.Tracef("Extracting layer %d", i)
 		}
 
-		r, err := l.Uncompressed()
+		r, err := uncompressedLayerOfType(l, mediaType)
 		if err != nil {
 			return nil, err
 		}
@@ -163,6 +204,9 @@ func GetFSFromLayers(root string, layers []v1.Layer, opts ...FSOpt) ([]string, e
 
 		tr := tar.NewReader(r)
 		for {
+			if err := cfg.ctx.Err(); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("extracting tar %d", i))
+			}
 			hdr, err := tr.Next()
 			if err == io.EOF {
 				break
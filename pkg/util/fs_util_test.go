@@ -94,6 +94,26 @@ func Test_AddToIgnoreList(t *testing.T) {
 	}
 }
 
+func Test_RemoveFromIgnoreList(t *testing.T) {
+	t.Cleanup(func() {
+		ignorelist = append([]IgnoreListEntry{}, defaultIgnoreList...)
+	})
+
+	entry := IgnoreListEntry{Path: "/mnt/cache", PrefixMatchOnly: false}
+	AddToIgnoreList(entry)
+	AddToIgnoreList(entry)
+	RemoveFromIgnoreList(entry)
+	if !CheckIgnoreList("/mnt/cache") {
+		t.Errorf("CheckIgnoreList() = %v, want %v", false, true)
+	}
+	RemoveFromIgnoreList(entry)
+	if CheckIgnoreList("/mnt/cache") {
+		t.Errorf("CheckIgnoreList() = %v, want %v", true, false)
+	}
+	// The default ignore list is left alone
+	testutil.CheckDeepEqual(t, defaultIgnoreList, ignorelist)
+}
+
 var tests = []struct {
 	files         map[string]string
 	directory     string
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+const opaqueWhiteout = ".wh..wh..opq"
+
+// squashState tracks the paths decided by the layers above the one being read
+type squashState struct {
+	// seen are the paths written by a layer above, nonDirs those which aren't directories
+	seen    map[string]bool
+	nonDirs map[string]bool
+	// deleted are the paths whited out, opaque the directories made opaque by a layer above
+	deleted map[string]bool
+	opaque  map[string]bool
+	// reopened are the directories deleted and created again, which hide the
+	// contents of the layers below the squashed ones
+	reopened map[string]bool
+}
+
+// hidden returns true if a layer above replaces or deletes p or one of its parents
+func (s *squashState) hidden(p string) bool {
+	if s.deleted[p] {
+		return true
+	}
+	for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+		if s.deleted[dir] || s.opaque[dir] || s.nonDirs[dir] {
+			return true
+		}
+		if dir == "/" {
+			return false
+		}
+	}
+}
+
+// SquashLayers writes a single uncompressed layer to w which has the same effect
+// as applying layers in order. Files replaced or deleted by a later layer are
+// dropped, whiteouts are kept only for the files they may delete from the layers
+// below the squashed ones.
+func SquashLayers(layers []v1.Layer, w io.Writer) error {
+	state := &squashState{
+		seen:     map[string]bool{},
+		nonDirs:  map[string]bool{},
+		deleted:  map[string]bool{},
+		opaque:   map[string]bool{},
+		reopened: map[string]bool{},
+	}
+
+	// Walk the layers from the top to decide which entries are kept
+	keep := make([]map[int]bool, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		keep[i] = map[int]bool{}
+		var deleted, opaque, reopened []string
+		err := forEachEntry(layers[i], func(index int, hdr *tar.Header, _ io.Reader) error {
+			p := filepath.Clean("/" + hdr.Name)
+			dir, base := filepath.Split(p)
+			dir = filepath.Clean(dir)
+			switch {
+			case base == opaqueWhiteout:
+				if state.opaque[dir] || state.hidden(dir) {
+					return nil
+				}
+				opaque = append(opaque, dir)
+			case strings.HasPrefix(base, ".wh."):
+				target := filepath.Join(dir, strings.TrimPrefix(base, ".wh."))
+				if state.seen[target] && !state.nonDirs[target] && !state.opaque[target] {
+					reopened = append(reopened, target)
+					return nil
+				}
+				if state.seen[target] || state.hidden(target) {
+					return nil
+				}
+				deleted = append(deleted, target)
+			default:
+				if state.seen[p] || state.hidden(p) {
+					return nil
+				}
+				state.seen[p] = true
+				if hdr.Typeflag != tar.TypeDir {
+					state.nonDirs[p] = true
+				}
+			}
+			keep[i][index] = true
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("reading layer %d", i))
+		}
+		// whiteouts only hide the files of the layers below them
+		for _, p := range deleted {
+			state.deleted[p] = true
+		}
+		for _, p := range opaque {
+			state.opaque[p] = true
+		}
+		for _, p := range reopened {
+			state.opaque[p] = true
+			state.reopened[p] = true
+		}
+	}
+
+	// Write the kept entries in the order they were added
+	tw := tar.NewWriter(w)
+	for i, l := range layers {
+		err := forEachEntry(l, func(index int, hdr *tar.Header, r io.Reader) error {
+			if !keep[i][index] {
+				return nil
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, r); err != nil {
+				return err
+			}
+			p := filepath.Clean("/" + hdr.Name)
+			if hdr.Typeflag != tar.TypeDir || !state.reopened[p] {
+				return nil
+			}
+			// the directory replaces the one of the layers below, make it opaque
+			return tw.WriteHeader(&tar.Header{
+				Name:     filepath.Join(hdr.Name, opaqueWhiteout),
+				Typeflag: tar.TypeReg,
+				Mode:     0644,
+				ModTime:  hdr.ModTime,
+			})
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("writing layer %d", i))
+		}
+	}
+	return tw.Close()
+}
+
+// forEachEntry calls f with the index, header and content of every entry of the layer
+func forEachEntry(l v1.Layer, f func(int, *tar.Header, io.Reader) error) error {
+	r, err := UncompressedLayer(l)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := f(index, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
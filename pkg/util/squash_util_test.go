@@ -0,0 +1,167 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+// squashEntry is a tar entry, a directory if its name ends with a slash
+type squashEntry struct {
+	name    string
+	content string
+}
+
+func squashLayer(t *testing.T, entries ...squashEntry) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content))}
+		if e.name[len(e.name)-1] == '/' {
+			hdr = &tar.Header{Name: e.name, Typeflag: tar.TypeDir, Mode: 0755}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestSquashLayers(t *testing.T) {
+	tests := []struct {
+		name     string
+		layers   [][]squashEntry
+		expected []squashEntry
+	}{
+		{
+			name: "overwritten files keep the last content",
+			layers: [][]squashEntry{
+				{{"app/", ""}, {"app/a", "1"}, {"app/b", "1"}},
+				{{"app/a", "2"}},
+			},
+			expected: []squashEntry{{"app/", ""}, {"app/b", "1"}, {"app/a", "2"}},
+		},
+		{
+			name: "files deleted by a later layer are dropped with their whiteout",
+			layers: [][]squashEntry{
+				{{"tmp/", ""}, {"tmp/build/", ""}, {"tmp/build/obj", "x"}, {"keep", "k"}},
+				{{"tmp/.wh.build", ""}},
+			},
+			expected: []squashEntry{{"tmp/", ""}, {"keep", "k"}, {"tmp/.wh.build", ""}},
+		},
+		{
+			name: "whiteouts of base image files are kept",
+			layers: [][]squashEntry{
+				{{"etc/.wh.motd", ""}},
+				{{"usr/bin/tool", "t"}},
+			},
+			expected: []squashEntry{{"etc/.wh.motd", ""}, {"usr/bin/tool", "t"}},
+		},
+		{
+			name: "files created again after a whiteout are kept without it",
+			layers: [][]squashEntry{
+				{{"etc/.wh.motd", ""}},
+				{{"etc/motd", "new"}},
+			},
+			expected: []squashEntry{{"etc/motd", "new"}},
+		},
+		{
+			name: "directories created again after a whiteout become opaque",
+			layers: [][]squashEntry{
+				{{"var/cache/", ""}, {"var/cache/old", "o"}},
+				{{"var/.wh.cache", ""}},
+				{{"var/cache/", ""}, {"var/cache/new", "n"}},
+			},
+			expected: []squashEntry{{"var/cache/", ""}, {"var/cache/.wh..wh..opq", ""}, {"var/cache/new", "n"}},
+		},
+		{
+			name: "opaque directories hide the layers below",
+			layers: [][]squashEntry{
+				{{"data/a", "a"}},
+				{{"data/.wh..wh..opq", ""}, {"data/b", "b"}},
+			},
+			expected: []squashEntry{{"data/.wh..wh..opq", ""}, {"data/b", "b"}},
+		},
+		{
+			name: "directories replaced by files hide their contents",
+			layers: [][]squashEntry{
+				{{"opt/", ""}, {"opt/lib", "l"}},
+				{{"opt", "file"}},
+			},
+			expected: []squashEntry{{"opt", "file"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var layers []v1.Layer
+			for _, entries := range test.layers {
+				layers = append(layers, squashLayer(t, entries...))
+			}
+			var buf bytes.Buffer
+			err := SquashLayers(layers, &buf)
+			testutil.CheckError(t, false, err)
+
+			var actual []squashEntry
+			tr := tar.NewReader(&buf)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := ioutil.ReadAll(tr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual = append(actual, squashEntry{hdr.Name, string(content)})
+			}
+			if len(actual) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+			for i := range actual {
+				if actual[i] != test.expected[i] {
+					t.Errorf("expected %v, got %v", test.expected, actual)
+					break
+				}
+			}
+		})
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/pkg/config"
 	"github.com/docker/docker/pkg/archive"
@@ -38,6 +39,7 @@ import (
 type Tar struct {
 	hardlinks map[uint64]string
 	w         *tar.Writer
+	epoch     time.Time
 }
 
 // NewTar will create an instance of Tar that can write files to the writer at f.
@@ -49,6 +51,12 @@ func NewTar(f io.Writer) Tar {
 	}
 }
 
+// ClampTimestamps makes t record the modification time of files newer than epoch
+// as epoch and drop their access and change times, as SOURCE_DATE_EPOCH asks for.
+func (t *Tar) ClampTimestamps(epoch time.Time) {
+	t.epoch = epoch
+}
+
 // Close will close any open streams used by Tar.
 func (t *Tar) Close() {
 	t.w.Close()
@@ -92,6 +100,13 @@ func (t *Tar) AddFileToTar(p string) error {
 	// this makes this layer unnecessarily differ from a cached layer which does contain this information
 	hdr.Uname = ""
 	hdr.Gname = ""
+	if !t.epoch.IsZero() {
+		if hdr.ModTime.After(t.epoch) {
+			hdr.ModTime = t.epoch
+		}
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+	}
 
 	hardlink, linkDst := t.checkHardlink(p, i)
 	if hardlink {
@@ -17,12 +17,15 @@ limitations under the License.
 package util
 
 import (
+	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/GoogleContainerTools/kaniko/testutil"
 )
@@ -101,3 +104,55 @@ func createTar(testdir string, writer io.Writer) error {
 	}
 	return nil
 }
+
+func Test_Tar_ClampTimestamps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	epoch := time.Unix(1600000000, 0)
+	older := epoch.Add(-time.Hour)
+	times := map[string]time.Time{
+		"old": older,
+		"new": epoch.Add(time.Hour),
+	}
+	for name, mtime := range times {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	tw := NewTar(&buf)
+	tw.ClampTimestamps(epoch)
+	for _, name := range []string{"new", "old"} {
+		if err := tw.AddFileToTar(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	actual := map[string]time.Time{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.AccessTime.IsZero() || !hdr.ChangeTime.IsZero() {
+			t.Errorf("expected no access or change time for %s, got %v and %v", hdr.Name, hdr.AccessTime, hdr.ChangeTime)
+		}
+		actual[filepath.Base(hdr.Name)] = hdr.ModTime
+	}
+	testutil.CheckDeepEqual(t, older.Unix(), actual["old"].Unix())
+	testutil.CheckDeepEqual(t, epoch.Unix(), actual["new"].Unix())
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// ZstdLayerMediaType is the media type of zstd compressed layers. Unlike gzip
+// layers, they can only be referenced by OCI manifests.
+const ZstdLayerMediaType types.MediaType = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+// zstdMagic starts every zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// zstdLayer is a layer created from an uncompressed tarball, compressed with zstd
+type zstdLayer struct {
+	path       string
+	compressed []byte
+	digest     v1.Hash
+	diffID     v1.Hash
+}
+
+// ZstdLayerFromFile returns a zstd compressed layer of the tarball at path, at the
+// given compression level from 1 (fastest) to 9 (smallest). The compressed layer
+// is kept in memory, like the layers created with tarball.WithCompressedCaching.
+func ZstdLayerFromFile(path string, level int) (v1.Layer, error) {
+	if level == 0 {
+		level = 1
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, err
+	}
+	diffID, _, err := v1.SHA256(io.TeeReader(f, zw))
+	if err != nil {
+		zw.Close()
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	digest, _, err := v1.SHA256(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdLayer{path: path, compressed: buf.Bytes(), digest: digest, diffID: diffID}, nil
+}
+
+func (l *zstdLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+func (l *zstdLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+func (l *zstdLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.compressed)), nil
+}
+
+func (l *zstdLayer) Uncompressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+func (l *zstdLayer) Size() (int64, error) {
+	return int64(len(l.compressed)), nil
+}
+
+func (l *zstdLayer) MediaType() (types.MediaType, error) {
+	return ZstdLayerMediaType, nil
+}
+
+// UncompressedLayer returns the uncompressed content of l. Unlike l.Uncompressed it
+// decompresses the zstd layers of registries and OCI layouts, which the registry
+// client kaniko is built with reads as gzip layers.
+func UncompressedLayer(l v1.Layer) (io.ReadCloser, error) {
+	mt, err := l.MediaType()
+	if err != nil {
+		return l.Uncompressed()
+	}
+	return uncompressedLayerOfType(l, mt)
+}
+
+// uncompressedLayerOfType is UncompressedLayer for the layer l of media type mt
+func uncompressedLayerOfType(l v1.Layer, mt types.MediaType) (io.ReadCloser, error) {
+	switch l.(type) {
+	case *zstdLayer, *openerZstdLayer:
+		return l.Uncompressed()
+	}
+	if mt == ZstdLayerMediaType {
+		rc, err := l.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser(rc)
+	}
+	return l.Uncompressed()
+}
+
+// zstdReadCloser returns the decompressed content of rc, closing rc when it is closed
+func zstdReadCloser(rc io.ReadCloser) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return readCloser{Reader: zr, Closer: closerFunc(func() error {
+		zr.Close()
+		return rc.Close()
+	})}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// zstdAwareLayer reads the content of a layer with UncompressedLayer
+type zstdAwareLayer struct {
+	v1.Layer
+}
+
+func (l zstdAwareLayer) Uncompressed() (io.ReadCloser, error) {
+	return UncompressedLayer(l.Layer)
+}
+
+// zstdImage is an image whose layers are read with UncompressedLayer
+type zstdImage struct {
+	v1.Image
+}
+
+// ZstdImage returns image, whose layers can be zstd compressed, for functions like
+// mutate.Extract which read the uncompressed content of its layers
+func ZstdImage(image v1.Image) v1.Image {
+	return zstdImage{Image: image}
+}
+
+func (i zstdImage) Layers() ([]v1.Layer, error) {
+	layers, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := make([]v1.Layer, 0, len(layers))
+	for _, l := range layers {
+		wrapped = append(wrapped, zstdAwareLayer{Layer: l})
+	}
+	return wrapped, nil
+}
+
+// openerZstdLayer is a zstd compressed layer whose compressed content is read from opener
+type openerZstdLayer struct {
+	opener tarball.Opener
+	digest v1.Hash
+	diffID v1.Hash
+	size   int64
+}
+
+func (l *openerZstdLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+func (l *openerZstdLayer) DiffID() (v1.Hash, error) {
+	return l.diffID, nil
+}
+
+func (l *openerZstdLayer) Compressed() (io.ReadCloser, error) {
+	return l.opener()
+}
+
+func (l *openerZstdLayer) Uncompressed() (io.ReadCloser, error) {
+	rc, err := l.opener()
+	if err != nil {
+		return nil, err
+	}
+	return zstdReadCloser(rc)
+}
+
+func (l *openerZstdLayer) Size() (int64, error) {
+	return l.size, nil
+}
+
+func (l *openerZstdLayer) MediaType() (types.MediaType, error) {
+	return ZstdLayerMediaType, nil
+}
+
+// ImageFromTarball returns the image in the docker tarball at path. Docker tarballs
+// don't record the media types of their layers, and the registry client kaniko is
+// built with reads all layers of a tarball as gzip layers if the first one is, or
+// as uncompressed layers otherwise. The layers of images with zstd layers are read
+// one by one instead.
+func ImageFromTarball(path string) (v1.Image, error) {
+	image, err := tarball.ImageFromPath(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fileFromTarball(path, "manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var manifest []struct {
+		Layers []string
+	}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, errors.Wrap(err, "parsing manifest.json")
+	}
+	if len(manifest) != 1 {
+		return image, nil
+	}
+
+	cf, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if len(cf.RootFS.DiffIDs) != len(manifest[0].Layers) {
+		return nil, fmt.Errorf("%s has %d layers but %d diff IDs", path, len(manifest[0].Layers), len(cf.RootFS.DiffIDs))
+	}
+	var layers []v1.Layer
+	hasZstd := false
+	for i, name := range manifest[0].Layers {
+		name := name
+		opener := func() (io.ReadCloser, error) {
+			return openFromTarball(path, name)
+		}
+		isZstd, err := startsWithZstdMagic(opener)
+		if err != nil {
+			return nil, err
+		}
+		if !isZstd {
+			l, err := tarball.LayerFromOpener(opener)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, l)
+			continue
+		}
+		rc, err := opener()
+		if err != nil {
+			return nil, err
+		}
+		digest, size, err := v1.SHA256(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, &openerZstdLayer{opener: opener, digest: digest, diffID: cf.RootFS.DiffIDs[i], size: size})
+		hasZstd = true
+	}
+	if !hasZstd {
+		return image, nil
+	}
+
+	// zstd layers can only be referenced by OCI manifests
+	zstdImage, err := mutate.AppendLayers(mutate.MediaType(empty.Image, types.OCIManifestSchema1), layers...)
+	if err != nil {
+		return nil, err
+	}
+	// The config records the diff IDs and the history of the layers
+	return mutate.ConfigFile(zstdImage, cf)
+}
+
+// startsWithZstdMagic returns true if the content opener returns is zstd compressed
+func startsWithZstdMagic(opener tarball.Opener) (bool, error) {
+	rc, err := opener()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	magic := make([]byte, len(zstdMagic))
+	if _, err := io.ReadFull(rc, magic); err != nil {
+		// Content shorter than the magic number isn't zstd compressed
+		return false, nil
+	}
+	return bytes.Equal(magic, zstdMagic), nil
+}
+
+// openFromTarball returns the content of the file name in the tarball at path
+func openFromTarball(path string, name string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			f.Close()
+			return nil, fmt.Errorf("%s not found in %s", name, path)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if hdr.Name == name {
+			return readCloser{Reader: tr, Closer: f}, nil
+		}
+	}
+}
+
+// fileFromTarball returns the content of the file name in the tarball at path
+func fileFromTarball(path string, name string) ([]byte, error) {
+	rc, err := openFromTarball(path, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
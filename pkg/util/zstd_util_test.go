@@ -0,0 +1,201 @@
+/*
+Copyright 2021 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/GoogleContainerTools/kaniko/testutil"
+)
+
+// zstdTestLayer returns a zstd layer holding the file foo
+func zstdTestLayer(t *testing.T, dir string) v1.Layer {
+	t.Helper()
+	f, err := ioutil.TempFile(dir, "layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	content := bytes.Repeat([]byte("meow"), 1024)
+	if err := tw.WriteHeader(&tar.Header{Name: "foo", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	f.Close()
+	layer, err := ZstdLayerFromFile(f.Name(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+// checkTarFile checks that the first file of the tarball rc is name
+func checkTarFile(t *testing.T, rc io.ReadCloser, name string) {
+	t.Helper()
+	defer rc.Close()
+	hdr, err := tar.NewReader(rc).Next()
+	testutil.CheckErrorAndDeepEqual(t, false, err, name, hdr.Name)
+}
+
+// remoteZstdLayer is a zstd layer as the registry client reads it, as a gzip layer
+type remoteZstdLayer struct {
+	v1.Layer
+}
+
+func (l remoteZstdLayer) Uncompressed() (io.ReadCloser, error) {
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestZstdLayerFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	layer := zstdTestLayer(t, dir)
+
+	mt, err := layer.MediaType()
+	testutil.CheckErrorAndDeepEqual(t, false, err, ZstdLayerMediaType, mt)
+	rc, err := layer.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckDeepEqual(t, zstdMagic, compressed[:len(zstdMagic)])
+	digest, size, err := v1.SHA256(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := layer.Digest()
+	testutil.CheckErrorAndDeepEqual(t, false, err, digest, d)
+	s, err := layer.Size()
+	testutil.CheckErrorAndDeepEqual(t, false, err, size, s)
+
+	rc, err = layer.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFile(t, rc, "foo")
+	rc, err = UncompressedLayer(remoteZstdLayer{Layer: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTarFile(t, rc, "foo")
+}
+
+func TestImageFromTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewTag("kaniko/test:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		path := filepath.Join(dir, "gzip.tar")
+		if err := tarball.WriteToFile(path, ref, base); err != nil {
+			t.Fatal(err)
+		}
+		image, err := ImageFromTarball(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := base.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := image.Digest()
+		testutil.CheckErrorAndDeepEqual(t, false, err, expected, d)
+	})
+
+	t.Run("zstd", func(t *testing.T) {
+		zstdLayer := zstdTestLayer(t, dir)
+		img, err := mutate.AppendLayers(mutate.MediaType(base, types.OCIManifestSchema1), zstdLayer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "zstd.tar")
+		if err := tarball.WriteToFile(path, ref, img); err != nil {
+			t.Fatal(err)
+		}
+		image, err := ImageFromTarball(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mt, err := image.MediaType()
+		testutil.CheckErrorAndDeepEqual(t, false, err, types.OCIManifestSchema1, mt)
+		layers, err := image.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckDeepEqual(t, 2, len(layers))
+		baseLayers, err := base.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, expected := range []v1.Layer{baseLayers[0], zstdLayer} {
+			expectedDigest, err := expected.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := layers[i].Digest()
+			testutil.CheckErrorAndDeepEqual(t, false, err, expectedDigest, d)
+		}
+		mt, err = layers[1].MediaType()
+		testutil.CheckErrorAndDeepEqual(t, false, err, ZstdLayerMediaType, mt)
+		rc, err := UncompressedLayer(layers[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTarFile(t, rc, "foo")
+		// The gzip layer is still read as one
+		rc, err = UncompressedLayer(layers[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, err := tar.NewReader(rc).Next(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}